@@ -1,13 +1,16 @@
 package cache
 
 import (
-	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
@@ -19,6 +22,10 @@ const (
 	writeWait      = 10 * time.Second
 	pingPeriod     = 5 * time.Second
 	maxMessageSize = 1024
+	hubChannelSize = 8192
+
+	OverflowDrop     = "drop"
+	OverflowSnapshot = "snapshot"
 )
 
 type BlazeMessage struct {
@@ -33,6 +40,16 @@ type Client struct {
 	hub            *Hub
 	conn           *websocket.Conn
 	cid            string
+	ip             string
+	encoding       string
+	overflow       string
+	userId         string
+	subscriptions  sync.Map
+	collapsed      int32
+	dropped        int32
+	channels       map[string]bool
+	messages       int64
+	messagesAt     int64
 	receive        chan *BlazeMessage
 	hubChannel     chan *EventResponse
 	clientResponse chan []byte
@@ -40,13 +57,20 @@ type Client struct {
 	cancel         context.CancelFunc
 }
 
-func NewClient(ctx context.Context, hub *Hub, conn *websocket.Conn, id string, cancel context.CancelFunc) (*Client, error) {
+func NewClient(ctx context.Context, hub *Hub, conn *websocket.Conn, id, ip, encoding, overflow string, cancel context.CancelFunc) (*Client, error) {
+	if overflow != OverflowSnapshot {
+		overflow = OverflowDrop
+	}
 	client := &Client{
 		hub:            hub,
 		conn:           conn,
 		cid:            id,
+		ip:             ip,
+		encoding:       ParseEncoding(encoding),
+		overflow:       overflow,
+		channels:       make(map[string]bool),
 		receive:        make(chan *BlazeMessage, 64),
-		hubChannel:     make(chan *EventResponse, 81920),
+		hubChannel:     make(chan *EventResponse, hubChannelSize),
 		hubResponse:    make(chan []byte, 1024),
 		clientResponse: make(chan []byte, 64),
 		cancel:         cancel,
@@ -64,12 +88,12 @@ func (client *Client) WritePump(ctx context.Context) error {
 	for {
 		select {
 		case msg := <-client.clientResponse:
-			err := writeGzipToConn(ctx, client.conn, msg)
+			err := writeMessageToConn(ctx, client.conn, client.encoding, msg)
 			if err != nil {
 				return err
 			}
 		case msg := <-client.hubResponse:
-			err := writeGzipToConn(ctx, client.conn, msg)
+			err := writeMessageToConn(ctx, client.conn, client.encoding, msg)
 			if err != nil {
 				return err
 			}
@@ -94,24 +118,16 @@ func (client *Client) loopHubChannel(ctx context.Context) error {
 	for {
 		select {
 		case e := <-client.hubChannel:
-			switch e.Source {
-			case "LIST_PENDING_EVENTS":
-				time.Sleep(100 * time.Millisecond)
-				err := client.sendPendingEvents(ctx, e.Channel)
-				if err != nil {
-					return err
-				}
-			case "EMIT_EVENT":
-				id, _ := uuid.NewV4()
-				data, _ := json.Marshal(BlazeMessage{
-					Id:     id.String(),
-					Action: "EMIT_EVENT",
-					Data:   e.Event,
-				})
-				err := client.pipeHubResponse(ctx, data)
+			if atomic.CompareAndSwapInt32(&client.collapsed, 1, 0) {
+				err := client.resync(ctx)
 				if err != nil {
 					return err
 				}
+				continue
+			}
+			err := client.handleHubChannel(ctx, e)
+			if err != nil {
+				return err
 			}
 		case <-ctx.Done():
 			return ctx.Err()
@@ -119,11 +135,60 @@ func (client *Client) loopHubChannel(ctx context.Context) error {
 	}
 }
 
+func (client *Client) handleHubChannel(ctx context.Context, e *EventResponse) error {
+	switch e.Source {
+	case "LIST_PENDING_EVENTS":
+		time.Sleep(100 * time.Millisecond)
+		return client.sendPendingEvents(ctx, e.Channel)
+	case "LIST_TRADES":
+		return client.sendPendingEvents(ctx, e.Channel)
+	case "REPLAY_EVENTS":
+		return client.replayEvents(ctx, e.Channel, e.Since)
+	case "LIST_TICKERS":
+		return client.sendTickers(ctx, e.Channel)
+	case "EMIT_EVENT":
+		id, _ := uuid.NewV4()
+		data, _ := json.Marshal(BlazeMessage{
+			Id:     id.String(),
+			Action: "EMIT_EVENT",
+			Data:   e.Event,
+		})
+		return client.pipeHubResponse(ctx, data)
+	}
+	return nil
+}
+
+// resync discards the queued events after an overflow, and sends a RESYNC
+// event followed by a fresh snapshot for each subscribed channel.
+func (client *Client) resync(ctx context.Context) error {
+	for len(client.hubChannel) > 0 {
+		<-client.hubChannel
+	}
+	var err error
+	client.subscriptions.Range(func(key, value interface{}) bool {
+		channel, source := key.(string), value.(string)
+		err = client.sendEvents(ctx, []*Event{{
+			Type:      EventTypeResync,
+			Data:      map[string]interface{}{"channel": channel},
+			Timestamp: time.Now().UTC(),
+		}})
+		if err == nil {
+			err = client.handleHubChannel(ctx, &EventResponse{Channel: channel, Source: source})
+		}
+		return err == nil
+	})
+	return err
+}
+
 func (client *Client) sendPendingEvents(ctx context.Context, channel string) error {
 	events, err := ListPendingEvents(ctx, channel)
 	if err != nil {
 		return err
 	}
+	return client.sendEvents(ctx, events)
+}
+
+func (client *Client) sendEvents(ctx context.Context, events []*Event) error {
 	for _, e := range events {
 		id, _ := uuid.NewV4()
 		data, _ := json.Marshal(BlazeMessage{
@@ -131,7 +196,7 @@ func (client *Client) sendPendingEvents(ctx context.Context, channel string) err
 			Action: "EMIT_EVENT",
 			Data:   e,
 		})
-		err = client.pipeHubResponse(ctx, data)
+		err := client.pipeHubResponse(ctx, data)
 		if err != nil {
 			return err
 		}
@@ -139,30 +204,44 @@ func (client *Client) sendPendingEvents(ctx context.Context, channel string) err
 	return nil
 }
 
-func writeGzipToConn(ctx context.Context, conn *websocket.Conn, msg []byte) error {
-	err := conn.SetWriteDeadline(time.Now().Add(writeWait))
+func (client *Client) replayEvents(ctx context.Context, channel string, since int64) error {
+	events, ok, err := ReplayEvents(ctx, channel, since)
 	if err != nil {
 		return err
 	}
-	wsWriter, err := conn.NextWriter(websocket.BinaryMessage)
-	if err != nil {
-		return err
+	if ok {
+		return client.sendEvents(ctx, events)
 	}
-	gzWriter, err := gzip.NewWriterLevel(wsWriter, 3)
+	err = client.sendEvents(ctx, []*Event{{
+		Market:    strings.TrimSuffix(channel, "-ORDER-EVENTS"),
+		Type:      EventTypeResync,
+		Sequence:  fmt.Sprint(since),
+		Timestamp: time.Now().UTC(),
+	}})
 	if err != nil {
 		return err
 	}
-	if _, err := gzWriter.Write(msg); err != nil {
+	return client.sendPendingEvents(ctx, channel)
+}
+
+func (client *Client) sendTickers(ctx context.Context, channel string) error {
+	events, err := TickerSnapshots(ctx, channel)
+	if err != nil {
 		return err
 	}
+	return client.sendEvents(ctx, events)
+}
 
-	if err := gzWriter.Close(); err != nil {
+func writeMessageToConn(ctx context.Context, conn *websocket.Conn, encoding string, msg []byte) error {
+	err := conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err != nil {
 		return err
 	}
-	if err := wsWriter.Close(); err != nil {
+	messageType, data, err := encodeMessage(encoding, msg)
+	if err != nil {
 		return err
 	}
-	return nil
+	return conn.WriteMessage(messageType, data)
 }
 
 func (client *Client) ReadPump(ctx context.Context) error {
@@ -188,7 +267,13 @@ func (client *Client) ReadPump(ctx context.Context) error {
 			log.Printf("EXPECTED CLOSE %s %s\n", client.cid, err.Error())
 			return nil
 		}
-		if messageType != websocket.BinaryMessage {
+		limit, err := client.checkMessageRate(ctx)
+		if err != nil {
+			return err
+		}
+		if limit != "" {
+			err = client.error(ctx, limit)
+		} else if messageType != websocket.BinaryMessage && client.encoding != EncodingJSON {
 			err = client.error(ctx, "message type must be binary")
 		} else {
 			err = client.parseMessage(ctx, wsReader)
@@ -201,6 +286,10 @@ func (client *Client) ReadPump(ctx context.Context) error {
 
 func (client *Client) loopReceiveMessage(ctx context.Context) error {
 	defer client.conn.Close()
+	defer client.releaseLimits(ctx)
+
+	renewTicker := time.NewTicker(limitRenew)
+	defer renewTicker.Stop()
 
 	for {
 		select {
@@ -209,6 +298,11 @@ func (client *Client) loopReceiveMessage(ctx context.Context) error {
 			if err != nil {
 				return err
 			}
+		case <-renewTicker.C:
+			err := client.renewLimits(ctx)
+			if err != nil {
+				log.Println("renew limits", client.cid, err)
+			}
 		case <-ctx.Done():
 			return ctx.Err()
 		}
@@ -218,25 +312,66 @@ func (client *Client) loopReceiveMessage(ctx context.Context) error {
 func (client *Client) handleMessage(ctx context.Context, msg *BlazeMessage) error {
 	var err error
 	market := fmt.Sprint(msg.Params["market"])
+	subscription := strings.TrimPrefix(strings.TrimPrefix(msg.Action, "UN"), "SUBSCRIBE_") + ":" + market
+	subscribed := client.channels[subscription]
+	if strings.HasPrefix(msg.Action, "SUBSCRIBE_") {
+		err = client.acquireSubscription(ctx, subscription)
+		if err != nil {
+			return client.ack(ctx, msg.Action, msg.Id, err)
+		}
+	}
 	switch msg.Action {
 	case "SUBSCRIBE_BOOK":
-		err = client.hub.SubscribePendingEvents(ctx, market, client.cid)
+		since, _ := strconv.ParseInt(fmt.Sprint(msg.Params["since_sequence"]), 10, 64)
+		err = client.hub.SubscribePendingEvents(ctx, market, client.cid, since)
 	case "UNSUBSCRIBE_BOOK":
 		err = client.hub.UnsubscribePendingEvents(ctx, market, client.cid)
 	case "SUBSCRIBE_TICKER":
+		err = client.hub.SubscribeTicker(ctx, market, client.cid)
 	case "UNSUBSCRIBE_TICKER":
+		err = client.hub.UnsubscribeTicker(ctx, market, client.cid)
+	case "SUBSCRIBE_TRADES":
+		err = client.hub.SubscribeTrades(ctx, market, client.cid)
+	case "UNSUBSCRIBE_TRADES":
+		err = client.hub.UnsubscribeTrades(ctx, market, client.cid)
+	case "SUBSCRIBE_L2":
+		err = client.hub.SubscribeLevels(ctx, market, client.cid)
+	case "UNSUBSCRIBE_L2":
+		err = client.hub.UnsubscribeLevels(ctx, market, client.cid)
+	case "AUTHENTICATE":
+		// the user channel is subscribed by the authenticated user, so the
+		// connection can't switch to another user
+		var userId string
+		userId, err = client.hub.authenticate(ctx, fmt.Sprint(msg.Params["token"]))
+		if err == nil && userId == "" {
+			err = errors.New("invalid token")
+		} else if err == nil && client.userId != "" && client.userId != userId {
+			err = errors.New("authenticated as another user")
+		} else if err == nil {
+			client.userId = userId
+		}
+	case "SUBSCRIBE_USER":
+		if client.userId == "" {
+			err = errors.New("unauthenticated")
+		} else {
+			err = client.hub.SubscribeUser(ctx, client.userId, client.cid)
+		}
+	case "UNSUBSCRIBE_USER":
+		if client.userId != "" {
+			err = client.hub.UnsubscribeUser(ctx, client.userId, client.cid)
+		}
+	}
+	if (strings.HasPrefix(msg.Action, "SUBSCRIBE_") && err != nil && !subscribed) || (strings.HasPrefix(msg.Action, "UNSUBSCRIBE_") && err == nil) {
+		if rerr := client.releaseSubscription(ctx, subscription); rerr != nil {
+			log.Println("release subscription", client.cid, rerr)
+		}
 	}
 	return client.ack(ctx, msg.Action, msg.Id, err)
 }
 
 func (client *Client) parseMessage(ctx context.Context, wsReader io.Reader) error {
 	var message BlazeMessage
-	gzReader, err := gzip.NewReader(wsReader)
-	if err != nil {
-		return client.error(ctx, err.Error())
-	}
-	defer gzReader.Close()
-	if err = json.NewDecoder(gzReader).Decode(&message); err != nil {
+	if err := decodeMessage(client.encoding, wsReader, &message); err != nil {
 		return client.error(ctx, err.Error())
 	}
 
@@ -258,6 +393,23 @@ func (client *Client) error(ctx context.Context, err string) error {
 	return client.pipeClientResponse(ctx, data)
 }
 
+// Reject sends the ERROR message to the connection before it's served, and
+// closes it as a policy violation.
+func (client *Client) Reject(ctx context.Context, reason string) error {
+	id, _ := uuid.NewV4()
+	data, _ := json.Marshal(BlazeMessage{
+		Id:     id.String(),
+		Action: "ERROR",
+		Error:  reason,
+	})
+	err := writeMessageToConn(ctx, client.conn, client.encoding, data)
+	if err != nil {
+		return err
+	}
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	return client.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+}
+
 func (client *Client) ack(ctx context.Context, action, id string, err error) error {
 	msg := &BlazeMessage{Action: action, Id: id}
 	if err != nil {
@@ -287,11 +439,20 @@ func (client *Client) pipeHubResponse(ctx context.Context, msg []byte) error {
 	return nil
 }
 
-func (client *Client) pipeHubChannel(ctx context.Context, msg *EventResponse) error {
+// pipeHubChannel never blocks the hub, false is returned if the queue is full.
+func (client *Client) pipeHubChannel(msg *EventResponse) bool {
 	select {
 	case client.hubChannel <- msg:
-	case <-time.After(writeWait):
-		return errors.New("timeout to pipe hub channel")
+		return true
+	default:
+		return false
 	}
-	return nil
+}
+
+func (client *Client) collapse() bool {
+	return atomic.CompareAndSwapInt32(&client.collapsed, 0, 1)
+}
+
+func (client *Client) drop() bool {
+	return atomic.CompareAndSwapInt32(&client.dropped, 0, 1)
 }
@@ -9,14 +9,28 @@ import (
 type contextValueKey int
 
 const (
-	keyRedis contextValueKey = 1
+	keyStore   contextValueKey = 1
+	keyJournal contextValueKey = 2
 )
 
 func SetupRedis(ctx context.Context, client *redis.Client) context.Context {
-	return context.WithValue(ctx, keyRedis, client)
+	return SetupBackend(ctx, NewRedisStore(client))
 }
 
-func Redis(ctx context.Context) *redis.Client {
-	v, _ := ctx.Value(keyRedis).(*redis.Client)
+func SetupBackend(ctx context.Context, store Store) context.Context {
+	return context.WithValue(ctx, keyStore, store)
+}
+
+func Backend(ctx context.Context) Store {
+	v, _ := ctx.Value(keyStore).(Store)
+	return v
+}
+
+func SetupJournal(ctx context.Context, journal EventJournal) context.Context {
+	return context.WithValue(ctx, keyJournal, journal)
+}
+
+func Journal(ctx context.Context) EventJournal {
+	v, _ := ctx.Value(keyJournal).(EventJournal)
 	return v
 }
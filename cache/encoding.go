@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	EncodingGzip    = "gzip"
+	EncodingJSON    = "json"
+	EncodingMsgpack = "msgpack"
+)
+
+// Encodings are also the supported subprotocols, in the preferred order.
+var Encodings = []string{EncodingGzip, EncodingJSON, EncodingMsgpack}
+
+var msgpackHandle = newMsgpackHandle()
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	handle := new(codec.MsgpackHandle)
+	handle.RawToString = true
+	return handle
+}
+
+func ParseEncoding(s string) string {
+	for _, e := range Encodings {
+		if e == s {
+			return e
+		}
+	}
+	return EncodingGzip
+}
+
+// encodeMessage converts the JSON message to the frame of the encoding.
+func encodeMessage(encoding string, msg []byte) (int, []byte, error) {
+	switch encoding {
+	case EncodingJSON:
+		return websocket.TextMessage, msg, nil
+	case EncodingMsgpack:
+		var v interface{}
+		err := json.Unmarshal(msg, &v)
+		if err != nil {
+			return 0, nil, err
+		}
+		var out []byte
+		err = codec.NewEncoderBytes(&out, msgpackHandle).Encode(v)
+		return websocket.BinaryMessage, out, err
+	}
+	var buf bytes.Buffer
+	gzWriter, err := gzip.NewWriterLevel(&buf, 3)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := gzWriter.Write(msg); err != nil {
+		return 0, nil, err
+	}
+	if err := gzWriter.Close(); err != nil {
+		return 0, nil, err
+	}
+	return websocket.BinaryMessage, buf.Bytes(), nil
+}
+
+func decodeMessage(encoding string, r io.Reader, message *BlazeMessage) error {
+	switch encoding {
+	case EncodingJSON:
+		decoder := json.NewDecoder(r)
+		decoder.UseNumber()
+		return decoder.Decode(message)
+	case EncodingMsgpack:
+		return codec.NewDecoder(r, msgpackHandle).Decode(message)
+	}
+	gzReader, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gzReader.Close()
+	decoder := json.NewDecoder(gzReader)
+	decoder.UseNumber()
+	return decoder.Decode(message)
+}
@@ -4,15 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const registerWait = 10 * time.Second
+const (
+	registerWait = 10 * time.Second
+	hubShards    = 16
+)
 
 type Subscription struct {
 	channel string
 	cid     string
+	source  string
+	since   int64
 }
 
 type Member struct {
@@ -24,72 +32,115 @@ type EventResponse struct {
 	Channel string
 	Source  string
 	Event   *Event
+	Since   int64
 }
 
+type Authenticator func(ctx context.Context, token string) (string, error)
+
+// Hub fans out the events to the subscribed clients, the clients are sharded
+// across goroutines and the delivery to a client never blocks its shard. Each
+// event only goes to the shards with subscribers of its channel, and a full
+// shard never blocks the others, its subscribers of the missed channels
+// overflow instead.
 type Hub struct {
+	authenticate Authenticator
+	shards       []*hubShard
+	clients      int64
+	slow         int64
+	dropped      int64
+	collapsed    int64
+}
+
+type hubShard struct {
 	register    chan *Client
 	unregister  chan *Client
 	subscribe   chan *Subscription
 	unsubscribe chan *Subscription
 	response    chan *EventResponse
+	subscribed  sync.Map
+	missed      sync.Map
+	overflowed  int32
 }
 
-func NewHub() *Hub {
-	return &Hub{
-		register:    make(chan *Client),
-		unregister:  make(chan *Client),
-		subscribe:   make(chan *Subscription, 64),
-		unsubscribe: make(chan *Subscription, 64),
-		response:    make(chan *EventResponse, 8192),
+func NewHub(authenticate Authenticator) *Hub {
+	hub := &Hub{authenticate: authenticate}
+	for i := 0; i < hubShards; i++ {
+		hub.shards = append(hub.shards, &hubShard{
+			register:    make(chan *Client),
+			unregister:  make(chan *Client),
+			subscribe:   make(chan *Subscription, 64),
+			unsubscribe: make(chan *Subscription, 64),
+			response:    make(chan *EventResponse, 8192),
+		})
 	}
+	return hub
 }
 
 func (hub *Hub) Run(ctx context.Context) error {
-	go hub.loopPendingEvents(ctx)
+	for _, shard := range hub.shards {
+		go hub.runShard(ctx, shard)
+	}
+	hub.loopPendingEvents(ctx)
+	return nil
+}
+
+func (hub *Hub) Metrics() map[string]int64 {
+	return map[string]int64{
+		"clients":   atomic.LoadInt64(&hub.clients),
+		"slow":      atomic.LoadInt64(&hub.slow),
+		"dropped":   atomic.LoadInt64(&hub.dropped),
+		"collapsed": atomic.LoadInt64(&hub.collapsed),
+	}
+}
+
+func (hub *Hub) runShard(ctx context.Context, shard *hubShard) {
 	members := make(map[string]*Member)
 	channels := make(map[string]map[string]time.Time)
 
 	for {
 		select {
-		case client := <-hub.register:
+		case client := <-shard.register:
 			if _, found := members[client.cid]; !found {
 				members[client.cid] = &Member{client, make(map[string]time.Time)}
+				atomic.AddInt64(&hub.clients, 1)
 			}
-		case client := <-hub.unregister:
+		case client := <-shard.unregister:
 			if member, found := members[client.cid]; found {
 				delete(members, client.cid)
 				for channel, _ := range member.channels {
-					delete(channels[channel], client.cid)
+					shard.leave(channels, channel, client.cid)
 				}
+				atomic.AddInt64(&hub.clients, -1)
 				client.cancel()
 			}
-		case sub := <-hub.subscribe:
-			if _, found := channels[sub.channel]; !found {
-				channels[sub.channel] = make(map[string]time.Time)
-			}
-			if member, found := members[sub.cid]; found {
-				if _, found := member.channels[sub.channel]; found {
-					continue
-				}
-				channels[sub.channel][sub.cid] = time.Now()
-				member.channels[sub.channel] = time.Now()
-				err := member.client.pipeHubChannel(ctx, &EventResponse{
-					Channel: sub.channel,
-					Source:  "LIST_PENDING_EVENTS",
-				})
-				if err != nil {
-					log.Println("hub subscribe", err)
-					member.client.cancel()
-				}
+		case sub := <-shard.subscribe:
+			member := shard.join(members, channels, sub)
+			if member == nil || sub.source == "" {
+				continue
 			}
-		case sub := <-hub.unsubscribe:
+			hub.deliver(member.client, &EventResponse{
+				Channel: sub.channel,
+				Source:  sub.source,
+				Since:   sub.since,
+			})
+		case sub := <-shard.unsubscribe:
 			if member, found := members[sub.cid]; found {
 				delete(member.channels, sub.channel)
+				member.client.subscriptions.Delete(sub.channel)
 			}
-			if channel, found := channels[sub.channel]; found {
-				delete(channel, sub.cid)
+			shard.leave(channels, sub.channel, sub.cid)
+		case resp := <-shard.response:
+			if atomic.CompareAndSwapInt32(&shard.overflowed, 1, 0) {
+				shard.missed.Range(func(key, _ interface{}) bool {
+					shard.missed.Delete(key)
+					for cid, _ := range channels[key.(string)] {
+						if member, found := members[cid]; found {
+							hub.overflow(member.client)
+						}
+					}
+					return true
+				})
 			}
-		case resp := <-hub.response:
 			clients, found := channels[resp.Channel]
 			if !found {
 				continue
@@ -99,19 +150,96 @@ func (hub *Hub) Run(ctx context.Context) error {
 				if !found {
 					continue
 				}
-				err := member.client.pipeHubChannel(ctx, resp)
-				if err != nil {
-					log.Println("hub response", err)
-					member.client.cancel()
-				}
+				hub.deliver(member.client, resp)
 			}
 		}
 	}
 }
 
+// join adds the client to the channel, the member is nil if the client isn't
+// registered in the shard or already in the channel.
+func (shard *hubShard) join(members map[string]*Member, channels map[string]map[string]time.Time, sub *Subscription) *Member {
+	member, found := members[sub.cid]
+	if !found {
+		return nil
+	}
+	if _, found := member.channels[sub.channel]; found {
+		return nil
+	}
+	clients, found := channels[sub.channel]
+	if !found {
+		clients = make(map[string]time.Time)
+		channels[sub.channel] = clients
+	}
+	clients[sub.cid] = time.Now()
+	member.channels[sub.channel] = time.Now()
+	shard.subscribed.Store(sub.channel, true)
+	member.client.subscriptions.Store(sub.channel, sub.source)
+	return member
+}
+
+// leave removes the client from the channel, and the channel from the shard
+// without any client.
+func (shard *hubShard) leave(channels map[string]map[string]time.Time, channel, cid string) {
+	clients, found := channels[channel]
+	if !found {
+		return
+	}
+	delete(clients, cid)
+	if len(clients) == 0 {
+		delete(channels, channel)
+		shard.subscribed.Delete(channel)
+	}
+}
+
+// deliver queues the response to the client, and applies the overflow policy
+// of the client if its queue is full.
+func (hub *Hub) deliver(client *Client, resp *EventResponse) {
+	if client.pipeHubChannel(resp) {
+		return
+	}
+	hub.overflow(client)
+}
+
+// overflow applies the overflow policy of the client, it's counted as slow once
+// until it resyncs or is dropped.
+func (hub *Hub) overflow(client *Client) {
+	if client.overflow == OverflowSnapshot {
+		if client.collapse() {
+			atomic.AddInt64(&hub.slow, 1)
+			atomic.AddInt64(&hub.collapsed, 1)
+		}
+		return
+	}
+	if client.drop() {
+		log.Println("hub drop slow client", client.cid)
+		atomic.AddInt64(&hub.slow, 1)
+		atomic.AddInt64(&hub.dropped, 1)
+		client.cancel()
+	}
+}
+
+func (hub *Hub) broadcast(resp *EventResponse) {
+	for _, shard := range hub.shards {
+		if _, found := shard.subscribed.Load(resp.Channel); !found {
+			continue
+		}
+		select {
+		case shard.response <- resp:
+		default:
+			shard.missed.Store(resp.Channel, true)
+			atomic.StoreInt32(&shard.overflowed, 1)
+		}
+	}
+}
+
+func (hub *Hub) shard(cid string) *hubShard {
+	return hub.shards[crc32.ChecksumIEEE([]byte(cid))%uint32(len(hub.shards))]
+}
+
 func (hub *Hub) Register(ctx context.Context, client *Client) error {
 	select {
-	case hub.register <- client:
+	case hub.shard(client.cid).register <- client:
 	case <-time.After(registerWait):
 		return fmt.Errorf("timeout to register client %s", client.cid)
 	}
@@ -120,16 +248,22 @@ func (hub *Hub) Register(ctx context.Context, client *Client) error {
 
 func (hub *Hub) Unregister(client *Client) error {
 	select {
-	case hub.unregister <- client:
+	case hub.shard(client.cid).unregister <- client:
 	case <-time.After(registerWait):
 		return fmt.Errorf("timeout to unregister client %s", client.cid)
 	}
 	return nil
 }
 
-func (hub *Hub) SubscribePendingEvents(ctx context.Context, market, cid string) error {
+// SubscribePendingEvents subscribes the market events, they are replayed after
+// the since sequence if positive, otherwise starting from the full book.
+func (hub *Hub) SubscribePendingEvents(ctx context.Context, market, cid string, since int64) error {
+	source := "LIST_PENDING_EVENTS"
+	if since > 0 {
+		source = "REPLAY_EVENTS"
+	}
 	select {
-	case hub.subscribe <- &Subscription{market + "-ORDER-EVENTS", cid}:
+	case hub.shard(cid).subscribe <- &Subscription{market + "-ORDER-EVENTS", cid, source, since}:
 	case <-time.After(registerWait):
 		return fmt.Errorf("timeout to subscribe pending events %s %s", market, cid)
 	}
@@ -138,28 +272,111 @@ func (hub *Hub) SubscribePendingEvents(ctx context.Context, market, cid string)
 
 func (hub *Hub) UnsubscribePendingEvents(ctx context.Context, market, cid string) error {
 	select {
-	case hub.unsubscribe <- &Subscription{market + "-ORDER-EVENTS", cid}:
+	case hub.shard(cid).unsubscribe <- &Subscription{market + "-ORDER-EVENTS", cid, "", 0}:
 	case <-time.After(registerWait):
 		return fmt.Errorf("timeout to unsubscribe pending events %s %s", market, cid)
 	}
 	return nil
 }
 
+func (hub *Hub) SubscribeTicker(ctx context.Context, market, cid string) error {
+	select {
+	case hub.shard(cid).subscribe <- &Subscription{market + "-TICKER", cid, "LIST_TICKERS", 0}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to subscribe ticker %s %s", market, cid)
+	}
+	return nil
+}
+
+func (hub *Hub) UnsubscribeTicker(ctx context.Context, market, cid string) error {
+	select {
+	case hub.shard(cid).unsubscribe <- &Subscription{market + "-TICKER", cid, "", 0}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to unsubscribe ticker %s %s", market, cid)
+	}
+	return nil
+}
+
+func (hub *Hub) SubscribeTrades(ctx context.Context, market, cid string) error {
+	select {
+	case hub.shard(cid).subscribe <- &Subscription{market + "-TRADES", cid, "LIST_TRADES", 0}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to subscribe trades %s %s", market, cid)
+	}
+	return nil
+}
+
+func (hub *Hub) UnsubscribeTrades(ctx context.Context, market, cid string) error {
+	select {
+	case hub.shard(cid).unsubscribe <- &Subscription{market + "-TRADES", cid, "", 0}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to unsubscribe trades %s %s", market, cid)
+	}
+	return nil
+}
+
+func (hub *Hub) SubscribeLevels(ctx context.Context, market, cid string) error {
+	select {
+	case hub.shard(cid).subscribe <- &Subscription{market + "-L2", cid, "LIST_PENDING_EVENTS", 0}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to subscribe levels %s %s", market, cid)
+	}
+	return nil
+}
+
+func (hub *Hub) UnsubscribeLevels(ctx context.Context, market, cid string) error {
+	select {
+	case hub.shard(cid).unsubscribe <- &Subscription{market + "-L2", cid, "", 0}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to unsubscribe levels %s %s", market, cid)
+	}
+	return nil
+}
+
+func (hub *Hub) SubscribeUser(ctx context.Context, userId, cid string) error {
+	select {
+	case hub.shard(cid).subscribe <- &Subscription{userId + "-USER", cid, "", 0}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to subscribe user %s %s", userId, cid)
+	}
+	return nil
+}
+
+func (hub *Hub) UnsubscribeUser(ctx context.Context, userId, cid string) error {
+	select {
+	case hub.shard(cid).unsubscribe <- &Subscription{userId + "-USER", cid, "", 0}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to unsubscribe user %s %s", userId, cid)
+	}
+	return nil
+}
+
 func (hub *Hub) loopPendingEvents(ctx context.Context) {
-	pubsub := Redis(ctx).Subscribe("ORDER-EVENTS")
+	messages := Backend(ctx).Subscribe(ctx, "ORDER-EVENTS", "TICKER-EVENTS", "TRADE-EVENTS", "L2-EVENTS", "USER-EVENTS")
 
-	for {
-		msg, err := pubsub.ReceiveMessage()
-		if err != nil {
-			log.Println("loopPendingEvents", err)
-			time.Sleep(300 * time.Millisecond)
-			continue
-		}
+	for msg := range messages {
 		var event Event
-		err = json.Unmarshal([]byte(msg.Payload), &event)
+		err := json.Unmarshal([]byte(msg.Payload), &event)
 		if err != nil {
 			log.Panicln(err)
 		}
-		hub.response <- &EventResponse{event.Market + "-ORDER-EVENTS", "EMIT_EVENT", &event}
+		if msg.Channel == "TRADE-EVENTS" {
+			hub.broadcast(&EventResponse{event.Market + "-TRADES", "EMIT_EVENT", &event, 0})
+			continue
+		}
+		if msg.Channel == "L2-EVENTS" {
+			hub.broadcast(&EventResponse{event.Market + "-L2", "EMIT_EVENT", &event, 0})
+			continue
+		}
+		if msg.Channel == "USER-EVENTS" {
+			hub.broadcast(&EventResponse{event.UserId + "-USER", "EMIT_EVENT", &event, 0})
+			continue
+		}
+		if msg.Channel == "TICKER-EVENTS" {
+			hub.broadcast(&EventResponse{event.Market + "-TICKER", "EMIT_EVENT", &event, 0})
+			hub.broadcast(&EventResponse{tickerAllMarkets + "-TICKER", "EMIT_EVENT", &event, 0})
+			continue
+		}
+		hub.broadcast(&EventResponse{event.Market + "-ORDER-EVENTS", "EMIT_EVENT", &event, 0})
 	}
 }
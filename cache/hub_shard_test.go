@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHubBroadcast(t *testing.T) {
+	assert := assert.New(t)
+	hub := NewHub(nil)
+	shard := hub.shards[0]
+	shard.subscribed.Store("market-TRADES", true)
+
+	hub.broadcast(&EventResponse{Channel: "market-L2"})
+	hub.broadcast(&EventResponse{Channel: "market-TRADES"})
+	for i, s := range hub.shards {
+		if i == 0 {
+			assert.Len(s.response, 1)
+		} else {
+			assert.Len(s.response, 0)
+		}
+	}
+
+	for len(shard.response) < cap(shard.response) {
+		shard.response <- &EventResponse{Channel: "market-TRADES"}
+	}
+	hub.broadcast(&EventResponse{Channel: "market-TRADES"})
+	_, missed := shard.missed.Load("market-TRADES")
+	assert.True(missed)
+	assert.Equal(int32(1), shard.overflowed)
+}
+
+func TestHubShardChannels(t *testing.T) {
+	assert := assert.New(t)
+	shard := NewHub(nil).shards[0]
+	members := map[string]*Member{"cid": {&Client{cid: "cid"}, make(map[string]time.Time)}}
+	channels := make(map[string]map[string]time.Time)
+
+	// the unregistered client never creates the channel
+	assert.Nil(shard.join(members, channels, &Subscription{channel: "market-TRADES", cid: "unknown"}))
+	assert.Len(channels, 0)
+	_, found := shard.subscribed.Load("market-TRADES")
+	assert.False(found)
+
+	member := shard.join(members, channels, &Subscription{channel: "market-TRADES", cid: "cid", source: "SOURCE"})
+	assert.Equal(members["cid"], member)
+	assert.Nil(shard.join(members, channels, &Subscription{channel: "market-TRADES", cid: "cid"}))
+	assert.Len(channels["market-TRADES"], 1)
+	source, _ := member.client.subscriptions.Load("market-TRADES")
+	assert.Equal("SOURCE", source)
+	_, found = shard.subscribed.Load("market-TRADES")
+	assert.True(found)
+
+	// the channel without any client is removed
+	shard.leave(channels, "market-TRADES", "cid")
+	assert.Len(channels, 0)
+	_, found = shard.subscribed.Load("market-TRADES")
+	assert.False(found)
+}
@@ -0,0 +1,335 @@
+package cache_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/cache"
+	"github.com/MixinNetwork/ocean.one/engine"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHubBookEvents(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(cache.SetupBackend(context.Background(), cache.NewMemoryStore()))
+	defer cancel()
+
+	hub := cache.NewHub(nil)
+	go hub.Run(ctx)
+	book := engine.NewBook(ctx, "market", 0, func(taker, maker *engine.Order, amount number.Integer) (string, time.Time) {
+		return "TRADE-ID", time.Now()
+	}, func(order *engine.Order) {}, nil)
+	go book.Run(ctx)
+
+	conn := testDialHub(ctx, t, hub)
+	err := conn.WriteJSON(map[string]interface{}{"id": "1", "action": "SUBSCRIBE_BOOK", "params": map[string]interface{}{"market": "market"}})
+	assert.Nil(err)
+	msg := testReadMessage(t, conn)
+	assert.Equal("SUBSCRIBE_BOOK", msg.Action)
+	assert.Equal("", msg.Error)
+	e := testReadEvent(t, conn)
+	assert.Equal("BOOK-T0", e.Type)
+	assert.Equal("0", e.Sequence)
+
+	id, _ := uuid.NewV4()
+	book.AttachOrderEvent(ctx, &engine.Order{
+		Id:              id.String(),
+		Side:            engine.PageSideAsk,
+		Type:            engine.OrderTypeLimit,
+		Price:           number.NewInteger(10000, 2),
+		RemainingAmount: number.NewInteger(300, 1),
+		FilledAmount:    number.NewInteger(0, 1),
+		RemainingFunds:  number.NewInteger(0, 3),
+		FilledFunds:     number.NewInteger(0, 3),
+	}, engine.OrderActionCreate)
+	e = testReadEvent(t, conn)
+	assert.Equal(cache.EventTypeOrderOpen, e.Type)
+	assert.Equal("1", e.Sequence)
+	assert.Equal("ASK", e.Data["side"])
+
+	id, _ = uuid.NewV4()
+	book.AttachOrderEvent(ctx, &engine.Order{
+		Id:              id.String(),
+		Side:            engine.PageSideBid,
+		Type:            engine.OrderTypeLimit,
+		Price:           number.NewInteger(10000, 2),
+		RemainingAmount: number.NewInteger(0, 1),
+		FilledAmount:    number.NewInteger(0, 1),
+		RemainingFunds:  number.NewInteger(1000000, 3),
+		FilledFunds:     number.NewInteger(0, 3),
+	}, engine.OrderActionCreate)
+	e = testReadEvent(t, conn)
+	assert.Equal(cache.EventTypeOrderMatch, e.Type)
+	assert.Equal("2", e.Sequence)
+	assert.Equal("10", e.Data["amount"])
+}
+
+func TestHubLevels(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(cache.SetupBackend(context.Background(), cache.NewMemoryStore()))
+	defer cancel()
+
+	hub := cache.NewHub(nil)
+	go hub.Run(ctx)
+	book := engine.NewBook(ctx, "market", 0, func(taker, maker *engine.Order, amount number.Integer) (string, time.Time) {
+		return "TRADE-ID", time.Now()
+	}, func(order *engine.Order) {}, nil)
+	go book.Run(ctx)
+
+	conn := testDialHub(ctx, t, hub)
+	err := conn.WriteJSON(map[string]interface{}{"id": "1", "action": "SUBSCRIBE_L2", "params": map[string]interface{}{"market": "market"}})
+	assert.Nil(err)
+	msg := testReadMessage(t, conn)
+	assert.Equal("SUBSCRIBE_L2", msg.Action)
+	assert.Equal("", msg.Error)
+	e := testReadEvent(t, conn)
+	assert.Equal(cache.EventTypeLevelsSnapshot, e.Type)
+
+	// more updates than the limit, so a snapshot is sent among them
+	count := cache.LevelsUpdatesLimit + 10
+	for i := 0; i < count; i++ {
+		id, _ := uuid.NewV4()
+		book.AttachOrderEvent(ctx, &engine.Order{
+			Id:              id.String(),
+			Side:            engine.PageSideAsk,
+			Type:            engine.OrderTypeLimit,
+			Price:           number.NewInteger(int64(100+i%5), 0),
+			RemainingAmount: number.NewInteger(1, 0),
+			FilledAmount:    number.NewInteger(0, 0),
+			RemainingFunds:  number.NewInteger(0, 0),
+			FilledFunds:     number.NewInteger(0, 0),
+		}, engine.OrderActionCreate)
+	}
+
+	levels, snapshots := make(map[string]string), 0
+	for e.Sequence != fmt.Sprint(count) {
+		e = testReadEvent(t, conn)
+		if e.Type == cache.EventTypeLevelsSnapshot {
+			levels, snapshots = make(map[string]string), snapshots+1
+		}
+		for _, l := range e.Data["asks"].([]interface{}) {
+			level := l.(map[string]interface{})
+			price := number.FromString(fmt.Sprint(level["price"])).Persist()
+			if amount := number.FromString(fmt.Sprint(level["amount"])); amount.Sign() > 0 {
+				levels[price] = amount.Persist()
+			} else {
+				delete(levels, price)
+			}
+		}
+	}
+	assert.Equal(1, snapshots)
+	assert.Equal(map[string]string{"100": "202", "101": "202", "102": "202", "103": "202", "104": "202"}, levels)
+}
+
+func TestHubTicker(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(cache.SetupBackend(context.Background(), cache.NewMemoryStore()))
+	defer cancel()
+
+	hub := cache.NewHub(nil)
+	go hub.Run(ctx)
+	queue := cache.NewQueue(ctx, "market")
+	go queue.Loop(ctx)
+	best := func(ask, bid string) map[string]interface{} {
+		return map[string]interface{}{
+			"asks": []map[string]interface{}{{"price": ask, "amount": "1"}},
+			"bids": []map[string]interface{}{{"price": bid, "amount": "1"}},
+		}
+	}
+	queue.AttachEvent(ctx, "BOOK-T1", 1, best("10", "9"))
+	for {
+		events, err := cache.TickerSnapshots(ctx, "market-TICKER")
+		assert.Nil(err)
+		if len(events) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the last ticker is sent on subscription, then the changes are pushed
+	conn := testDialHub(ctx, t, hub)
+	err := conn.WriteJSON(map[string]interface{}{"id": "1", "action": "SUBSCRIBE_TICKER", "params": map[string]interface{}{"market": "market"}})
+	assert.Nil(err)
+	msg := testReadMessage(t, conn)
+	assert.Equal("SUBSCRIBE_TICKER", msg.Action)
+	assert.Equal("", msg.Error)
+	e := testReadEvent(t, conn)
+	assert.Equal(cache.EventTypeTicker, e.Type)
+	assert.Equal("10", e.Data["ask"])
+	assert.Equal("9", e.Data["bid"])
+
+	queue.AttachEvent(ctx, "BOOK-T1", 2, best("10", "9"))
+	queue.AttachEvent(ctx, "BOOK-T1", 3, best("11", "9"))
+	e = testReadEvent(t, conn)
+	assert.Equal(cache.EventTypeTicker, e.Type)
+	assert.Equal("3", e.Sequence)
+	assert.Equal("11", e.Data["ask"])
+	assert.Equal("0", e.Data["volume"])
+}
+
+func TestHubReplayEvents(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(cache.SetupBackend(context.Background(), cache.NewMemoryStore()))
+	defer cancel()
+
+	events, ok, err := cache.ReplayEvents(ctx, "market-ORDER-EVENTS", 4)
+	assert.Nil(err)
+	assert.False(ok)
+	assert.Len(events, 0)
+
+	push := func(key string, sequence int64, typ string) {
+		data, _ := json.Marshal(&cache.Event{Market: "market", Type: typ, Sequence: fmt.Sprint(sequence), Timestamp: time.Now()})
+		err := cache.Backend(ctx).Push(key, 0, string(data))
+		assert.Nil(err)
+	}
+	for sequence := int64(5); sequence <= 7; sequence++ {
+		push("market-ORDER-JOURNAL", sequence, cache.EventTypeOrderOpen)
+	}
+	push("market-ORDER-EVENTS", 7, "BOOK-T0")
+
+	for since, count := range map[int64]int{4: 3, 6: 1, 7: 0} {
+		events, ok, err = cache.ReplayEvents(ctx, "market-ORDER-EVENTS", since)
+		assert.Nil(err)
+		assert.True(ok)
+		assert.Len(events, count)
+	}
+	for _, since := range []int64{3, 8} {
+		events, ok, err = cache.ReplayEvents(ctx, "market-ORDER-EVENTS", since)
+		assert.Nil(err)
+		assert.False(ok)
+		assert.Len(events, 0)
+	}
+
+	hub := cache.NewHub(nil)
+	go hub.Run(ctx)
+	conn := testDialHub(ctx, t, hub)
+	subscribe := func(since int64) {
+		err := conn.WriteJSON(map[string]interface{}{"id": fmt.Sprint(since), "action": "SUBSCRIBE_BOOK", "params": map[string]interface{}{"market": "market", "since_sequence": since}})
+		assert.Nil(err)
+		msg := testReadMessage(t, conn)
+		assert.Equal("SUBSCRIBE_BOOK", msg.Action)
+		assert.Equal("", msg.Error)
+	}
+	subscribe(5)
+	e := testReadEvent(t, conn)
+	assert.Equal("6", e.Sequence)
+	e = testReadEvent(t, conn)
+	assert.Equal("7", e.Sequence)
+
+	// the journal is newer than the sequence, so the book is resynced from the
+	// full snapshot
+	err = conn.WriteJSON(map[string]interface{}{"id": "unsubscribe", "action": "UNSUBSCRIBE_BOOK", "params": map[string]interface{}{"market": "market"}})
+	assert.Nil(err)
+	assert.Equal("UNSUBSCRIBE_BOOK", testReadMessage(t, conn).Action)
+	subscribe(3)
+	e = testReadEvent(t, conn)
+	assert.Equal(cache.EventTypeResync, e.Type)
+	assert.Equal("3", e.Sequence)
+	e = testReadEvent(t, conn)
+	assert.Equal("BOOK-T0", e.Type)
+	assert.Equal("7", e.Sequence)
+}
+
+func TestHubAuthenticate(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(cache.SetupBackend(context.Background(), cache.NewMemoryStore()))
+	defer cancel()
+
+	hub := cache.NewHub(func(ctx context.Context, token string) (string, error) {
+		if token == "alice" || token == "bob" {
+			return token, nil
+		}
+		return "", nil
+	})
+	go hub.Run(ctx)
+	conn := testDialHub(ctx, t, hub)
+
+	request := func(action, token string) string {
+		err := conn.WriteJSON(map[string]interface{}{"id": action, "action": action, "params": map[string]interface{}{"token": token}})
+		assert.Nil(err)
+		for {
+			msg := testReadMessage(t, conn)
+			if msg.Action == action {
+				return msg.Error
+			}
+		}
+	}
+	assert.Equal("unauthenticated", request("SUBSCRIBE_USER", ""))
+	assert.Equal("", request("AUTHENTICATE", "alice"))
+	assert.Equal("", request("SUBSCRIBE_USER", ""))
+
+	// the user channel of alice is never kept for another user
+	assert.Equal("authenticated as another user", request("AUTHENTICATE", "bob"))
+	assert.Equal("invalid token", request("AUTHENTICATE", "invalid"))
+	assert.Equal("", request("AUTHENTICATE", "alice"))
+	assert.Equal("", request("UNSUBSCRIBE_USER", ""))
+}
+
+type testMessage struct {
+	Id     string          `json:"id"`
+	Action string          `json:"action"`
+	Data   json.RawMessage `json:"data"`
+	Error  string          `json:"error"`
+}
+
+func testReadMessage(t *testing.T, conn *websocket.Conn) *testMessage {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg testMessage
+	err := conn.ReadJSON(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &msg
+}
+
+func testReadEvent(t *testing.T, conn *websocket.Conn) *cache.Event {
+	for {
+		msg := testReadMessage(t, conn)
+		if msg.Action != "EMIT_EVENT" {
+			continue
+		}
+		var e cache.Event
+		err := json.Unmarshal(msg.Data, &e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Type != "HEARTBEAT" {
+			return &e
+		}
+	}
+}
+
+func testDialHub(ctx context.Context, t *testing.T, hub *cache.Hub) *websocket.Conn {
+	upgrader := &websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		id, _ := uuid.NewV4()
+		cctx, ccancel := context.WithCancel(ctx)
+		client, _ := cache.NewClient(cctx, hub, conn, id.String(), "127.0.0.1", cache.EncodingJSON, "", ccancel)
+		hub.Register(cctx, client)
+		defer hub.Unregister(client)
+		go client.WritePump(cctx)
+		client.ReadPump(cctx)
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MixinNetwork/ocean.one/client"
+	"github.com/MixinNetwork/ocean.one/config"
+)
+
+const (
+	EventTypeResync = "RESYNC"
+
+	journalSize             = 4096
+	journalBatch            = 256
+	journalCompactCheck     = time.Minute
+	journalSnapshotInterval = time.Hour
+	journalCompactInterval  = 24 * time.Hour
+)
+
+// EventJournal is the durable history of the order events of all markets,
+// with a BOOK-T0 snapshot every hour to rebuild the book at any sequence.
+type EventJournal interface {
+	// Append writes the events in the sequence order of each market.
+	Append(ctx context.Context, events ...*Event) error
+	// Range reads the events with the sequence between from and to, both
+	// inclusive, at most limit events are returned unless limit is 0.
+	Range(ctx context.Context, market string, from, to int64, limit int) ([]*Event, error)
+	// Snapshot reads the last BOOK-T0 event at or before the sequence.
+	Snapshot(ctx context.Context, market string, sequence int64) (*Event, error)
+	// Last reads the sequence of the last event of the market, 0 if none.
+	Last(ctx context.Context, market string) (int64, error)
+	// Compact removes the events before the last snapshot created before the
+	// time, so the book can still be rebuilt from the time on.
+	Compact(ctx context.Context, market string, before time.Time) error
+}
+
+// ReplayEvents reads the journaled events of the channel after the sequence,
+// false is returned if the events since the sequence are no longer available
+// and the client must resync from a fresh snapshot.
+func ReplayEvents(ctx context.Context, channel string, since int64) ([]*Event, bool, error) {
+	market := strings.TrimSuffix(channel, "-ORDER-EVENTS")
+	journal, err := ListPendingEvents(ctx, market+"-ORDER-JOURNAL")
+	if err != nil || len(journal) == 0 {
+		return nil, false, err
+	}
+	first, _ := strconv.ParseInt(journal[0].Sequence, 10, 64)
+	last, _ := strconv.ParseInt(journal[len(journal)-1].Sequence, 10, 64)
+	if since < first-1 || since > last {
+		return nil, false, nil
+	}
+	var events []*Event
+	for _, e := range journal {
+		if s, _ := strconv.ParseInt(e.Sequence, 10, 64); s > since {
+			events = append(events, e)
+		}
+	}
+	return events, true, nil
+}
+
+// LastJournaled reads the sequence of the last event of the market in the
+// durable journal, which is never behind the published events.
+func LastJournaled(ctx context.Context, market string) (int64, error) {
+	journal := Journal(ctx)
+	if journal == nil {
+		return 0, errors.New("event journal not configured")
+	}
+	return journal.Last(ctx, market)
+}
+
+// RebuildBook applies the journaled events up to the sequence on the last
+// snapshot before it, nil is returned if no snapshot is available.
+func RebuildBook(ctx context.Context, market string, sequence int64) (*client.Book, error) {
+	journal := Journal(ctx)
+	if journal == nil {
+		return nil, errors.New("event journal not configured")
+	}
+	snapshot, err := journal.Snapshot(ctx, market, sequence)
+	if err != nil || snapshot == nil {
+		return nil, err
+	}
+	from, _ := strconv.ParseInt(snapshot.Sequence, 10, 64)
+	events, err := journal.Range(ctx, market, from+1, sequence, 0)
+	if err != nil {
+		return nil, err
+	}
+	book := client.NewBook(market)
+	for _, e := range append([]*Event{snapshot}, events...) {
+		err = book.Apply(&client.Event{
+			Market:    e.Market,
+			Type:      e.Type,
+			Sequence:  e.Sequence,
+			Data:      e.Data,
+			Timestamp: e.Timestamp,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return book, nil
+}
+
+// journalEvents appends the order events and the hourly snapshot to the durable
+// journal, before any of them is published, so a crash never loses a published
+// event from the journal.
+func (queue *Queue) journalEvents(ctx context.Context, events []*Event) error {
+	if Journal(ctx) == nil {
+		return nil
+	}
+	var journal []*Event
+	var snapshotAt time.Time
+	for _, e := range events {
+		if e.UserId != "" {
+			continue
+		}
+		switch e.Type {
+		case EventTypeOrderOpen, EventTypeOrderMatch, EventTypeOrderCancel:
+		case "BOOK-T0":
+			if time.Since(queue.journalAt) < journalSnapshotInterval || !snapshotAt.IsZero() {
+				continue
+			}
+			snapshotAt = time.Now()
+		default:
+			continue
+		}
+		journal = append(journal, e)
+	}
+	if len(journal) == 0 {
+		return nil
+	}
+	err := Journal(ctx).Append(ctx, journal...)
+	if err != nil {
+		return err
+	}
+	if !snapshotAt.IsZero() {
+		queue.journalAt = snapshotAt
+	}
+	return nil
+}
+
+func (queue *Queue) compactJournal(ctx context.Context) {
+	if Journal(ctx) == nil || time.Since(queue.compactAt) < journalCompactInterval {
+		return
+	}
+	queue.compactAt = time.Now()
+	go func() {
+		before := time.Now().Add(-time.Duration(config.EventJournalRetentionDays) * 24 * time.Hour)
+		err := Journal(ctx).Compact(ctx, queue.market, before)
+		if err != nil {
+			log.Println("cache queue journal compact error", queue.market, err)
+		}
+	}()
+}
@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"context"
+)
+
+const (
+	EventTypeLevelsSnapshot = "L2-SNAPSHOT"
+	EventTypeLevelsUpdate   = "L2-UPDATE"
+
+	// LevelsUpdatesLimit is the most updates after a snapshot, the engine sends
+	// a new snapshot instead of more updates.
+	LevelsUpdatesLimit = 1000
+)
+
+// handleLevelEvent keeps the depth limited snapshot and the following price
+// level updates of the market, the sequence is shared with the last order
+// event, so updates after the snapshot sequence should be applied. A snapshot
+// is published as the updates, it includes the pending changes which are not
+// sent as an update, so the subscribers replace their levels with it.
+func (queue *Queue) handleLevelEvent(ctx context.Context, e *Event, data []byte) error {
+	key := queue.market + "-L2"
+	var err error
+	if e.Type == EventTypeLevelsSnapshot {
+		err = Backend(ctx).Replace(key, string(data))
+	} else {
+		err = Backend(ctx).Push(key, LevelsUpdatesLimit+1, string(data))
+	}
+	if err != nil {
+		return err
+	}
+	return Backend(ctx).Publish("L2-EVENTS", string(data))
+}
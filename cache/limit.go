@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/MixinNetwork/ocean.one/config"
+)
+
+const (
+	limitLease            = time.Minute
+	limitRenew            = limitLease / 3
+	limitConnectionsKey   = "LIMIT-CONNECTIONS-%s"
+	limitSubscriptionsKey = "LIMIT-SUBSCRIPTIONS-%s"
+	limitMessagesKey      = "LIMIT-MESSAGES-%s-%d"
+	limitRequestsKey      = "LIMIT-REQUESTS-%s-%d"
+)
+
+// AcquireConnection takes a connection slot of the IP, the slots are shared by
+// all nodes and expire if not renewed by the client, e.g. the node crashed.
+func AcquireConnection(ctx context.Context, ip, cid string) (bool, error) {
+	return Backend(ctx).Acquire(fmt.Sprintf(limitConnectionsKey, ip), cid, config.LimitConnectionsPerIP, limitLease)
+}
+
+func ReleaseConnection(ctx context.Context, ip, cid string) error {
+	return Backend(ctx).Release(fmt.Sprintf(limitConnectionsKey, ip), cid)
+}
+
+// CheckRequestRate counts the HTTP request in the current second of the IP.
+func CheckRequestRate(ctx context.Context, ip string) (bool, error) {
+	return checkRate(ctx, fmt.Sprintf(limitRequestsKey, ip, time.Now().Unix()), config.LimitRequestsPerSecondIP)
+}
+
+func checkRate(ctx context.Context, key string, limit int64) (bool, error) {
+	count, err := Backend(ctx).Incr(key, 2*time.Second)
+	if err != nil {
+		return false, err
+	}
+	return count <= limit, nil
+}
+
+// checkMessageRate returns the violated limit of the inbound message, if any,
+// it's only called by the read loop of the client.
+func (client *Client) checkMessageRate(ctx context.Context) (string, error) {
+	now := time.Now().Unix()
+	if client.messagesAt != now {
+		client.messagesAt, client.messages = now, 0
+	}
+	client.messages = client.messages + 1
+	if client.messages > config.LimitMessagesPerSecondConnection {
+		return fmt.Sprintf("rate limit exceeded: %d messages per second per connection", config.LimitMessagesPerSecondConnection), nil
+	}
+	ok, err := checkRate(ctx, fmt.Sprintf(limitMessagesKey, client.ip, now), config.LimitMessagesPerSecondIP)
+	if err != nil || ok {
+		return "", err
+	}
+	return fmt.Sprintf("rate limit exceeded: %d messages per second per IP", config.LimitMessagesPerSecondIP), nil
+}
+
+// acquireSubscription checks the subscription limits of the connection and
+// the IP, the subscriptions are only changed by the receive loop of the client.
+func (client *Client) acquireSubscription(ctx context.Context, subscription string) error {
+	if client.channels[subscription] {
+		return nil
+	}
+	if len(client.channels) >= config.LimitSubscriptionsPerConnection {
+		return fmt.Errorf("subscription limit exceeded: %d per connection", config.LimitSubscriptionsPerConnection)
+	}
+	key := fmt.Sprintf(limitSubscriptionsKey, client.ip)
+	ok, err := Backend(ctx).Acquire(key, client.cid+":"+subscription, config.LimitSubscriptionsPerIP, limitLease)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("subscription limit exceeded: %d per IP", config.LimitSubscriptionsPerIP)
+	}
+	client.channels[subscription] = true
+	return nil
+}
+
+func (client *Client) releaseSubscription(ctx context.Context, subscription string) error {
+	if !client.channels[subscription] {
+		return nil
+	}
+	delete(client.channels, subscription)
+	return Backend(ctx).Release(fmt.Sprintf(limitSubscriptionsKey, client.ip), client.cid+":"+subscription)
+}
+
+func (client *Client) renewLimits(ctx context.Context) error {
+	err := Backend(ctx).Renew(fmt.Sprintf(limitConnectionsKey, client.ip), limitLease, client.cid)
+	if err != nil {
+		return err
+	}
+	members := make([]string, 0, len(client.channels))
+	for subscription := range client.channels {
+		members = append(members, client.cid+":"+subscription)
+	}
+	return Backend(ctx).Renew(fmt.Sprintf(limitSubscriptionsKey, client.ip), limitLease, members...)
+}
+
+func (client *Client) releaseLimits(ctx context.Context) error {
+	for subscription := range client.channels {
+		err := client.releaseSubscription(ctx, subscription)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process store for tests and single node setups, the
+// published messages are only delivered in the same process.
+type MemoryStore struct {
+	mutex       sync.Mutex
+	lists       map[string][]string
+	values      map[string]*memoryValue
+	hashes      map[string]map[string]string
+	sets        map[string]map[string]bool
+	leases      map[string]map[string]time.Time
+	subscribers []*memorySubscriber
+}
+
+type memoryValue struct {
+	value    string
+	expireAt time.Time
+}
+
+type memorySubscriber struct {
+	channels map[string]bool
+	messages chan *Message
+	done     <-chan struct{}
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		lists:  make(map[string][]string),
+		values: make(map[string]*memoryValue),
+		hashes: make(map[string]map[string]string),
+		sets:   make(map[string]map[string]bool),
+		leases: make(map[string]map[string]time.Time),
+	}
+}
+
+func (s *MemoryStore) Push(key string, limit int64, values ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := append(s.lists[key], values...)
+	if limit > 0 && int64(len(list)) > limit {
+		list = append([]string{}, list[int64(len(list))-limit:]...)
+	}
+	s.lists[key] = list
+	return nil
+}
+
+func (s *MemoryStore) Replace(key string, values ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lists[key] = append([]string{}, values...)
+	return nil
+}
+
+func (s *MemoryStore) List(key string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]string{}, s.lists[key]...), nil
+}
+
+func (s *MemoryStore) Last(key string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := s.lists[key]
+	if len(list) == 0 {
+		return "", ErrNotFound
+	}
+	return list[len(list)-1], nil
+}
+
+func (s *MemoryStore) Delete(keys ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, k := range keys {
+		delete(s.lists, k)
+		delete(s.values, k)
+		delete(s.hashes, k)
+		delete(s.sets, k)
+		delete(s.leases, k)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Set(key, value string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v := &memoryValue{value: value}
+	if ttl > 0 {
+		v.expireAt = time.Now().Add(ttl)
+	}
+	s.values[key] = v
+	return nil
+}
+
+func (s *MemoryStore) Get(key string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v := s.value(key)
+	if v == nil {
+		return "", ErrNotFound
+	}
+	return v.value, nil
+}
+
+func (s *MemoryStore) MGet(keys ...string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	values := make([]string, len(keys))
+	for i, k := range keys {
+		if v := s.value(k); v != nil {
+			values[i] = v.value
+		}
+	}
+	return values, nil
+}
+
+func (s *MemoryStore) HGet(key, field string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, found := s.hashes[key][field]
+	if !found {
+		return "", ErrNotFound
+	}
+	return v, nil
+}
+
+func (s *MemoryStore) HSet(key, field, value string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.hashes[key] == nil {
+		s.hashes[key] = make(map[string]string)
+	}
+	s.hashes[key][field] = value
+	return nil
+}
+
+func (s *MemoryStore) HVals(keys ...string) ([][]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	values := make([][]string, len(keys))
+	for i, k := range keys {
+		for _, v := range s.hashes[k] {
+			values[i] = append(values[i], v)
+		}
+	}
+	return values, nil
+}
+
+func (s *MemoryStore) HKeys(key string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var fields []string
+	for f := range s.hashes[key] {
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func (s *MemoryStore) HDel(key string, fields ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, f := range fields {
+		delete(s.hashes[key], f)
+	}
+	return nil
+}
+
+func (s *MemoryStore) SAdd(key string, members ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.sets[key] == nil {
+		s.sets[key] = make(map[string]bool)
+	}
+	for _, m := range members {
+		s.sets[key][m] = true
+	}
+	return nil
+}
+
+func (s *MemoryStore) SMembers(key string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var members []string
+	for m := range s.sets[key] {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+func (s *MemoryStore) Incr(key string, ttl time.Duration) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var count int64
+	if v := s.value(key); v != nil {
+		count, _ = strconv.ParseInt(v.value, 10, 64)
+	}
+	count = count + 1
+	s.values[key] = &memoryValue{value: strconv.FormatInt(count, 10), expireAt: time.Now().Add(ttl)}
+	return count, nil
+}
+
+func (s *MemoryStore) Acquire(key, member string, limit int64, lease time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if s.leases[key] == nil {
+		s.leases[key] = make(map[string]time.Time)
+	}
+	for m, at := range s.leases[key] {
+		if now.Sub(at) > lease {
+			delete(s.leases[key], m)
+		}
+	}
+	if _, found := s.leases[key][member]; !found && int64(len(s.leases[key])) >= limit {
+		return false, nil
+	}
+	s.leases[key][member] = now
+	return true, nil
+}
+
+func (s *MemoryStore) Renew(key string, lease time.Duration, members ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for _, m := range members {
+		if _, found := s.leases[key][m]; found {
+			s.leases[key][m] = now
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Release(key string, members ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, m := range members {
+		delete(s.leases[key], m)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Publish(channel, payload string) error {
+	s.mutex.Lock()
+	subscribers := s.subscribers
+	s.mutex.Unlock()
+
+	msg := &Message{Channel: channel, Payload: payload}
+	for _, sub := range subscribers {
+		if !sub.channels[channel] {
+			continue
+		}
+		select {
+		case sub.messages <- msg:
+		case <-sub.done:
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Subscribe(ctx context.Context, channels ...string) <-chan *Message {
+	sub := &memorySubscriber{
+		channels: make(map[string]bool),
+		messages: make(chan *Message, 1024),
+		done:     ctx.Done(),
+	}
+	for _, c := range channels {
+		sub.channels[c] = true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.subscribers = append(s.subscribers, sub)
+	go func() {
+		<-ctx.Done()
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for i, c := range s.subscribers {
+			if c == sub {
+				s.subscribers = append(s.subscribers[:i:i], s.subscribers[i+1:]...)
+				break
+			}
+		}
+	}()
+	return sub.messages
+}
+
+func (s *MemoryStore) value(key string) *memoryValue {
+	v := s.values[key]
+	if v == nil {
+		return nil
+	}
+	if !v.expireAt.IsZero() && time.Now().After(v.expireAt) {
+		delete(s.values, key)
+		return nil
+	}
+	return v
+}
@@ -5,15 +5,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
 	"time"
 
-	"github.com/go-redis/redis"
+	"github.com/MixinNetwork/ocean.one/client"
 )
 
 const (
 	EventTypeOrderOpen   = "ORDER-OPEN"
 	EventTypeOrderMatch  = "ORDER-MATCH"
 	EventTypeOrderCancel = "ORDER-CANCEL"
+
+	EventTypeUserOrder    = "USER-ORDER"
+	EventTypeUserTrade    = "USER-TRADE"
+	EventTypeUserTransfer = "USER-TRANSFER"
 )
 
 type Event struct {
@@ -22,17 +27,22 @@ type Event struct {
 	Sequence  string                 `json:"sequence"`
 	Data      map[string]interface{} `json:"data,omitempty"`
 	Timestamp time.Time              `json:"timestamp"`
+	UserId    string                 `json:"user_id,omitempty"`
 }
 
 type Queue struct {
-	market   string
-	sequence int64
-	events   chan *Event
+	market      string
+	events      chan *Event
+	tickerDirty bool
+	tickerBest  string
+	tickerAt    time.Time
+	journalAt   time.Time
+	compactAt   time.Time
 }
 
 func ListPendingEvents(ctx context.Context, key string) ([]*Event, error) {
 	var events []*Event
-	slice, err := Redis(ctx).LRange(key, 0, -1).Result()
+	slice, err := Backend(ctx).List(key)
 	if err != nil {
 		return nil, err
 	}
@@ -49,7 +59,7 @@ func ListPendingEvents(ctx context.Context, key string) ([]*Event, error) {
 
 func Book(ctx context.Context, market string, limit int) (*Event, error) {
 	key := fmt.Sprintf("%s-BOOK-T%d", market, limit)
-	data, err := Redis(ctx).Get(key).Result()
+	data, err := Backend(ctx).Get(key)
 	if err != nil {
 		return nil, err
 	}
@@ -59,54 +69,99 @@ func Book(ctx context.Context, market string, limit int) (*Event, error) {
 }
 
 func NewQueue(ctx context.Context, market string) *Queue {
-	base, _ := time.Parse(time.RFC3339Nano, "2017-07-07T07:07:07.777777777Z")
 	return &Queue{
-		market:   market,
-		sequence: time.Now().UnixNano() - base.UnixNano(),
-		events:   make(chan *Event, 8192),
+		market: market,
+		events: make(chan *Event, 8192),
 	}
 }
 
+// ResetJournal reads the sequence of the last published event of the market,
+// then resets the published events, so the events replayed to the clients never
+// span a restart of the book. It's called before the book attaches any event.
+func ResetJournal(ctx context.Context, market string) (int64, error) {
+	key := market + "-ORDER-JOURNAL"
+	data, err := Backend(ctx).Last(key)
+	if err == ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var e Event
+	err = json.Unmarshal([]byte(data), &e)
+	if err != nil {
+		return 0, err
+	}
+	sequence, err := strconv.ParseInt(e.Sequence, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return sequence, Backend(ctx).Delete(key)
+}
+
 func (queue *Queue) Loop(ctx context.Context) {
+	journalTicker := time.NewTicker(journalCompactCheck)
+	defer journalTicker.Stop()
+
 	for {
 		select {
 		case e := <-queue.events:
-			err := queue.handleEvent(ctx, e)
-			if err != nil {
-				log.Println("cache queue loop error", err)
+			events := []*Event{e}
+			for len(events) < journalBatch && len(queue.events) > 0 {
+				events = append(events, <-queue.events)
+			}
+			for {
+				err := queue.journalEvents(ctx, events)
+				if err == nil {
+					break
+				}
+				log.Println("cache queue journal append error", err)
 				time.Sleep(1 * time.Second)
 			}
+			for _, e := range events {
+				err := queue.handleEvent(ctx, e)
+				if err != nil {
+					log.Println("cache queue loop error", err)
+					time.Sleep(1 * time.Second)
+				}
+			}
+		case <-journalTicker.C:
+			queue.compactJournal(ctx)
 		}
 	}
 }
 
 func (queue *Queue) handleEvent(ctx context.Context, e *Event) error {
-	e.Sequence = fmt.Sprint(queue.sequence)
 	data, err := json.Marshal(e)
 	if err != nil {
 		log.Panicln(err)
 	}
+	if e.UserId != "" {
+		return Backend(ctx).Publish("USER-EVENTS", string(data))
+	}
+	if e.Type == EventTypeLevelsSnapshot || e.Type == EventTypeLevelsUpdate {
+		return queue.handleLevelEvent(ctx, e, data)
+	}
 	if e.Type == "BOOK-T1" {
-		_, err := Redis(ctx).Set(queue.market+"-BOOK-T1", data, 0).Result()
-		return err
+		err := Backend(ctx).Set(queue.market+"-BOOK-T1", string(data), 0)
+		if err != nil {
+			return err
+		}
+		return queue.publishTicker(ctx, e)
 	}
 
-	queue.sequence = queue.sequence + 1
-
 	key := queue.market + "-ORDER-EVENTS"
 	switch e.Type {
 	case EventTypeOrderOpen, EventTypeOrderMatch, EventTypeOrderCancel:
-		_, err := Redis(ctx).RPush(key, data).Result()
+		err := Backend(ctx).Push(key, 0, string(data))
 		if err != nil {
 			return err
 		}
 	case "BOOK-T0":
-		_, err := Redis(ctx).Pipelined(func(pipe redis.Pipeliner) error {
-			pipe.Del(key)
-			pipe.RPush(key, data)
-			pipe.Set(queue.market+"-BOOK-T0", data, 0)
-			return nil
-		})
+		err := Backend(ctx).Replace(key, string(data))
+		if err != nil {
+			return err
+		}
+		err = Backend(ctx).Set(queue.market+"-BOOK-T0", string(data), 0)
 		if err != nil {
 			return err
 		}
@@ -114,22 +169,62 @@ func (queue *Queue) handleEvent(ctx context.Context, e *Event) error {
 			Market:    queue.market,
 			Type:      "HEARTBEAT",
 			Sequence:  e.Sequence,
+			Data:      map[string]interface{}{"checksum": bookChecksum(e)},
 			Timestamp: e.Timestamp,
 		})
 	default:
 		return fmt.Errorf("unsupported queue type %s", e.Type)
 	}
+	journal := queue.market + "-ORDER-JOURNAL"
+	err = Backend(ctx).Push(journal, journalSize, string(data))
+	if err != nil {
+		return err
+	}
+	err = Backend(ctx).Publish("ORDER-EVENTS", string(data))
+	if err != nil || e.Type != EventTypeOrderMatch {
+		return err
+	}
+	err = queue.publishTrade(ctx, e)
+	if err != nil {
+		return err
+	}
+	return queue.updateTicker(ctx, e)
+}
 
-	_, err = Redis(ctx).Publish("ORDER-EVENTS", data).Result()
-	return err
-
+// AttachEvent queues an event of the book at the sequence, only the order
+// events increment the sequence, and the others have the current one.
+func (queue *Queue) AttachEvent(ctx context.Context, typ string, sequence int64, data map[string]interface{}) {
+	queue.events <- &Event{
+		Market:    queue.market,
+		Type:      typ,
+		Sequence:  strconv.FormatInt(sequence, 10),
+		Data:      data,
+		Timestamp: time.Now().UTC(),
+	}
 }
 
-func (queue *Queue) AttachEvent(ctx context.Context, typ string, data map[string]interface{}) {
+// AttachUserEvent queues an event visible only to the user, in the same order
+// as the market events.
+func (queue *Queue) AttachUserEvent(ctx context.Context, userId, typ string, sequence int64, data map[string]interface{}) {
+	if userId == "" {
+		return
+	}
 	queue.events <- &Event{
 		Market:    queue.market,
 		Type:      typ,
+		Sequence:  strconv.FormatInt(sequence, 10),
 		Data:      data,
 		Timestamp: time.Now().UTC(),
+		UserId:    userId,
+	}
+}
+
+func bookChecksum(e *Event) uint32 {
+	data, _ := json.Marshal(e.Data)
+	var book struct {
+		Asks []client.Level `json:"asks"`
+		Bids []client.Level `json:"bids"`
 	}
+	json.Unmarshal(data, &book)
+	return client.Checksum(book.Asks, book.Bids)
 }
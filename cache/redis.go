@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	subscribeBackoffMin = 300 * time.Millisecond
+	subscribeBackoffMax = 10 * time.Second
+)
+
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Push(key string, limit int64, values ...string) error {
+	_, err := s.client.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.RPush(key, stringsToInterfaces(values)...)
+		if limit > 0 {
+			pipe.LTrim(key, -limit, -1)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) Replace(key string, values ...string) error {
+	_, err := s.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(key)
+		pipe.RPush(key, stringsToInterfaces(values)...)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) List(key string) ([]string, error) {
+	return s.client.LRange(key, 0, -1).Result()
+}
+
+func (s *RedisStore) Last(key string) (string, error) {
+	return notFound(s.client.LIndex(key, -1).Result())
+}
+
+func (s *RedisStore) Delete(keys ...string) error {
+	return s.client.Del(keys...).Err()
+}
+
+func (s *RedisStore) Set(key, value string, ttl time.Duration) error {
+	return s.client.Set(key, value, ttl).Err()
+}
+
+func (s *RedisStore) Get(key string) (string, error) {
+	return notFound(s.client.Get(key).Result())
+}
+
+func (s *RedisStore) MGet(keys ...string) ([]string, error) {
+	values, err := s.client.MGet(keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, len(values))
+	for i, v := range values {
+		result[i], _ = v.(string)
+	}
+	return result, nil
+}
+
+func (s *RedisStore) HGet(key, field string) (string, error) {
+	return notFound(s.client.HGet(key, field).Result())
+}
+
+func (s *RedisStore) HSet(key, field, value string) error {
+	return s.client.HSet(key, field, value).Err()
+}
+
+func (s *RedisStore) HVals(keys ...string) ([][]string, error) {
+	cmds := make([]*redis.StringSliceCmd, len(keys))
+	_, err := s.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, k := range keys {
+			cmds[i] = pipe.HVals(k)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]string, len(keys))
+	for i, cmd := range cmds {
+		values[i] = cmd.Val()
+	}
+	return values, nil
+}
+
+func (s *RedisStore) HKeys(key string) ([]string, error) {
+	return s.client.HKeys(key).Result()
+}
+
+func (s *RedisStore) HDel(key string, fields ...string) error {
+	return s.client.HDel(key, fields...).Err()
+}
+
+func (s *RedisStore) SAdd(key string, members ...string) error {
+	return s.client.SAdd(key, stringsToInterfaces(members)...).Err()
+}
+
+func (s *RedisStore) SMembers(key string) ([]string, error) {
+	return s.client.SMembers(key).Result()
+}
+
+func (s *RedisStore) Incr(key string, ttl time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := s.client.Pipelined(func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(key)
+		pipe.Expire(key, ttl)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (s *RedisStore) Acquire(key, member string, limit int64, lease time.Duration) (bool, error) {
+	now := time.Now()
+	var card *redis.IntCmd
+	_, err := s.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(key, "-inf", strconv.FormatInt(now.Add(-lease).UnixMilli(), 10))
+		pipe.ZAdd(key, redis.Z{Score: float64(now.UnixMilli()), Member: member})
+		card = pipe.ZCard(key)
+		pipe.Expire(key, lease)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if card.Val() <= limit {
+		return true, nil
+	}
+	return false, s.client.ZRem(key, member).Err()
+}
+
+func (s *RedisStore) Renew(key string, lease time.Duration, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	score := float64(time.Now().UnixMilli())
+	zs := make([]redis.Z, len(members))
+	for i, m := range members {
+		zs[i] = redis.Z{Score: score, Member: m}
+	}
+	_, err := s.client.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZAddXX(key, zs...)
+		pipe.Expire(key, lease)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) Release(key string, members ...string) error {
+	return s.client.ZRem(key, stringsToInterfaces(members)...).Err()
+}
+
+func (s *RedisStore) Publish(channel, payload string) error {
+	return s.client.Publish(channel, payload).Err()
+}
+
+// Subscribe receives the messages until the context is done, then the pubsub
+// and the messages channel are closed. The receive errors are retried with a
+// backoff up to subscribeBackoffMax.
+func (s *RedisStore) Subscribe(ctx context.Context, channels ...string) <-chan *Message {
+	messages := make(chan *Message, 1024)
+	pubsub := s.client.Subscribe(channels...)
+	go func() {
+		// unblock the receive when the context is done
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+	go func() {
+		defer close(messages)
+		backoff := subscribeBackoffMin
+		for {
+			msg, err := pubsub.ReceiveMessage()
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Println("redis subscribe", err)
+				select {
+				case <-time.After(backoff):
+				case <-ctx.Done():
+					return
+				}
+				if backoff = backoff * 2; backoff > subscribeBackoffMax {
+					backoff = subscribeBackoffMax
+				}
+				continue
+			}
+			backoff = subscribeBackoffMin
+			select {
+			case messages <- &Message{Channel: msg.Channel, Payload: msg.Payload}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return messages
+}
+
+func notFound(value string, err error) (string, error) {
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+	return value, err
+}
+
+func stringsToInterfaces(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
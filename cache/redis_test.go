@@ -0,0 +1,29 @@
+package cache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/ocean.one/cache"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisSubscribeDone(t *testing.T) {
+	assert := assert.New(t)
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond})
+	defer client.Close()
+
+	// the receive keeps failing without the server, it stops when the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	messages := cache.NewRedisStore(client).Subscribe(ctx, "ORDER-EVENTS")
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+	select {
+	case _, ok := <-messages:
+		assert.False(ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscribe not stopped")
+	}
+}
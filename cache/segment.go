@@ -0,0 +1,259 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileJournal keeps the events of each market in segmented local files, a
+// new segment is started by each snapshot and named by its sequence, so the
+// compaction only removes whole segments.
+type FileJournal struct {
+	dir   string
+	mutex sync.Mutex
+	files map[string]*os.File
+}
+
+func NewFileJournal(dir string) (*FileJournal, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileJournal{dir: dir, files: make(map[string]*os.File)}, nil
+}
+
+func (j *FileJournal) Append(ctx context.Context, events ...*Event) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	touched := make(map[string]*os.File)
+	for _, e := range events {
+		f, err := j.segment(e)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(append(data, '\n'))
+		if err != nil {
+			return err
+		}
+		touched[f.Name()] = f
+	}
+	for _, f := range touched {
+		err := f.Sync()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *FileJournal) Range(ctx context.Context, market string, from, to int64, limit int) ([]*Event, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	segments, err := j.segments(market)
+	if err != nil {
+		return nil, err
+	}
+	var events []*Event
+	for i, s := range segments {
+		if s > to || (limit > 0 && len(events) >= limit) {
+			break
+		}
+		if i+1 < len(segments) && segments[i+1] < from {
+			continue
+		}
+		err = j.scan(market, s, func(e *Event) bool {
+			sequence, _ := strconv.ParseInt(e.Sequence, 10, 64)
+			if sequence > to || (limit > 0 && len(events) >= limit) {
+				return false
+			}
+			if sequence >= from {
+				events = append(events, e)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+func (j *FileJournal) Snapshot(ctx context.Context, market string, sequence int64) (*Event, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	segments, err := j.segments(market)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(segments) - 1; i >= 0; i-- {
+		if segments[i] > sequence {
+			continue
+		}
+		e, err := j.first(market, segments[i])
+		if err != nil {
+			return nil, err
+		}
+		if e != nil && e.Type == "BOOK-T0" {
+			return e, nil
+		}
+	}
+	return nil, nil
+}
+
+func (j *FileJournal) Last(ctx context.Context, market string) (int64, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	segments, err := j.segments(market)
+	if err != nil || len(segments) == 0 {
+		return 0, err
+	}
+	var last int64
+	err = j.scan(market, segments[len(segments)-1], func(e *Event) bool {
+		sequence, _ := strconv.ParseInt(e.Sequence, 10, 64)
+		if sequence > last {
+			last = sequence
+		}
+		return true
+	})
+	return last, err
+}
+
+func (j *FileJournal) Compact(ctx context.Context, market string, before time.Time) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	segments, err := j.segments(market)
+	if err != nil {
+		return err
+	}
+	for i := len(segments) - 1; i > 0; i-- {
+		e, err := j.first(market, segments[i])
+		if err != nil {
+			return err
+		}
+		if e == nil || e.Type != "BOOK-T0" || e.Timestamp.After(before) {
+			continue
+		}
+		for _, s := range segments[:i] {
+			err = os.Remove(j.path(market, s))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// segment opens the file of the event, the last segment is reopened after
+// restart unless the event starts a new one.
+func (j *FileJournal) segment(e *Event) (*os.File, error) {
+	f := j.files[e.Market]
+	if f != nil && e.Type != "BOOK-T0" {
+		return f, nil
+	}
+	if f != nil {
+		f.Close()
+		delete(j.files, e.Market)
+	}
+	sequence, err := strconv.ParseInt(e.Sequence, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if e.Type != "BOOK-T0" {
+		segments, err := j.segments(e.Market)
+		if err != nil {
+			return nil, err
+		}
+		if len(segments) > 0 {
+			sequence = segments[len(segments)-1]
+		}
+	}
+	err = os.MkdirAll(filepath.Join(j.dir, e.Market), 0755)
+	if err != nil {
+		return nil, err
+	}
+	f, err = os.OpenFile(j.path(e.Market, sequence), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j.files[e.Market] = f
+	return f, nil
+}
+
+func (j *FileJournal) segments(market string) ([]int64, error) {
+	entries, err := os.ReadDir(filepath.Join(j.dir, market))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var segments []int64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		s, err := strconv.ParseInt(strings.TrimSuffix(name, ".log"), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, s)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+func (j *FileJournal) first(market string, segment int64) (*Event, error) {
+	var first *Event
+	err := j.scan(market, segment, func(e *Event) bool {
+		first = e
+		return false
+	})
+	return first, err
+}
+
+// scan decodes the events of the segment until fn returns false, a partially
+// written event at the end is ignored.
+func (j *FileJournal) scan(market string, segment int64, fn func(*Event) bool) error {
+	f, err := os.Open(j.path(market, segment))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	for {
+		var e Event
+		err := decoder.Decode(&e)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !fn(&e) {
+			return nil
+		}
+	}
+}
+
+func (j *FileJournal) path(market string, segment int64) string {
+	return filepath.Join(j.dir, market, fmt.Sprintf("%020d.log", segment))
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileJournal(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	journal, err := NewFileJournal(t.TempDir())
+	assert.Nil(err)
+	start := time.Now().Add(-2 * time.Hour)
+	event := func(typ string, sequence int64, data map[string]interface{}) *Event {
+		return &Event{Market: "market", Type: typ, Sequence: fmt.Sprint(sequence), Data: data, Timestamp: start.Add(time.Duration(sequence) * time.Minute)}
+	}
+	err = journal.Append(ctx,
+		event("BOOK-T0", 1, map[string]interface{}{"asks": []interface{}{}, "bids": []interface{}{}}),
+		event(EventTypeOrderOpen, 2, map[string]interface{}{"side": "ASK", "price": "0.2", "amount": "1"}),
+		event(EventTypeOrderOpen, 3, map[string]interface{}{"side": "BID", "price": "0.1", "funds": "0.5"}),
+	)
+	assert.Nil(err)
+	err = journal.Append(ctx,
+		event("BOOK-T0", 4, map[string]interface{}{
+			"asks": []interface{}{map[string]interface{}{"price": "0.2", "amount": "1"}},
+			"bids": []interface{}{map[string]interface{}{"price": "0.1", "funds": "0.5"}},
+		}),
+		event(EventTypeOrderMatch, 5, map[string]interface{}{"side": "ASK", "price": "0.2", "amount": "0.4"}),
+	)
+	assert.Nil(err)
+
+	events, err := journal.Range(ctx, "market", 2, 4, 0)
+	assert.Nil(err)
+	assert.Len(events, 3)
+	assert.Equal("2", events[0].Sequence)
+	assert.Equal("BOOK-T0", events[2].Type)
+	events, err = journal.Range(ctx, "market", 0, 100, 2)
+	assert.Nil(err)
+	assert.Len(events, 2)
+
+	last, err := journal.Last(ctx, "market")
+	assert.Nil(err)
+	assert.Equal(int64(5), last)
+	last, err = journal.Last(ctx, "other")
+	assert.Nil(err)
+	assert.Equal(int64(0), last)
+
+	snapshot, err := journal.Snapshot(ctx, "market", 3)
+	assert.Nil(err)
+	assert.Equal("1", snapshot.Sequence)
+	snapshot, err = journal.Snapshot(ctx, "market", 5)
+	assert.Nil(err)
+	assert.Equal("4", snapshot.Sequence)
+
+	err = journal.Compact(ctx, "market", start.Add(10*time.Minute))
+	assert.Nil(err)
+	events, err = journal.Range(ctx, "market", 0, 100, 0)
+	assert.Nil(err)
+	assert.Len(events, 2)
+	assert.Equal("4", events[0].Sequence)
+	snapshot, err = journal.Snapshot(ctx, "market", 3)
+	assert.Nil(err)
+	assert.Nil(snapshot)
+}
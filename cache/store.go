@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("cache: not found")
+
+type Message struct {
+	Channel string
+	Payload string
+}
+
+// Store is the backend of the cache, the lists of events, the snapshots and
+// the pub/sub shared by the engine and the HTTP nodes.
+type Store interface {
+	// Push appends the values to the list, and keeps only the last limit
+	// values unless limit is 0.
+	Push(key string, limit int64, values ...string) error
+	// Replace replaces the list with the values.
+	Replace(key string, values ...string) error
+	List(key string) ([]string, error)
+	Last(key string) (string, error)
+	Delete(keys ...string) error
+
+	Set(key, value string, ttl time.Duration) error
+	Get(key string) (string, error)
+	// MGet reads the values, an empty string for a missing key.
+	MGet(keys ...string) ([]string, error)
+
+	HGet(key, field string) (string, error)
+	HSet(key, field, value string) error
+	// HVals reads the values of each hash.
+	HVals(keys ...string) ([][]string, error)
+	HKeys(key string) ([]string, error)
+	HDel(key string, fields ...string) error
+	SAdd(key string, members ...string) error
+	SMembers(key string) ([]string, error)
+
+	// Incr increments the counter, which expires after the ttl.
+	Incr(key string, ttl time.Duration) (int64, error)
+	// Acquire adds the member to the leases of the key if there are less
+	// than limit leases, a lease expires unless renewed in the lease duration.
+	Acquire(key, member string, limit int64, lease time.Duration) (bool, error)
+	Renew(key string, lease time.Duration, members ...string) error
+	Release(key string, members ...string) error
+
+	Publish(channel, payload string) error
+	// Subscribe delivers the messages of the channels until the context is
+	// done, it reconnects on errors.
+	Subscribe(ctx context.Context, channels ...string) <-chan *Message
+}
@@ -0,0 +1,285 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+)
+
+const (
+	EventTypeTicker = "TICKER"
+
+	tickerWindow      = 24 * time.Hour
+	tickerBucket      = time.Minute
+	tickerRefresh     = time.Minute
+	tickerMarkets     = "TICKER-MARKETS"
+	tickerBucketsKey  = "%s-TICKER-BUCKETS"
+	tickerSnapshotKey = "%s-TICKER"
+	tickerAllMarkets  = "ALL"
+)
+
+type Ticker struct {
+	Market      string `json:"market"`
+	Open        string `json:"open"`
+	High        string `json:"high"`
+	Low         string `json:"low"`
+	Close       string `json:"close"`
+	Volume      string `json:"volume"`
+	QuoteVolume string `json:"quote_volume"`
+	Change      string `json:"change"`
+	Count       int64  `json:"count"`
+}
+
+type tickerStats struct {
+	Point  int64  `json:"t"`
+	Open   string `json:"o"`
+	High   string `json:"h"`
+	Low    string `json:"l"`
+	Close  string `json:"c"`
+	Volume string `json:"v"`
+	Funds  string `json:"f"`
+	Count  int64  `json:"n"`
+}
+
+// Tickers reads the rolling 24 hours statistics of the markets, all markets
+// with trades in the window are returned if none specified.
+func Tickers(ctx context.Context, markets ...string) ([]*Ticker, error) {
+	if len(markets) == 0 {
+		all, err := Backend(ctx).SMembers(tickerMarkets)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(all)
+		markets = all
+	}
+
+	keys := make([]string, len(markets))
+	for i, m := range markets {
+		keys[i] = fmt.Sprintf(tickerBucketsKey, m)
+	}
+	values, err := Backend(ctx).HVals(keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	since := time.Now().Add(-tickerWindow).Truncate(tickerBucket).Unix()
+	tickers := make([]*Ticker, 0)
+	for i, m := range markets {
+		var buckets []*tickerStats
+		for _, v := range values[i] {
+			var s tickerStats
+			err := json.Unmarshal([]byte(v), &s)
+			if err != nil {
+				return nil, err
+			}
+			if s.Point > since {
+				buckets = append(buckets, &s)
+			}
+		}
+		if len(buckets) == 0 {
+			continue
+		}
+		tickers = append(tickers, aggregateTicker(m, buckets))
+	}
+	return tickers, nil
+}
+
+func aggregateTicker(market string, buckets []*tickerStats) *Ticker {
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Point < buckets[j].Point })
+	open, close := number.FromString(buckets[0].Open), number.FromString(buckets[len(buckets)-1].Close)
+	high, low := open, open
+	volume, funds := number.Zero(), number.Zero()
+	var count int64
+	for _, b := range buckets {
+		if h := number.FromString(b.High); h.Cmp(high) > 0 {
+			high = h
+		}
+		if l := number.FromString(b.Low); l.Cmp(low) < 0 {
+			low = l
+		}
+		volume = volume.Add(number.FromString(b.Volume))
+		funds = funds.Add(number.FromString(b.Funds))
+		count = count + b.Count
+	}
+	change := number.Zero()
+	if open.Sign() > 0 {
+		change = close.Sub(open).Div(open).Mul(number.FromString("100")).RoundFloor(2)
+	}
+	return &Ticker{
+		Market:      market,
+		Open:        open.Persist(),
+		High:        high.Persist(),
+		Low:         low.Persist(),
+		Close:       close.Persist(),
+		Volume:      volume.Persist(),
+		QuoteVolume: funds.Persist(),
+		Change:      change.Persist(),
+		Count:       count,
+	}
+}
+
+// updateTicker merges the match event into the minute bucket of the market,
+// buckets out of the window are removed when a new bucket starts.
+func (queue *Queue) updateTicker(ctx context.Context, e *Event) error {
+	price, _ := e.Data["price"].(number.Integer)
+	amount, _ := e.Data["amount"].(number.Integer)
+	funds, _ := e.Data["funds"].(number.Integer)
+	key := fmt.Sprintf(tickerBucketsKey, queue.market)
+	point := e.Timestamp.Truncate(tickerBucket).Unix()
+	field := strconv.FormatInt(point, 10)
+
+	var s tickerStats
+	data, err := Backend(ctx).HGet(key, field)
+	if err == ErrNotFound {
+		s = tickerStats{Point: point, Open: price.Persist(), High: price.Persist(), Low: price.Persist(), Volume: "0", Funds: "0"}
+		err = queue.expireTicker(ctx, key, point)
+	} else if err == nil {
+		err = json.Unmarshal([]byte(data), &s)
+	}
+	if err != nil {
+		return err
+	}
+
+	if price.Decimal().Cmp(number.FromString(s.High)) > 0 {
+		s.High = price.Persist()
+	}
+	if price.Decimal().Cmp(number.FromString(s.Low)) < 0 {
+		s.Low = price.Persist()
+	}
+	s.Close = price.Persist()
+	s.Volume = number.FromString(s.Volume).Add(amount.Decimal()).Persist()
+	s.Funds = number.FromString(s.Funds).Add(funds.Decimal()).Persist()
+	s.Count = s.Count + 1
+
+	stats, _ := json.Marshal(s)
+	err = Backend(ctx).HSet(key, field, string(stats))
+	if err == nil {
+		err = Backend(ctx).SAdd(tickerMarkets, queue.market)
+	}
+	if err == nil {
+		queue.tickerDirty = true
+	}
+	return err
+}
+
+// publishTicker emits the ticker of the market with the best bid/ask of the
+// BOOK-T1 event, it's throttled by the BOOK-T1 interval and only emitted when
+// the ticker changes, or the rolling window needs a refresh.
+func (queue *Queue) publishTicker(ctx context.Context, e *Event) error {
+	data, _ := json.Marshal(e.Data)
+	var best struct {
+		Asks []struct {
+			Price string `json:"price"`
+		} `json:"asks"`
+		Bids []struct {
+			Price string `json:"price"`
+		} `json:"bids"`
+	}
+	json.Unmarshal(data, &best)
+	ask, bid := "0", "0"
+	if len(best.Asks) > 0 {
+		ask = best.Asks[0].Price
+	}
+	if len(best.Bids) > 0 {
+		bid = best.Bids[0].Price
+	}
+	if !queue.tickerDirty && queue.tickerBest == ask+bid && time.Since(queue.tickerAt) < tickerRefresh {
+		return nil
+	}
+
+	stats := &Ticker{Market: queue.market, Open: "0", High: "0", Low: "0", Close: "0", Volume: "0", QuoteVolume: "0", Change: "0"}
+	tickers, err := Tickers(ctx, queue.market)
+	if err != nil {
+		return err
+	}
+	if len(tickers) > 0 {
+		stats = tickers[0]
+	}
+	data, _ = json.Marshal(Event{
+		Market:   queue.market,
+		Type:     EventTypeTicker,
+		Sequence: e.Sequence,
+		Data: map[string]interface{}{
+			"price":        stats.Close,
+			"ask":          ask,
+			"bid":          bid,
+			"open":         stats.Open,
+			"high":         stats.High,
+			"low":          stats.Low,
+			"close":        stats.Close,
+			"volume":       stats.Volume,
+			"quote_volume": stats.QuoteVolume,
+			"change":       stats.Change,
+			"count":        stats.Count,
+		},
+		Timestamp: e.Timestamp,
+	})
+	err = Backend(ctx).Set(fmt.Sprintf(tickerSnapshotKey, queue.market), string(data), tickerWindow)
+	if err == nil {
+		err = Backend(ctx).Publish("TICKER-EVENTS", string(data))
+	}
+	if err != nil {
+		return err
+	}
+	queue.tickerDirty, queue.tickerBest, queue.tickerAt = false, ask+bid, time.Now()
+	return nil
+}
+
+// TickerSnapshots reads the last emitted tickers of the channel, which is
+// either a market or all markets.
+func TickerSnapshots(ctx context.Context, channel string) ([]*Event, error) {
+	markets := []string{strings.TrimSuffix(channel, "-TICKER")}
+	if markets[0] == tickerAllMarkets {
+		all, err := Backend(ctx).SMembers(tickerMarkets)
+		if err != nil || len(all) == 0 {
+			return nil, err
+		}
+		sort.Strings(all)
+		markets = all
+	}
+	keys := make([]string, len(markets))
+	for i, m := range markets {
+		keys[i] = fmt.Sprintf(tickerSnapshotKey, m)
+	}
+	values, err := Backend(ctx).MGet(keys...)
+	if err != nil {
+		return nil, err
+	}
+	var events []*Event
+	for _, s := range values {
+		if s == "" {
+			continue
+		}
+		var e Event
+		err = json.Unmarshal([]byte(s), &e)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, nil
+}
+
+func (queue *Queue) expireTicker(ctx context.Context, key string, point int64) error {
+	fields, err := Backend(ctx).HKeys(key)
+	if err != nil {
+		return err
+	}
+	since := point - int64(tickerWindow/time.Second)
+	var expired []string
+	for _, f := range fields {
+		if p, _ := strconv.ParseInt(f, 10, 64); p <= since {
+			expired = append(expired, f)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	return Backend(ctx).HDel(key, expired...)
+}
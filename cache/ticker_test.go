@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregateTicker(t *testing.T) {
+	assert := assert.New(t)
+
+	ticker := aggregateTicker("market", []*tickerStats{
+		{Point: 120, Open: "11", High: "13", Low: "10", Close: "12", Volume: "2", Funds: "24", Count: 2},
+		{Point: 60, Open: "10", High: "12", Low: "9", Close: "11", Volume: "1", Funds: "10", Count: 1},
+	})
+	assert.Equal("market", ticker.Market)
+	assert.Equal("10", ticker.Open)
+	assert.Equal("13", ticker.High)
+	assert.Equal("9", ticker.Low)
+	assert.Equal("12", ticker.Close)
+	assert.Equal("3", ticker.Volume)
+	assert.Equal("34", ticker.QuoteVolume)
+	assert.Equal("20", ticker.Change)
+	assert.Equal(int64(3), ticker.Count)
+
+	ticker = aggregateTicker("market", []*tickerStats{
+		{Point: 60, Open: "3", High: "3", Low: "3", Close: "2", Volume: "1", Funds: "3", Count: 1},
+	})
+	assert.Equal("-33.34", ticker.Change)
+	ticker = aggregateTicker("market", []*tickerStats{
+		{Point: 60, Open: "0", High: "1", Low: "0", Close: "1", Volume: "1", Funds: "1", Count: 1},
+	})
+	assert.Equal("0", ticker.Change)
+}
+
+func TestUpdateTicker(t *testing.T) {
+	assert := assert.New(t)
+	ctx := SetupBackend(context.Background(), NewMemoryStore())
+	queue := NewQueue(ctx, "market")
+
+	match := func(price, amount string, timestamp time.Time) {
+		p, a := number.FromString(price), number.FromString(amount)
+		err := queue.updateTicker(ctx, &Event{
+			Type: EventTypeOrderMatch,
+			Data: map[string]interface{}{
+				"price":  p.Integer(8),
+				"amount": a.Integer(8),
+				"funds":  p.Mul(a).Integer(8),
+			},
+			Timestamp: timestamp,
+		})
+		assert.Nil(err)
+	}
+	now := time.Now().Truncate(tickerBucket).Add(time.Second)
+	match("5", "10", now.Add(-tickerWindow-time.Minute))
+	match("10", "1", now.Add(-time.Hour))
+	match("12", "2", now)
+	match("9", "1", now)
+	assert.True(queue.tickerDirty)
+
+	// the bucket out of the window is removed when a new bucket starts
+	fields, err := Backend(ctx).HKeys(fmt.Sprintf(tickerBucketsKey, "market"))
+	assert.Nil(err)
+	assert.Len(fields, 2)
+
+	tickers, err := Tickers(ctx)
+	assert.Nil(err)
+	assert.Len(tickers, 1)
+	ticker := tickers[0]
+	assert.Equal("market", ticker.Market)
+	assert.Equal("10", ticker.Open)
+	assert.Equal("12", ticker.High)
+	assert.Equal("9", ticker.Low)
+	assert.Equal("9", ticker.Close)
+	assert.Equal("4", ticker.Volume)
+	assert.Equal("43", ticker.QuoteVolume)
+	assert.Equal("-10", ticker.Change)
+	assert.Equal(int64(3), ticker.Count)
+}
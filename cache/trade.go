@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+)
+
+const (
+	EventTypeTrade = "TRADE"
+
+	tradesSnapshotKey  = "%s-TRADES"
+	tradesSnapshotSize = 50
+)
+
+// publishTrade emits the match event as a trade in the same shape of the
+// market trades API, with the side of the taker order.
+func (queue *Queue) publishTrade(ctx context.Context, e *Event) error {
+	price, _ := e.Data["price"].(number.Integer)
+	amount, _ := e.Data["amount"].(number.Integer)
+	side := fmt.Sprint(e.Data["side"])
+	makerId, takerId := fmt.Sprint(e.Data["maker_id"]), fmt.Sprint(e.Data["taker_id"])
+	askOrderId, bidOrderId, takerSide := makerId, takerId, "BID"
+	if side == "BID" {
+		askOrderId, bidOrderId, takerSide = takerId, makerId, "ASK"
+	}
+	createdAt, ok := e.Data["created_at"].(time.Time)
+	if !ok {
+		createdAt = e.Timestamp
+	}
+	base, quote := queue.market, ""
+	if len(queue.market) == 73 {
+		base, quote = queue.market[:36], queue.market[37:]
+	}
+
+	data, _ := json.Marshal(Event{
+		Market:   queue.market,
+		Type:     EventTypeTrade,
+		Sequence: e.Sequence,
+		Data: map[string]interface{}{
+			"trade_id":     e.Data["trade_id"],
+			"base":         base,
+			"quote":        quote,
+			"bid_order_id": bidOrderId,
+			"ask_order_id": askOrderId,
+			"side":         side,
+			"taker_side":   takerSide,
+			"price":        price.Persist(),
+			"amount":       amount.Persist(),
+			"created_at":   createdAt.UTC(),
+		},
+		Timestamp: e.Timestamp,
+	})
+	key := fmt.Sprintf(tradesSnapshotKey, queue.market)
+	err := Backend(ctx).Push(key, tradesSnapshotSize, string(data))
+	if err != nil {
+		return err
+	}
+	return Backend(ctx).Publish("TRADE-EVENTS", string(data))
+}
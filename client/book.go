@@ -0,0 +1,174 @@
+// Package client maintains a local order book from the events of the Ocean ONE
+// WebSocket stream, and verifies it with the checksum of the HEARTBEAT event.
+package client
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+)
+
+// ChecksumDepth is the number of the best levels of each side in the checksum.
+const ChecksumDepth = 25
+
+type Event struct {
+	Market    string                 `json:"market"`
+	Type      string                 `json:"event"`
+	Sequence  string                 `json:"sequence"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+type Level struct {
+	Price  string `json:"price"`
+	Amount string `json:"amount"`
+	Funds  string `json:"funds"`
+}
+
+// Checksum is the CRC32 (IEEE) of the best ChecksumDepth levels of the asks
+// in ascending price, then the bids in descending price. Each ask level is
+// written as "price:amount" and each bid level as "price:funds", with the
+// decimals in the shortest form, and all joined by ":". Empty levels are skipped.
+func Checksum(asks, bids []Level) uint32 {
+	var parts []string
+	for _, side := range []struct {
+		levels []Level
+		funds  bool
+	}{{asks, false}, {bids, true}} {
+		count := 0
+		for _, l := range side.levels {
+			value := number.FromString(l.Amount)
+			if side.funds {
+				value = number.FromString(l.Funds)
+			}
+			if value.Sign() <= 0 {
+				continue
+			}
+			parts = append(parts, number.FromString(l.Price).Persist()+":"+value.Persist())
+			if count = count + 1; count == ChecksumDepth {
+				break
+			}
+		}
+	}
+	return crc32.ChecksumIEEE([]byte(strings.Join(parts, ":")))
+}
+
+// ErrSequenceGap is returned if an event is missed, the client should
+// resubscribe the market to get a fresh snapshot.
+var ErrSequenceGap = errors.New("sequence gap")
+
+type Book struct {
+	Market   string
+	Sequence int64
+	asks     map[string]number.Decimal
+	bids     map[string]number.Decimal
+}
+
+func NewBook(market string) *Book {
+	return &Book{
+		Market: market,
+		asks:   make(map[string]number.Decimal),
+		bids:   make(map[string]number.Decimal),
+	}
+}
+
+// Apply updates the book with the BOOK-T0 and ORDER-* events, the asks are
+// tracked by amount and the bids by funds, the same as the engine. The order
+// events must follow the sequence of the book one by one, and those already
+// in the book are skipped.
+func (book *Book) Apply(e *Event) error {
+	sequence, _ := strconv.ParseInt(e.Sequence, 10, 64)
+	switch e.Type {
+	case "ORDER-OPEN", "ORDER-MATCH", "ORDER-CANCEL":
+		if sequence <= book.Sequence {
+			return nil
+		}
+		if sequence != book.Sequence+1 {
+			return ErrSequenceGap
+		}
+		book.Sequence = sequence
+	}
+
+	switch e.Type {
+	case "BOOK-T0":
+		book.Sequence = sequence
+		book.asks = make(map[string]number.Decimal)
+		book.bids = make(map[string]number.Decimal)
+		for _, side := range []string{"asks", "bids"} {
+			levels, _ := e.Data[side].([]interface{})
+			for _, l := range levels {
+				level, _ := l.(map[string]interface{})
+				book.add(strings.ToUpper(strings.TrimSuffix(side, "s")), level, 1)
+			}
+		}
+	case "ORDER-OPEN":
+		book.add(fmt.Sprint(e.Data["side"]), e.Data, 1)
+	case "ORDER-MATCH", "ORDER-CANCEL":
+		book.add(fmt.Sprint(e.Data["side"]), e.Data, -1)
+	case "HEARTBEAT":
+	default:
+		return fmt.Errorf("unsupported event %s", e.Type)
+	}
+	return nil
+}
+
+// Verify compares the checksum of the book with the one in the HEARTBEAT
+// event, the client should resubscribe the market if they differ.
+func (book *Book) Verify(e *Event) bool {
+	checksum, ok := e.Data["checksum"].(float64)
+	if !ok {
+		return true
+	}
+	return uint32(checksum) == book.Checksum()
+}
+
+// Asks returns the ask levels in ascending price.
+func (book *Book) Asks() []Level {
+	return book.levels(book.asks, false)
+}
+
+// Bids returns the bid levels in descending price.
+func (book *Book) Bids() []Level {
+	return book.levels(book.bids, true)
+}
+
+func (book *Book) Checksum() uint32 {
+	return Checksum(book.levels(book.asks, false), book.levels(book.bids, true))
+}
+
+func (book *Book) levels(side map[string]number.Decimal, funds bool) []Level {
+	var levels []Level
+	for p, v := range side {
+		if funds {
+			levels = append(levels, Level{Price: p, Funds: v.Persist()})
+		} else {
+			levels = append(levels, Level{Price: p, Amount: v.Persist()})
+		}
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		c := number.FromString(levels[i].Price).Cmp(number.FromString(levels[j].Price))
+		if funds {
+			return c > 0
+		}
+		return c < 0
+	})
+	return levels
+}
+
+func (book *Book) add(side string, data map[string]interface{}, sign int64) {
+	price := number.FromString(fmt.Sprint(data["price"])).Persist()
+	levels, value := book.asks, number.FromString(fmt.Sprint(data["amount"]))
+	if side == "BID" {
+		levels, value = book.bids, number.FromString(fmt.Sprint(data["funds"]))
+	}
+	levels[price] = levels[price].Add(value.Mul(number.NewDecimal(sign, 0)))
+	if levels[price].Sign() <= 0 {
+		delete(levels, price)
+	}
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBookChecksum(t *testing.T) {
+	assert := assert.New(t)
+
+	asks := []Level{{Price: "0.2", Amount: "1.5", Funds: "0.3"}, {Price: "0.3", Amount: "2", Funds: "0.6"}}
+	bids := []Level{{Price: "0.1", Amount: "5", Funds: "0.5"}}
+	assert.Equal(Checksum(asks, bids), Checksum([]Level{{Price: "0.20", Amount: "1.50"}, {Price: "0.3", Amount: "2.0"}}, []Level{{Price: "0.1", Funds: "0.50"}}))
+
+	book := NewBook("market")
+	err := book.Apply(&Event{Type: "BOOK-T0", Sequence: "10", Data: map[string]interface{}{
+		"asks": []interface{}{map[string]interface{}{"price": "0.2", "amount": "1.5", "funds": "0.3"}},
+		"bids": []interface{}{map[string]interface{}{"price": "0.1", "amount": "5", "funds": "0.5"}},
+	}})
+	assert.Nil(err)
+	err = book.Apply(&Event{Type: "ORDER-OPEN", Sequence: "11", Data: map[string]interface{}{"side": "ASK", "price": "0.3", "amount": "2", "funds": "0.6"}})
+	assert.Nil(err)
+	assert.Equal(Checksum(asks, bids), book.Checksum())
+	assert.True(book.Verify(&Event{Type: "HEARTBEAT", Data: map[string]interface{}{"checksum": float64(Checksum(asks, bids))}}))
+
+	err = book.Apply(&Event{Type: "ORDER-MATCH", Sequence: "12", Data: map[string]interface{}{"side": "BID", "price": "0.1", "amount": "1", "funds": "0.1"}})
+	assert.Nil(err)
+	assert.False(book.Verify(&Event{Type: "HEARTBEAT", Data: map[string]interface{}{"checksum": float64(Checksum(asks, bids))}}))
+	bids[0].Funds = "0.4"
+	assert.Equal(Checksum(asks, bids), book.Checksum())
+
+	err = book.Apply(&Event{Type: "ORDER-CANCEL", Sequence: "13", Data: map[string]interface{}{"side": "ASK", "price": "0.3", "amount": "2", "funds": "0.6"}})
+	assert.Nil(err)
+	assert.Equal(Checksum(asks[:1], bids), book.Checksum())
+	assert.Equal(int64(13), book.Sequence)
+
+	err = book.Apply(&Event{Type: "ORDER-OPEN", Sequence: "13", Data: map[string]interface{}{"side": "ASK", "price": "0.3", "amount": "2", "funds": "0.6"}})
+	assert.Nil(err)
+	assert.Equal(Checksum(asks[:1], bids), book.Checksum())
+	err = book.Apply(&Event{Type: "ORDER-OPEN", Sequence: "15", Data: map[string]interface{}{"side": "ASK", "price": "0.3", "amount": "2", "funds": "0.6"}})
+	assert.Equal(ErrSequenceGap, err)
+	assert.Equal(Checksum(asks[:1], bids), book.Checksum())
+}
@@ -15,10 +15,13 @@ const (
 	OrderActionCancel = "CANCEL"
 
 	EventQueueSize = 8192
+	LevelsDepth    = 100
 )
 
-type TransactCallback func(taker, maker *Order, amount number.Integer) string
+// TransactCallback writes the match, and returns the id and time of the trade.
+type TransactCallback func(taker, maker *Order, amount number.Integer) (string, time.Time)
 type CancelCallback func(order *Order)
+type CheckpointCallback func(sequence int64)
 
 type OrderEvent struct {
 	Order  *Order
@@ -26,28 +29,37 @@ type OrderEvent struct {
 }
 
 type Book struct {
-	market      string
-	events      chan *OrderEvent
-	createIndex map[string]bool
-	cancelIndex map[string]bool
-	transact    TransactCallback
-	cancel      CancelCallback
-	asks        *Page
-	bids        *Page
-	queue       *cache.Queue
+	market       string
+	events       chan *OrderEvent
+	createIndex  map[string]bool
+	cancelIndex  map[string]bool
+	transact     TransactCallback
+	cancel       CancelCallback
+	checkpoint   CheckpointCallback
+	asks         *Page
+	bids         *Page
+	queue        *cache.Queue
+	sequence     int64
+	checkpointed int64
+	levelUpdates int
 }
 
-func NewBook(ctx context.Context, market string, transact TransactCallback, cancel CancelCallback) *Book {
+// NewBook resumes the book events from the sequence, which is incremented by
+// one for each book changing event, and checkpointed periodically.
+func NewBook(ctx context.Context, market string, sequence int64, transact TransactCallback, cancel CancelCallback, checkpoint CheckpointCallback) *Book {
 	return &Book{
-		market:      market,
-		events:      make(chan *OrderEvent, EventQueueSize),
-		createIndex: make(map[string]bool),
-		cancelIndex: make(map[string]bool),
-		transact:    transact,
-		cancel:      cancel,
-		asks:        NewPage(PageSideAsk),
-		bids:        NewPage(PageSideBid),
-		queue:       cache.NewQueue(ctx, market),
+		market:       market,
+		events:       make(chan *OrderEvent, EventQueueSize),
+		createIndex:  make(map[string]bool),
+		cancelIndex:  make(map[string]bool),
+		transact:     transact,
+		cancel:       cancel,
+		checkpoint:   checkpoint,
+		sequence:     sequence,
+		checkpointed: sequence,
+		asks:         NewPage(PageSideAsk),
+		bids:         NewPage(PageSideBid),
+		queue:        cache.NewQueue(ctx, market),
 	}
 }
 
@@ -67,7 +79,12 @@ func (book *Book) AttachOrderEvent(ctx context.Context, order *Order, action str
 	book.events <- &OrderEvent{Order: order, Action: action}
 }
 
-func (book *Book) process(ctx context.Context, taker, maker *Order) (string, number.Integer, number.Integer) {
+// AttachUserEvent queues an event for the user of an order in this book.
+func (book *Book) AttachUserEvent(ctx context.Context, userId, event string, data map[string]interface{}) {
+	book.queue.AttachUserEvent(ctx, userId, event, book.sequence, data)
+}
+
+func (book *Book) process(ctx context.Context, taker, maker *Order) (string, time.Time, number.Integer, number.Integer) {
 	taker.assert()
 	maker.assert()
 
@@ -107,8 +124,8 @@ func (book *Book) process(ctx context.Context, taker, maker *Order) (string, num
 		maker.RemainingFunds = maker.RemainingFunds.Sub(matchedFunds)
 	}
 
-	tradeId := book.transact(taker, maker, matchedAmount)
-	return tradeId, matchedAmount, matchedFunds
+	tradeId, createdAt := book.transact(taker, maker, matchedAmount)
+	return tradeId, createdAt, matchedAmount, matchedFunds
 }
 
 func (book *Book) createOrder(ctx context.Context, order *Order) {
@@ -123,8 +140,14 @@ func (book *Book) createOrder(ctx context.Context, order *Order) {
 			if order.Type == OrderTypeLimit && opponent.Price.Cmp(order.Price) < 0 {
 				return order.RemainingAmount.Zero(), order.RemainingFunds.Zero(), true
 			}
-			tradeId, matchedAmount, matchedFunds := book.process(ctx, order, opponent)
-			book.cacheOrderEvent(ctx, cache.EventTypeOrderMatch, opponent.Side, opponent.Price, matchedAmount, matchedFunds, tradeId, opponent.Id, order.Id)
+			tradeId, createdAt, matchedAmount, matchedFunds := book.process(ctx, order, opponent)
+			book.cacheOrderEvent(ctx, cache.EventTypeOrderMatch, opponent.Side, opponent.Price, matchedAmount, matchedFunds, map[string]interface{}{
+				"trade_id":   tradeId,
+				"maker_id":   opponent.Id,
+				"taker_id":   order.Id,
+				"created_at": createdAt,
+			})
+			book.cacheUserOrderEvent(ctx, opponent, opponent.filled())
 			opponents = append(opponents, opponent)
 			return matchedAmount, matchedFunds, order.filled()
 		})
@@ -136,7 +159,7 @@ func (book *Book) createOrder(ctx context.Context, order *Order) {
 		if !order.filled() {
 			if order.Type == OrderTypeLimit {
 				book.asks.Put(order)
-				book.cacheOrderEvent(ctx, cache.EventTypeOrderOpen, order.Side, order.Price, order.RemainingAmount, order.RemainingFunds, order.Id)
+				book.cacheOrderEvent(ctx, cache.EventTypeOrderOpen, order.Side, order.Price, order.RemainingAmount, order.RemainingFunds, map[string]interface{}{"order_id": order.Id})
 			} else {
 				book.cancel(order)
 			}
@@ -147,8 +170,14 @@ func (book *Book) createOrder(ctx context.Context, order *Order) {
 			if order.Type == OrderTypeLimit && opponent.Price.Cmp(order.Price) > 0 {
 				return order.RemainingAmount.Zero(), order.RemainingFunds.Zero(), true
 			}
-			tradeId, matchedAmount, matchedFunds := book.process(ctx, order, opponent)
-			book.cacheOrderEvent(ctx, cache.EventTypeOrderMatch, opponent.Side, opponent.Price, matchedAmount, matchedFunds, tradeId, opponent.Id, order.Id)
+			tradeId, createdAt, matchedAmount, matchedFunds := book.process(ctx, order, opponent)
+			book.cacheOrderEvent(ctx, cache.EventTypeOrderMatch, opponent.Side, opponent.Price, matchedAmount, matchedFunds, map[string]interface{}{
+				"trade_id":   tradeId,
+				"maker_id":   opponent.Id,
+				"taker_id":   order.Id,
+				"created_at": createdAt,
+			})
+			book.cacheUserOrderEvent(ctx, opponent, opponent.filled())
 			opponents = append(opponents, opponent)
 			return matchedAmount, matchedFunds, order.filled()
 		})
@@ -160,12 +189,13 @@ func (book *Book) createOrder(ctx context.Context, order *Order) {
 		if !order.filled() {
 			if order.Type == OrderTypeLimit {
 				book.bids.Put(order)
-				book.cacheOrderEvent(ctx, cache.EventTypeOrderOpen, order.Side, order.Price, order.RemainingAmount, order.RemainingFunds, order.Id)
+				book.cacheOrderEvent(ctx, cache.EventTypeOrderOpen, order.Side, order.Price, order.RemainingAmount, order.RemainingFunds, map[string]interface{}{"order_id": order.Id})
 			} else {
 				book.cancel(order)
 			}
 		}
 	}
+	book.cacheUserOrderEvent(ctx, order, order.filled() || order.Type != OrderTypeLimit)
 }
 
 func (book *Book) cancelOrder(ctx context.Context, order *Order) {
@@ -183,7 +213,8 @@ func (book *Book) cancelOrder(ctx context.Context, order *Order) {
 	}
 	if order != nil {
 		book.cancel(order)
-		book.cacheOrderEvent(ctx, cache.EventTypeOrderCancel, order.Side, order.Price, order.RemainingAmount, order.RemainingFunds, order.Id)
+		book.cacheOrderEvent(ctx, cache.EventTypeOrderCancel, order.Side, order.Price, order.RemainingAmount, order.RemainingFunds, map[string]interface{}{"order_id": order.Id})
+		book.cacheUserOrderEvent(ctx, order, true)
 	}
 }
 
@@ -197,6 +228,7 @@ func (book *Book) Run(ctx context.Context) {
 	defer bestCacheTicker.Stop()
 
 	book.cacheList(ctx, 0)
+	book.cacheLevels(ctx)
 
 	for {
 		select {
@@ -208,8 +240,11 @@ func (book *Book) Run(ctx context.Context) {
 			} else {
 				log.Panicln(event)
 			}
+			book.cacheLevelChanges(ctx)
 		case <-fullCacheTicker.C:
 			book.cacheList(ctx, 0)
+			book.cacheLevels(ctx)
+			book.checkpointSequence()
 		case <-bestCacheTicker.C:
 			book.cacheList(ctx, 1)
 		}
@@ -222,10 +257,40 @@ func (book *Book) cacheList(ctx context.Context, limit int) {
 		"asks": book.asks.List(limit, true),
 		"bids": book.bids.List(limit, true),
 	}
-	book.queue.AttachEvent(ctx, event, data)
+	book.queue.AttachEvent(ctx, event, book.sequence, data)
+}
+
+func (book *Book) cacheLevels(ctx context.Context) {
+	data := map[string]interface{}{
+		"asks": book.asks.Levels(LevelsDepth),
+		"bids": book.bids.Levels(LevelsDepth),
+	}
+	book.levelUpdates = 0
+	book.queue.AttachEvent(ctx, cache.EventTypeLevelsSnapshot, book.sequence, data)
+}
+
+// cacheLevelChanges replaces the updates with a snapshot after too many of
+// them, so the cached list of the market is limited.
+func (book *Book) cacheLevelChanges(ctx context.Context) {
+	if book.levelUpdates >= cache.LevelsUpdatesLimit {
+		book.cacheLevels(ctx)
+		return
+	}
+	asks, bids := book.asks.Changes(LevelsDepth), book.bids.Changes(LevelsDepth)
+	if len(asks) == 0 && len(bids) == 0 {
+		return
+	}
+	book.levelUpdates = book.levelUpdates + 1
+	data := map[string]interface{}{
+		"asks": asks,
+		"bids": bids,
+	}
+	book.queue.AttachEvent(ctx, cache.EventTypeLevelsUpdate, book.sequence, data)
 }
 
-func (book *Book) cacheOrderEvent(ctx context.Context, event, side string, price, amount, funds number.Integer, tradeAndOrderIds ...string) {
+// cacheOrderEvent attaches the order event with the ids of the order, or the
+// trade and orders of a match.
+func (book *Book) cacheOrderEvent(ctx context.Context, event, side string, price, amount, funds number.Integer, ids map[string]interface{}) {
 	if amount.IsZero() {
 		amount = funds.Div(price)
 	} else if funds.IsZero() {
@@ -237,15 +302,36 @@ func (book *Book) cacheOrderEvent(ctx context.Context, event, side string, price
 		"amount": amount,
 		"funds":  funds,
 	}
+	for k, v := range ids {
+		data[k] = v
+	}
 
-	switch event {
-	case cache.EventTypeOrderOpen, cache.EventTypeOrderCancel: // order open or cancel event
-		data["order_id"] = tradeAndOrderIds[0]
-	case cache.EventTypeOrderMatch: // order match event
-		data["trade_id"] = tradeAndOrderIds[0]
-		data["maker_id"] = tradeAndOrderIds[1]
-		data["taker_id"] = tradeAndOrderIds[2]
+	book.sequence = book.sequence + 1
+	book.queue.AttachEvent(ctx, event, book.sequence, data)
+}
+
+func (book *Book) checkpointSequence() {
+	if book.checkpoint == nil || book.checkpointed == book.sequence {
+		return
 	}
+	book.checkpoint(book.sequence)
+	book.checkpointed = book.sequence
+}
 
-	book.queue.AttachEvent(ctx, event, data)
+func (book *Book) cacheUserOrderEvent(ctx context.Context, order *Order, done bool) {
+	state := "PENDING"
+	if done {
+		state = "DONE"
+	}
+	book.queue.AttachUserEvent(ctx, order.UserId, cache.EventTypeUserOrder, book.sequence, map[string]interface{}{
+		"order_id":         order.Id,
+		"order_type":       order.Type,
+		"side":             order.Side,
+		"price":            order.Price,
+		"remaining_amount": order.RemainingAmount,
+		"filled_amount":    order.FilledAmount,
+		"remaining_funds":  order.RemainingFunds,
+		"filled_funds":     order.FilledFunds,
+		"state":            state,
+	})
 }
@@ -7,8 +7,6 @@ import (
 
 	"github.com/MixinNetwork/go-number"
 	"github.com/MixinNetwork/ocean.one/cache"
-	"github.com/MixinNetwork/ocean.one/config"
-	"github.com/go-redis/redis"
 	"github.com/gofrs/uuid/v5"
 	"github.com/stretchr/testify/assert"
 )
@@ -27,12 +25,12 @@ type DummyTrade struct {
 
 func TestBook(t *testing.T) {
 	ctx := context.Background()
-	ctx = testSetupRedis(ctx)
+	ctx = cache.SetupBackend(ctx, cache.NewMemoryStore())
 	assert := assert.New(t)
 
 	matched := make([]*DummyTrade, 0)
 	cancelled := make([]*Order, 0)
-	book := NewBook(ctx, "market", func(taker, maker *Order, amount number.Integer) string {
+	book := NewBook(ctx, "market", 0, func(taker, maker *Order, amount number.Integer) (string, time.Time) {
 		matched = append(matched, &DummyTrade{
 			Amount:           amount,
 			TakerId:          taker.Id,
@@ -44,10 +42,10 @@ func TestBook(t *testing.T) {
 			MakerFunds:       maker.RemainingFunds,
 			MakerFilledPrice: maker.FilledFunds.Div(maker.FilledAmount),
 		})
-		return "TRADE-ID"
+		return "TRADE-ID", time.Now()
 	}, func(order *Order) {
 		cancelled = append(cancelled, order)
-	})
+	}, nil)
 	assert.NotNil(book)
 	go book.Run(ctx)
 
@@ -248,17 +246,3 @@ func TestBook(t *testing.T) {
 	assert.Equal("0", m5.MakerAmount.Persist())
 	assert.Equal("200", m5.MakerFilledPrice.Persist())
 }
-
-func testSetupRedis(ctx context.Context) context.Context {
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:         config.RedisEngineCacheAddress,
-		DB:           config.RedisEngineCacheDatabase,
-		ReadTimeout:  3 * time.Second,
-		WriteTimeout: 3 * time.Second,
-		PoolTimeout:  4 * time.Second,
-		IdleTimeout:  60 * time.Second,
-		PoolSize:     1024,
-	})
-
-	return cache.SetupRedis(ctx, redisClient)
-}
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"log"
+	"sort"
 
 	"github.com/MixinNetwork/go-number"
 	"github.com/emirpasic/gods/lists/arraylist"
@@ -26,6 +27,8 @@ type Page struct {
 	Side    string
 	points  *redblacktree.Tree
 	entries map[string]*Entry
+	changes map[string]*Entry
+	levels  map[string]number.Integer
 }
 
 func NewPage(side string) *Page {
@@ -36,6 +39,8 @@ func NewPage(side string) *Page {
 		Side:    side,
 		points:  redblacktree.NewWith(entryCompare),
 		entries: make(map[string]*Entry),
+		changes: make(map[string]*Entry),
+		levels:  make(map[string]number.Integer),
 	}
 }
 
@@ -69,6 +74,7 @@ func (page *Page) Put(order *Order) {
 	}
 	entry.orders[order.Id] = order
 	entry.list.Add(order.Id)
+	page.changes[entry.Price.Persist()] = entry
 }
 
 func (page *Page) Remove(o *Order) *Order {
@@ -94,6 +100,7 @@ func (page *Page) Remove(o *Order) *Order {
 		entry.Funds = entry.Funds.Sub(order.RemainingFunds.Decimal())
 	}
 	entry.list.Remove(index)
+	page.changes[entry.Price.Persist()] = entry
 	return order
 }
 
@@ -103,6 +110,9 @@ func (page *Page) Iterate(hook func(*Order) (number.Integer, number.Integer, boo
 		for eit := entry.list.Iterator(); eit.Next(); {
 			order := entry.orders[eit.Value().(string)]
 			matchedAmount, matchedFunds, done := hook(order)
+			if !matchedAmount.IsZero() || !matchedFunds.IsZero() {
+				page.changes[entry.Price.Persist()] = entry
+			}
 			if entry.Side == PageSideAsk {
 				entry.Amount = entry.Amount.Sub(matchedAmount.Decimal())
 			} else {
@@ -119,19 +129,7 @@ func (page *Page) Iterate(hook func(*Order) (number.Integer, number.Integer, boo
 func (page *Page) List(count int, filterEmpty bool) []*Entry {
 	entries := make([]*Entry, 0)
 	for it := page.points.Iterator(); it.Next(); {
-		ie := it.Key().(*Entry)
-		entry := &Entry{
-			Side:   ie.Side,
-			Price:  ie.Price,
-			Amount: ie.Amount,
-			Funds:  ie.Funds,
-		}
-		price := ie.Price.Decimal()
-		if entry.Amount.IsZero() {
-			entry.Amount = entry.Funds.Div(price)
-		} else if entry.Funds.IsZero() {
-			entry.Funds = price.Mul(entry.Amount)
-		}
+		entry := it.Key().(*Entry).level()
 		if filterEmpty && entry.Funds.IsZero() {
 			continue
 		}
@@ -143,6 +141,58 @@ func (page *Page) List(count int, filterEmpty bool) []*Entry {
 	return entries
 }
 
+// Levels lists the best price levels up to the depth, and the following
+// changes are listed since them.
+func (page *Page) Levels(depth int) []*Entry {
+	entries := page.List(depth, true)
+	page.changes = make(map[string]*Entry)
+	page.levels = make(map[string]number.Integer)
+	for _, e := range entries {
+		page.levels[e.Price.Persist()] = e.Price
+	}
+	return entries
+}
+
+// Changes lists the aggregate state of the best price levels up to the depth,
+// which are changed or new to them since the last call. A level no longer in
+// them is listed with zero funds as removed, the deeper changes are dropped.
+func (page *Page) Changes(depth int) []*Entry {
+	entries := make([]*Entry, 0)
+	levels := make(map[string]number.Integer)
+	for _, e := range page.List(depth, true) {
+		key := e.Price.Persist()
+		levels[key] = e.Price
+		if _, found := page.levels[key]; !found || page.changes[key] != nil {
+			entries = append(entries, e)
+		}
+	}
+	for key, price := range page.levels {
+		if _, found := levels[key]; !found {
+			entries = append(entries, &Entry{Side: page.Side, Price: price, Amount: number.Zero(), Funds: number.Zero()})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entryCompare(entries[i], entries[j]) < 0 })
+	page.changes = make(map[string]*Entry)
+	page.levels = levels
+	return entries
+}
+
+func (ie *Entry) level() *Entry {
+	entry := &Entry{
+		Side:   ie.Side,
+		Price:  ie.Price,
+		Amount: ie.Amount,
+		Funds:  ie.Funds,
+	}
+	price := ie.Price.Decimal()
+	if entry.Amount.IsZero() {
+		entry.Amount = entry.Funds.Div(price)
+	} else if entry.Funds.IsZero() {
+		entry.Funds = price.Mul(entry.Amount)
+	}
+	return entry
+}
+
 func entryCompare(a, b interface{}) int {
 	entry := a.(*Entry)
 	opponent := b.(*Entry)
@@ -338,3 +338,86 @@ func TestPageBid(t *testing.T) {
 	assert.Equal("50", e.Funds.Persist())
 	assert.Equal(int64(10000), e.Price.Value())
 }
+
+func TestPageChanges(t *testing.T) {
+	assert := assert.New(t)
+
+	page := NewPage(PageSideAsk)
+	assert.Len(page.Changes(LevelsDepth), 0)
+
+	id, _ := uuid.NewV4()
+	o1 := &Order{
+		Id:              id.String(),
+		Side:            page.Side,
+		Type:            OrderTypeLimit,
+		Price:           number.NewInteger(20000, 2),
+		RemainingAmount: number.NewInteger(10, 1),
+		FilledAmount:    number.NewInteger(0, 1),
+	}
+	page.Put(o1)
+	id, _ = uuid.NewV4()
+	o2 := &Order{
+		Id:              id.String(),
+		Side:            page.Side,
+		Type:            OrderTypeLimit,
+		Price:           number.NewInteger(10000, 2),
+		RemainingAmount: number.NewInteger(20, 1),
+		FilledAmount:    number.NewInteger(0, 1),
+	}
+	page.Put(o2)
+
+	entries := page.Changes(LevelsDepth)
+	assert.Len(entries, 2)
+	assert.Equal(int64(10000), entries[0].Price.Value())
+	assert.Equal("2", entries[0].Amount.Persist())
+	assert.Equal("200", entries[0].Funds.Persist())
+	assert.Equal(int64(20000), entries[1].Price.Value())
+	assert.Equal("1", entries[1].Amount.Persist())
+	assert.Len(page.Changes(LevelsDepth), 0)
+
+	page.Remove(o2)
+	entries = page.Changes(LevelsDepth)
+	assert.Len(entries, 1)
+	assert.Equal(int64(10000), entries[0].Price.Value())
+	assert.Equal("0", entries[0].Amount.Persist())
+	assert.Equal("0", entries[0].Funds.Persist())
+}
+
+func TestPageChangesDepth(t *testing.T) {
+	assert := assert.New(t)
+
+	page := NewPage(PageSideBid)
+	orders := make([]*Order, 3)
+	for i := range orders {
+		id, _ := uuid.NewV4()
+		orders[i] = &Order{
+			Id:             id.String(),
+			Side:           page.Side,
+			Type:           OrderTypeLimit,
+			Price:          number.NewInteger(int64(30000-i*10000), 2),
+			RemainingFunds: number.NewInteger(100, 0),
+			FilledFunds:    number.NewInteger(0, 0),
+		}
+		page.Put(orders[i])
+	}
+	levels := page.Levels(2)
+	assert.Len(levels, 2)
+	assert.Len(page.Changes(2), 0)
+
+	page.Remove(orders[2])
+	assert.Len(page.Changes(2), 0)
+
+	page.Remove(orders[0])
+	entries := page.Changes(2)
+	assert.Len(entries, 1)
+	assert.Equal(int64(30000), entries[0].Price.Value())
+	assert.Equal("0", entries[0].Funds.Persist())
+
+	id, _ := uuid.NewV4()
+	page.Put(&Order{Id: id.String(), Side: page.Side, Type: OrderTypeLimit, Price: number.NewInteger(40000, 2), RemainingFunds: number.NewInteger(100, 0), FilledFunds: number.NewInteger(0, 0)})
+	page.Put(orders[2])
+	entries = page.Changes(2)
+	assert.Len(entries, 1)
+	assert.Equal(int64(40000), entries[0].Price.Value())
+	assert.Equal("100", entries[0].Funds.Persist())
+}
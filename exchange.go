@@ -3,11 +3,14 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/MixinNetwork/bot-api-go-client"
 	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/cache"
 	"github.com/MixinNetwork/ocean.one/config"
 	"github.com/MixinNetwork/ocean.one/engine"
 	"github.com/MixinNetwork/ocean.one/persistence"
@@ -17,7 +20,9 @@ import (
 
 const (
 	PollInterval                    = 100 * time.Millisecond
+	BrokerRevenueInterval           = 24 * time.Hour
 	CheckpointMixinNetworkSnapshots = "exchange-checkpoint-mixin-network-snapshots"
+	CheckpointBookSequence          = "exchange-checkpoint-book-sequence-%s"
 )
 
 type Exchange struct {
@@ -49,6 +54,7 @@ func (ex *Exchange) Run(ctx context.Context) {
 	}
 	go ex.PollMixinMessages(ctx)
 	go ex.PollMixinNetwork(ctx)
+	go ex.PollBrokerRevenues(ctx)
 	ex.PollOrderActions(ctx)
 }
 
@@ -97,12 +103,33 @@ func (ex *Exchange) PollTransfers(ctx context.Context, brokerId string) {
 	}
 }
 
+func (ex *Exchange) PollBrokerRevenues(ctx context.Context) {
+	limit := 500
+	for {
+		for _, b := range ex.brokers {
+			for {
+				count, err := persistence.PayBrokerRevenues(ctx, b.BrokerId, limit)
+				if err != nil {
+					log.Println("PayBrokerRevenues", b.BrokerId, err)
+					time.Sleep(PollInterval)
+					continue
+				}
+				if count < limit {
+					break
+				}
+			}
+		}
+		time.Sleep(BrokerRevenueInterval)
+	}
+}
+
 type TransferAction struct {
 	S string    // source
 	O uuid.UUID // cancelled order
 	A uuid.UUID // matched ask order
 	B uuid.UUID // matched bid order
-	F string    // fee
+	F string    // fee, negative for maker rebate
+	R string    `codec:",omitempty"` // reject reason
 }
 
 func (ex *Exchange) ensureProcessTransfer(ctx context.Context, transfer *persistence.Transfer) {
@@ -129,7 +156,11 @@ func (ex *Exchange) processTransfer(ctx context.Context, transfer *persistence.T
 	case persistence.TransferSourceOrderCancelled:
 		data = &TransferAction{S: "CANCEL", O: uuid.FromStringOrNil(transfer.Detail)}
 	case persistence.TransferSourceOrderInvalid:
-		data = &TransferAction{S: "REFUND", O: uuid.FromStringOrNil(transfer.Detail), F: transfer.Fee}
+		data = &TransferAction{S: "REFUND", O: uuid.FromStringOrNil(transfer.Detail), F: transfer.Fee, R: transfer.Reason}
+	case persistence.TransferSourceFeeWithdrawn:
+		data = &TransferAction{S: "WITHDRAW", O: uuid.FromStringOrNil(transfer.Detail)}
+	case persistence.TransferSourceBrokerRevenue:
+		data = &TransferAction{S: "REVENUE", O: uuid.FromStringOrNil(transfer.Detail)}
 	case persistence.TransferSourceTradeConfirmed:
 		trade, err := persistence.ReadTransferTrade(ctx, transfer.Detail, transfer.AssetId)
 		if err != nil {
@@ -156,25 +187,114 @@ func (ex *Exchange) processTransfer(ctx context.Context, transfer *persistence.T
 }
 
 func (ex *Exchange) buildBook(ctx context.Context, market string) *engine.Book {
-	return engine.NewBook(ctx, market, func(taker, maker *engine.Order, amount number.Integer) string {
+	var book *engine.Book
+	book = engine.NewBook(ctx, market, ex.bookSequence(ctx, market), func(taker, maker *engine.Order, amount number.Integer) (string, time.Time) {
 		for {
-			tradeId, err := persistence.Transact(ctx, taker, maker, amount)
+			trades, transfers, err := persistence.Transact(ctx, taker, maker, amount)
 			if err == nil {
-				return tradeId
+				for _, t := range trades {
+					book.AttachUserEvent(ctx, t.UserId, cache.EventTypeUserTrade, userTradeData(t))
+				}
+				for _, t := range transfers {
+					book.AttachUserEvent(ctx, t.UserId, cache.EventTypeUserTransfer, userTransferData(t))
+				}
+				return trades[0].TradeId, trades[0].CreatedAt
 			}
 			log.Println("Engine Transact CALLBACK", err)
 			time.Sleep(PollInterval)
 		}
 	}, func(order *engine.Order) {
 		for {
-			err := persistence.CancelOrder(ctx, order)
+			transfer, err := persistence.CancelOrder(ctx, order)
 			if err == nil {
+				book.AttachUserEvent(ctx, transfer.UserId, cache.EventTypeUserTransfer, userTransferData(transfer))
 				break
 			}
 			log.Println("Engine Cancel CALLBACK", err)
 			time.Sleep(PollInterval)
 		}
+	}, func(sequence int64) {
+		err := persistence.WriteProperty(ctx, fmt.Sprintf(CheckpointBookSequence, market), strconv.FormatInt(sequence, 10))
+		if err != nil {
+			log.Println("Engine Checkpoint CALLBACK", err)
+		}
 	})
+	return book
+}
+
+// bookSequence resumes the sequence of the market from the largest one of the
+// checkpoint, the last journaled event and the last published event, which may
+// be after the checkpoint. Redis may have lost the published events, but the
+// durable journal is appended before them, so it's read if configured. The
+// published events are reset last, after all the others are read.
+func (ex *Exchange) bookSequence(ctx context.Context, market string) int64 {
+	for {
+		checkpoint, err := persistence.ReadProperty(ctx, fmt.Sprintf(CheckpointBookSequence, market))
+		if err != nil {
+			log.Println("ReadProperty CheckpointBookSequence", err)
+			time.Sleep(PollInterval)
+			continue
+		}
+		var journaled int64
+		if cache.Journal(ctx) != nil {
+			journaled, err = cache.LastJournaled(ctx, market)
+			if err != nil {
+				log.Println("LastJournaled", err)
+				time.Sleep(PollInterval)
+				continue
+			}
+		}
+		last, err := cache.ResetJournal(ctx, market)
+		if err != nil {
+			log.Println("ResetJournal", err)
+			time.Sleep(PollInterval)
+			continue
+		}
+		var sequence int64
+		if checkpoint != "" {
+			sequence, err = strconv.ParseInt(checkpoint, 10, 64)
+			if err != nil {
+				log.Panicln("CheckpointBookSequence", market, checkpoint, err)
+			}
+		}
+		if last > sequence {
+			sequence = last
+		}
+		if journaled > sequence {
+			sequence = journaled
+		}
+		return sequence
+	}
+}
+
+func userTradeData(t *persistence.Trade) map[string]interface{} {
+	orderId := t.AskOrderId
+	if t.Side == engine.PageSideBid {
+		orderId = t.BidOrderId
+	}
+	return map[string]interface{}{
+		"trade_id":     t.TradeId,
+		"order_id":     orderId,
+		"side":         t.Side,
+		"liquidity":    t.Liquidity,
+		"price":        t.Price,
+		"amount":       t.Amount,
+		"fee_asset_id": t.FeeAssetId,
+		"fee_amount":   t.FeeAmount,
+		"created_at":   t.CreatedAt,
+	}
+}
+
+func userTransferData(t *persistence.Transfer) map[string]interface{} {
+	return map[string]interface{}{
+		"transfer_id": t.TransferId,
+		"source":      t.Source,
+		"detail":      t.Detail,
+		"asset_id":    t.AssetId,
+		"amount":      t.Amount,
+		"fee":         t.Fee,
+		"created_at":  t.CreatedAt,
+	}
 }
 
 func (ex *Exchange) ensureProcessOrderAction(ctx context.Context, action *persistence.Action) {
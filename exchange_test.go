@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/ocean.one/cache"
+	"github.com/MixinNetwork/ocean.one/config"
+	"github.com/MixinNetwork/ocean.one/persistence"
+	"github.com/gofrs/uuid/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/ugorji/go/codec"
+)
+
+func TestExchangeSettlement(t *testing.T) {
+	assert := assert.New(t)
+	store, err := persistence.NewSQLiteStore(filepath.Join(t.TempDir(), "ocean.db"))
+	assert.Nil(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = persistence.SetupBackend(ctx, store)
+	ctx = cache.SetupBackend(ctx, cache.NewMemoryStore())
+	ctx = cache.SetupJournal(ctx, persistence.NewSQLJournal(store))
+
+	ex := NewExchange()
+	brokerId, asker, bidder := testUUID(), testUUID(), testUUID()
+	ex.brokers[brokerId] = &persistence.Broker{BrokerId: brokerId}
+	base, quote := config.BitcoinAssetId, config.ERC20USDTAssetId
+	market := base + "-" + quote
+	createdAt := time.Now()
+
+	ask := testSnapshot(brokerId, asker, base, "0.01", createdAt, &OrderAction{S: "A", A: uuid.FromStringOrNil(quote), P: "10000", T: "L"})
+	bid := testSnapshot(brokerId, bidder, quote, "100", createdAt.Add(time.Second), &OrderAction{S: "B", A: uuid.FromStringOrNil(base), P: "10000", T: "L"})
+	invalid := testSnapshot(brokerId, bidder, quote, "10", createdAt.Add(2*time.Second), nil)
+	assert.Nil(ex.processSnapshot(ctx, ask))
+	assert.Nil(ex.processSnapshot(ctx, bid))
+	assert.Nil(ex.processSnapshot(ctx, invalid))
+	assert.Nil(ex.processSnapshot(ctx, ask))
+
+	count, err := persistence.CountPendingActions(ctx)
+	assert.Nil(err)
+	assert.Equal(int64(2), count)
+	actions, err := persistence.ListPendingActions(ctx, time.Time{}, 500)
+	assert.Nil(err)
+	assert.Len(actions, 2)
+	assert.Equal(ask.TraceId, actions[0].OrderId)
+	assert.Equal(bid.TraceId, actions[1].OrderId)
+	actions, err = persistence.ListPendingActions(ctx, actions[1].CreatedAt.Add(time.Millisecond), 500)
+	assert.Nil(err)
+	assert.Len(actions, 0)
+
+	rejection, err := persistence.UserRejection(ctx, invalid.TraceId, bidder)
+	assert.Nil(err)
+	assert.Equal(persistence.RejectReasonMemoInvalid, rejection.Reason)
+
+	actions, _ = persistence.ListPendingActions(ctx, time.Time{}, 500)
+	for _, a := range actions {
+		ex.ensureProcessOrderAction(ctx, a)
+	}
+
+	var transfers []*persistence.Transfer
+	for i := 0; i < 50 && len(transfers) < 4; i++ {
+		time.Sleep(PollInterval)
+		transfers, err = persistence.ListPendingTransfers(ctx, brokerId, 500)
+		assert.Nil(err)
+	}
+	assert.Len(transfers, 4)
+	received := make(map[string]*persistence.Transfer)
+	for _, t := range transfers {
+		received[t.UserId+t.AssetId] = t
+	}
+	refund := received[bidder+quote]
+	assert.Equal(persistence.TransferSourceOrderInvalid, refund.Source)
+	assert.Equal("9.99", refund.Amount)
+	filled := received[bidder+base]
+	assert.Equal(persistence.TransferSourceTradeConfirmed, filled.Source)
+	assert.Equal("0.00999", filled.Amount)
+	assert.Equal(persistence.TransferSourceTradeConfirmed, received[asker+quote].Source)
+	assert.Equal("100", received[asker+quote].Amount)
+	assert.Equal("0", received[asker+quote].Fee)
+	rebate := received[asker+base]
+	assert.Equal(persistence.TransferSourceTradeConfirmed, rebate.Source)
+	assert.Equal("0.000002", rebate.Amount)
+	assert.Equal("-0.000002", rebate.Fee)
+
+	trade, err := persistence.ReadTransferTrade(ctx, filled.Detail, base)
+	assert.Nil(err)
+	assert.Equal(ask.TraceId, trade.AskOrderId)
+	assert.Equal(bid.TraceId, trade.BidOrderId)
+	var events []*cache.Event
+	for i := 0; i < 50 && len(events) == 0; i++ {
+		time.Sleep(PollInterval)
+		events, err = cache.ListPendingEvents(ctx, market+"-TRADES")
+		assert.Nil(err)
+	}
+	assert.Len(events, 1)
+	assert.Equal(trade.TradeId, events[0].Data["trade_id"])
+	assert.Equal(trade.CreatedAt.UTC().Format(time.RFC3339Nano), events[0].Data["created_at"])
+	last, err := persistence.LastTrade(ctx, market)
+	assert.Nil(err)
+	assert.Equal(trade.TradeId, last.TradeId)
+	count, err = persistence.CountPendingActions(ctx)
+	assert.Nil(err)
+	assert.Equal(int64(0), count)
+
+	candles, err := persistence.MarketCandles(ctx, market, persistence.CandleGranularity1M, 10)
+	assert.Nil(err)
+	assert.Len(candles, 1)
+	assert.Equal("10000", candles[0].Close)
+	assert.Equal("0.01", candles[0].Volume)
+	assert.Nil(persistence.BackfillCandles(ctx))
+	candles, err = persistence.MarketCandles(ctx, market, persistence.CandleGranularity1M, 10)
+	assert.Nil(err)
+	assert.Equal("0.01", candles[0].Volume)
+
+	orders, _, err := persistence.UserOrders(ctx, bidder, market, persistence.OrderStateDone, nil, "DESC", 10)
+	assert.Nil(err)
+	assert.Len(orders, 1)
+	assert.Equal(bid.TraceId, orders[0].OrderId)
+	assert.Equal("100", orders[0].FilledFunds)
+	orders, _, err = persistence.UserOrders(ctx, asker, market, persistence.OrderStatePending, nil, "DESC", 10)
+	assert.Nil(err)
+	assert.Len(orders, 0)
+
+	assert.Nil(persistence.ExpireTransfers(ctx, transfers))
+	count, err = persistence.CountPendingTransfers(ctx)
+	assert.Nil(err)
+	assert.Equal(int64(0), count)
+
+	assert.Nil(persistence.WriteTimeProperty(ctx, CheckpointMixinNetworkSnapshots, createdAt))
+	checkpoint, err := persistence.ReadPropertyAsTime(ctx, CheckpointMixinNetworkSnapshots)
+	assert.Nil(err)
+	assert.True(createdAt.Equal(checkpoint))
+}
+
+func TestBookSequence(t *testing.T) {
+	assert := assert.New(t)
+	store, err := persistence.NewSQLiteStore(filepath.Join(t.TempDir(), "ocean.db"))
+	assert.Nil(err)
+	ctx := persistence.SetupBackend(context.Background(), store)
+	ctx = cache.SetupBackend(ctx, cache.NewMemoryStore())
+	ctx = cache.SetupJournal(ctx, persistence.NewSQLJournal(store))
+	market := config.BitcoinAssetId + "-" + config.ERC20USDTAssetId
+
+	ex := NewExchange()
+	assert.Equal(int64(0), ex.bookSequence(ctx, market))
+	assert.Nil(persistence.WriteProperty(ctx, fmt.Sprintf(CheckpointBookSequence, market), "5"))
+	assert.Equal(int64(5), ex.bookSequence(ctx, market))
+
+	// the published events after the checkpoint are lost from Redis
+	err = cache.Journal(ctx).Append(ctx, &cache.Event{Market: market, Type: cache.EventTypeOrderOpen, Sequence: "9", Timestamp: time.Now()})
+	assert.Nil(err)
+	assert.Equal(int64(9), ex.bookSequence(ctx, market))
+
+	// without the durable journal, the last published event is read before the reset
+	ctx = cache.SetupJournal(ctx, nil)
+	assert.Equal(int64(5), ex.bookSequence(ctx, market))
+	err = cache.Backend(ctx).Push(market+"-ORDER-JOURNAL", 10, `{"sequence":"12"}`)
+	assert.Nil(err)
+	assert.Equal(int64(12), ex.bookSequence(ctx, market))
+	events, err := cache.ListPendingEvents(ctx, market+"-ORDER-JOURNAL")
+	assert.Nil(err)
+	assert.Len(events, 0)
+	assert.Equal(int64(5), ex.bookSequence(ctx, market))
+}
+
+func testSnapshot(brokerId, userId, assetId, amount string, createdAt time.Time, action *OrderAction) *Snapshot {
+	s := &Snapshot{
+		SnapshotId: testUUID(),
+		Amount:     amount,
+		CreatedAt:  createdAt,
+		TraceId:    testUUID(),
+		UserId:     brokerId,
+		OpponentId: userId,
+		Data:       "invalid",
+	}
+	s.Asset.AssetId = assetId
+	if action != nil {
+		var out []byte
+		codec.NewEncoderBytes(&out, new(codec.MsgpackHandle)).MustEncode(action)
+		s.Data = base64.StdEncoding.EncodeToString(out)
+	}
+	return s
+}
+
+func testUUID() string {
+	id, _ := uuid.NewV4()
+	return id.String()
+}
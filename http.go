@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"net/http"
 	"runtime"
 	"strings"
@@ -12,7 +14,6 @@ import (
 	"github.com/MixinNetwork/ocean.one/persistence"
 	"github.com/dimfeld/httptreemux"
 	"github.com/gofrs/uuid/v5"
-	"github.com/gorilla/handlers"
 	"github.com/gorilla/websocket"
 	"github.com/unrolled/render"
 )
@@ -58,6 +59,7 @@ func (handler *RequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 			"checkpoint": cp,
 			"actions":    ac,
 			"transfers":  tc,
+			"hub":        handler.hub.Metrics(),
 		}
 		render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data})
 		return
@@ -69,15 +71,33 @@ func (handler *RequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	}
 	defer conn.Close()
 
+	encoding := r.URL.Query().Get("encoding")
+	if encoding == "" {
+		encoding = conn.Subprotocol()
+	}
+	encoding = cache.ParseEncoding(encoding)
+	conn.EnableWriteCompression(encoding != cache.EncodingGzip)
+
 	cid, err := uuid.NewV4()
 	if err != nil {
 		return
 	}
+	ip := remoteAddress(r)
 	ctx, cancel := context.WithCancel(r.Context())
-	client, err := cache.NewClient(ctx, handler.hub, conn, cid.String(), cancel)
+	client, err := cache.NewClient(ctx, handler.hub, conn, cid.String(), ip, encoding, r.URL.Query().Get("overflow"), cancel)
+	if err != nil {
+		return
+	}
+	ok, err := cache.AcquireConnection(ctx, ip, cid.String())
 	if err != nil {
+		client.Reject(ctx, err.Error())
+		return
+	}
+	if !ok {
+		client.Reject(ctx, fmt.Sprintf("connection limit exceeded: %d per IP", config.LimitConnectionsPerIP))
 		return
 	}
+	defer cache.ReleaseConnection(ctx, ip, cid.String())
 	if err := handler.hub.Register(ctx, client); err != nil {
 		return
 	}
@@ -87,38 +107,117 @@ func (handler *RequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 }
 
 func StartHTTP(ctx context.Context) error {
-	hub := cache.NewHub()
+	hub := cache.NewHub(persistence.Authenticate)
 	go hub.Run(ctx)
 
-	rh := &RequestHandler{
+	rh := newRequestHandler(hub)
+	handler := handleContext(rh, ctx)
+	handler = handleRateLimit(handler, ctx)
+	handler = handleCORS(handler)
+	handler = handleProxyHeaders(handler)
+	server := &http.Server{Addr: ":7000", Handler: handler}
+	return server.ListenAndServe()
+}
+
+func newRequestHandler(hub *cache.Hub) *RequestHandler {
+	return &RequestHandler{
 		hub: hub,
 		upgrader: &websocket.Upgrader{
-			HandshakeTimeout: 60 * time.Second,
-			ReadBufferSize:   1024,
-			WriteBufferSize:  1024,
-			CheckOrigin:      func(r *http.Request) bool { return true },
+			HandshakeTimeout:  60 * time.Second,
+			ReadBufferSize:    1024,
+			WriteBufferSize:   1024,
+			Subprotocols:      cache.Encodings,
+			EnableCompression: true,
+			CheckOrigin:       func(r *http.Request) bool { return true },
 			Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
 				render.New().JSON(w, status, map[string]interface{}{"error": reason.Error()})
 			},
 		},
 		router: NewRouter(),
 	}
-	handler := handleContext(rh, ctx)
-	handler = handleCORS(handler)
-	handler = handlers.ProxyHeaders(handler)
-
-	server := &http.Server{Addr: ":7000", Handler: handler}
-	return server.ListenAndServe()
 }
 
 func handleContext(handler http.Handler, src context.Context) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := cache.SetupRedis(r.Context(), cache.Redis(src))
-		ctx = persistence.SetupSpanner(ctx, persistence.Spanner(src))
+		ctx := cache.SetupBackend(r.Context(), cache.Backend(src))
+		ctx = persistence.SetupBackend(ctx, persistence.Backend(src))
+		ctx = cache.SetupJournal(ctx, cache.Journal(src))
 		handler.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+func handleRateLimit(handler http.Handler, src context.Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_hc" || (r.URL.Path == "/" && strings.ToLower(r.Header.Get("Upgrade")) == "websocket") {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		ctx := cache.SetupBackend(r.Context(), cache.Backend(src))
+		ok, err := cache.CheckRequestRate(ctx, remoteAddress(r))
+		if err != nil {
+			render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		} else if !ok {
+			w.Header().Set("Retry-After", "1")
+			render.New().JSON(w, http.StatusTooManyRequests, map[string]interface{}{"error": fmt.Sprintf("rate limit exceeded: %d requests per second per IP", config.LimitRequestsPerSecondIP)})
+		} else {
+			handler.ServeHTTP(w, r)
+		}
+	})
+}
+
+// handleProxyHeaders takes the scheme and host of the request from the
+// X-Forwarded-Proto and X-Forwarded-Host headers of a trusted proxy, unlike
+// handlers.ProxyHeaders which trusts them from any peer. The client address
+// is resolved by remoteAddress.
+func handleProxyHeaders(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if trustedProxy(host) {
+			if proto := strings.ToLower(r.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+				r.URL.Scheme = proto
+			}
+			if fh := r.Header.Get("X-Forwarded-Host"); fh != "" {
+				r.Host = fh
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// remoteAddress is the client address of the request, the X-Forwarded-For hops
+// are only trusted backwards from the peer while they are trusted proxies, so
+// a client can't forge its address by the header.
+func remoteAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0 && trustedProxy(host); i-- {
+		if hop := strings.TrimSpace(hops[i]); hop != "" {
+			host = hop
+		}
+	}
+	return host
+}
+
+func trustedProxy(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range strings.Split(config.TrustedProxyNetworks, ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func handleCORS(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
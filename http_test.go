@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/ocean.one/cache"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/ugorji/go/codec"
+)
+
+func TestRemoteAddress(t *testing.T) {
+	assert := assert.New(t)
+
+	r := httptest.NewRequest("GET", "/orders", nil)
+	r.RemoteAddr = "203.0.113.1:4000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	assert.Equal("203.0.113.1", remoteAddress(r))
+
+	r.RemoteAddr = "127.0.0.1:4000"
+	assert.Equal("198.51.100.1", remoteAddress(r))
+	r.Header.Set("X-Forwarded-For", "198.51.100.2, 198.51.100.1, ::1")
+	assert.Equal("198.51.100.1", remoteAddress(r))
+	r.Header.Del("X-Forwarded-For")
+	assert.Equal("127.0.0.1", remoteAddress(r))
+}
+
+func TestProxyHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	var scheme, host string
+	handler := handleProxyHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, host = r.URL.Scheme, r.Host
+	}))
+	r := httptest.NewRequest("GET", "/orders", nil)
+	r.RemoteAddr = "203.0.113.1:4000"
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "events.ocean.one")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal("", scheme)
+	assert.Equal("example.com", host)
+
+	r.RemoteAddr = "127.0.0.1:4000"
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal("https", scheme)
+	assert.Equal("events.ocean.one", host)
+}
+
+func TestWebSocketEncoding(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(cache.SetupBackend(context.Background(), cache.NewMemoryStore()))
+	defer cancel()
+
+	hub := cache.NewHub(nil)
+	go hub.Run(ctx)
+	server := httptest.NewServer(handleContext(newRequestHandler(hub), ctx))
+	defer server.Close()
+
+	handle := new(codec.MsgpackHandle)
+	handle.RawToString = true
+	request := map[string]interface{}{"id": "1", "action": "SUBSCRIBE_TICKER", "params": map[string]interface{}{"market": "market"}}
+	for _, c := range []struct {
+		query       string
+		subprotocol string
+		encoding    string
+	}{
+		{"", "", cache.EncodingGzip},
+		{"", cache.EncodingMsgpack, cache.EncodingMsgpack},
+		{"", "unknown", cache.EncodingGzip},
+		{"?encoding=json", cache.EncodingMsgpack, cache.EncodingJSON},
+		{"?encoding=unknown", cache.EncodingJSON, cache.EncodingGzip},
+	} {
+		dialer := &websocket.Dialer{}
+		if c.subprotocol != "" {
+			dialer.Subprotocols = []string{c.subprotocol}
+		}
+		conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/"+c.query, nil)
+		assert.Nil(err)
+
+		// the messages of the client are decoded in the same encoding
+		var data []byte
+		switch c.encoding {
+		case cache.EncodingMsgpack:
+			err = codec.NewEncoderBytes(&data, handle).Encode(request)
+		case cache.EncodingJSON:
+			data, err = json.Marshal(request)
+		default:
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			err = json.NewEncoder(w).Encode(request)
+			w.Close()
+			data = buf.Bytes()
+		}
+		assert.Nil(err)
+		messageType := websocket.BinaryMessage
+		if c.encoding == cache.EncodingJSON {
+			messageType = websocket.TextMessage
+		}
+		assert.Nil(conn.WriteMessage(messageType, data))
+
+		var msg struct {
+			Id     string `json:"id"`
+			Action string `json:"action"`
+			Error  string `json:"error"`
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		typ, data, err := conn.ReadMessage()
+		assert.Nil(err)
+		assert.Equal(messageType, typ, c.encoding)
+		switch c.encoding {
+		case cache.EncodingMsgpack:
+			var v map[string]interface{}
+			err = codec.NewDecoderBytes(data, handle).Decode(&v)
+			msg.Action, _ = v["action"].(string)
+			msg.Error, _ = v["error"].(string)
+		case cache.EncodingJSON:
+			err = json.Unmarshal(data, &msg)
+		default:
+			r, rerr := gzip.NewReader(bytes.NewReader(data))
+			assert.Nil(rerr)
+			err = json.NewDecoder(r).Decode(&msg)
+		}
+		assert.Nil(err, c.encoding)
+		assert.Equal("SUBSCRIBE_TICKER", msg.Action, c.encoding)
+		assert.Equal("", msg.Error)
+		conn.Close()
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"cloud.google.com/go/spanner"
@@ -15,39 +17,124 @@ import (
 
 func main() {
 	service := flag.String("service", "http", "run a service")
+	storage := flag.String("storage", "spanner", "the database, spanner, postgres or sqlite")
+	revenueShare := flag.String("revenue-share", "0", "the revenue share of the broker added by the broker service")
 	flag.Parse()
 
 	ctx := context.Background()
-	spannerClient, err := spanner.NewClientWithConfig(ctx, config.GoogleCloudSpanner, spanner.ClientConfig{NumChannels: 4,
-		SessionPoolConfig: spanner.SessionPoolConfig{
-			HealthCheckInterval: 5 * time.Second,
-		},
-	})
-	if err != nil {
-		log.Panicln(err)
+	if *service == "migrate" {
+		version, err := migrate(ctx, *storage)
+		if err != nil {
+			log.Panicln(err)
+		}
+		log.Println("migrate", *storage, version)
+		return
 	}
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:         config.RedisEngineCacheAddress,
-		DB:           config.RedisEngineCacheDatabase,
-		ReadTimeout:  3 * time.Second,
-		WriteTimeout: 3 * time.Second,
-		PoolTimeout:  4 * time.Second,
-		IdleTimeout:  60 * time.Second,
-		PoolSize:     1024,
-	})
-	err = redisClient.Ping().Err()
+	store, journal, err := openStorage(ctx, *storage)
 	if err != nil {
 		log.Panicln(err)
 	}
 
-	ctx = persistence.SetupSpanner(ctx, spannerClient)
-	ctx = cache.SetupRedis(ctx, redisClient)
+	ctx = persistence.SetupBackend(ctx, store)
+	if *service == "dev" {
+		ctx = cache.SetupBackend(ctx, cache.NewMemoryStore())
+	} else {
+		redisClient := redis.NewClient(&redis.Options{
+			Addr:         config.RedisEngineCacheAddress,
+			DB:           config.RedisEngineCacheDatabase,
+			ReadTimeout:  3 * time.Second,
+			WriteTimeout: 3 * time.Second,
+			PoolTimeout:  4 * time.Second,
+			IdleTimeout:  60 * time.Second,
+			PoolSize:     1024,
+		})
+		err = redisClient.Ping().Err()
+		if err != nil {
+			log.Panicln(err)
+		}
+		ctx = cache.SetupRedis(ctx, redisClient)
+	}
+
+	if dir := config.EventJournalDirectory; dir != "" {
+		// the http service only reads the file journal of the engine on the
+		// same host, without it the admin endpoints respond the error
+		if _, err := os.Stat(dir); *service == "http" && err != nil {
+			log.Println("event journal not found, the file journal must be shared with the engine on the same host", err)
+			journal = nil
+		} else {
+			journal, err = cache.NewFileJournal(dir)
+			if err != nil {
+				log.Panicln(err)
+			}
+		}
+	}
+	ctx = cache.SetupJournal(ctx, journal)
 
 	switch *service {
 	case "engine":
 		NewExchange().Run(ctx)
 	case "http":
 		StartHTTP(ctx)
+	case "dev":
+		go NewExchange().Run(ctx)
+		StartHTTP(ctx)
+	case "candles":
+		err = persistence.BackfillCandles(ctx)
+		if err != nil {
+			log.Panicln(err)
+		}
+	case "broker":
+		broker, err := persistence.AddBroker(ctx, *revenueShare)
+		if err != nil {
+			log.Panicln(err)
+		}
+		log.Println("broker", broker.BrokerId, broker.RevenueShare)
+	}
+}
+
+func migrate(ctx context.Context, storage string) (int, error) {
+	switch storage {
+	case "spanner":
+		client, err := spanner.NewClient(ctx, config.GoogleCloudSpanner)
+		if err != nil {
+			return 0, err
+		}
+		defer client.Close()
+		return persistence.MigrateSpanner(ctx, client)
+	case "postgres":
+		return persistence.MigratePostgres(ctx, config.PostgresDataSource)
+	case "sqlite":
+		_, err := persistence.NewSQLiteStore(config.SQLiteDatabasePath)
+		return persistence.SQLSchemaVersion, err
+	}
+	return 0, fmt.Errorf("no migrations for storage %s", storage)
+}
+
+func openStorage(ctx context.Context, storage string) (persistence.Store, cache.EventJournal, error) {
+	switch storage {
+	case "spanner":
+		client, err := spanner.NewClientWithConfig(ctx, config.GoogleCloudSpanner, spanner.ClientConfig{NumChannels: 4,
+			SessionPoolConfig: spanner.SessionPoolConfig{
+				HealthCheckInterval: 5 * time.Second,
+			},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return persistence.NewSpannerStore(client), persistence.NewSpannerJournal(client), nil
+	case "postgres":
+		store, err := persistence.NewPostgresStore(config.PostgresDataSource)
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, persistence.NewSQLJournal(store), nil
+	case "sqlite":
+		store, err := persistence.NewSQLiteStore(config.SQLiteDatabasePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, persistence.NewSQLJournal(store), nil
 	}
+	return nil, nil, fmt.Errorf("unknown storage %s", storage)
 }
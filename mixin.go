@@ -55,6 +55,7 @@ type OrderAction struct {
 	P string    // price
 	T string    // type
 	O uuid.UUID // order
+	F string    // fee balance
 }
 
 func (ex *Exchange) ensureProcessSnapshot(ctx context.Context, s *Snapshot) {
@@ -81,39 +82,42 @@ func (ex *Exchange) processSnapshot(ctx context.Context, s *Snapshot) error {
 
 	action, err := ex.decryptOrderAction(ctx, s.Data)
 	if err != nil {
-		return ex.refundSnapshot(ctx, s)
+		return ex.refundSnapshot(ctx, s, persistence.RejectReasonMemoInvalid)
 	}
 	if len(action.U) > 16 {
 		return persistence.UpdateUserPublicKey(ctx, s.OpponentId, hex.EncodeToString(action.U))
 	}
+	if action.F != "" {
+		return ex.updateFeeBalance(ctx, s, action.F)
+	}
 	if action.O.String() != uuid.Nil.String() {
 		return persistence.CancelOrderAction(ctx, action.O.String(), s.CreatedAt, s.OpponentId)
 	}
 
 	if action.A.String() == s.Asset.AssetId {
-		return ex.refundSnapshot(ctx, s)
+		return ex.refundSnapshot(ctx, s, persistence.RejectReasonAssetInvalid)
 	}
 	if action.T != engine.OrderTypeLimit && action.T != engine.OrderTypeMarket {
-		return ex.refundSnapshot(ctx, s)
+		return ex.refundSnapshot(ctx, s, persistence.RejectReasonTypeInvalid)
 	}
 
 	quote, base := ex.getQuoteBasePair(s, action)
 	if quote == "" {
-		return ex.refundSnapshot(ctx, s)
+		return ex.refundSnapshot(ctx, s, persistence.RejectReasonPairInvalid)
 	}
 
 	priceDecimal := number.FromString(action.P)
 	maxPrice := number.NewDecimal(MaxPrice, int32(config.QuotePrecision(quote)))
 	if priceDecimal.Cmp(maxPrice) > 0 {
-		return ex.refundSnapshot(ctx, s)
+		return ex.refundSnapshot(ctx, s, persistence.RejectReasonPriceTooLarge)
 	}
 	price := priceDecimal.Integer(config.QuotePrecision(quote))
 	if action.T == engine.OrderTypeLimit {
 		if price.IsZero() {
-			return ex.refundSnapshot(ctx, s)
+			return ex.refundSnapshot(ctx, s, persistence.RejectReasonPriceInvalid)
 		}
 	} else if !price.IsZero() {
-		return ex.refundSnapshot(ctx, s)
+		return ex.refundSnapshot(ctx, s, persistence.RejectReasonPriceInvalid)
 	}
 
 	fundsPrecision := AmountPrecision + config.QuotePrecision(quote)
@@ -125,20 +129,20 @@ func (ex *Exchange) processSnapshot(ctx context.Context, s *Snapshot) error {
 		maxAmount := number.NewDecimal(MaxAmount, AmountPrecision)
 		maxFunds := maxPrice.Mul(maxAmount)
 		if assetDecimal.Cmp(maxFunds) > 0 {
-			return ex.refundSnapshot(ctx, s)
+			return ex.refundSnapshot(ctx, s, persistence.RejectReasonFundsTooLarge)
 		}
 		funds = assetDecimal.Integer(fundsPrecision)
 		if funds.Decimal().Cmp(config.QuoteMinimum(quote)) < 0 {
-			return ex.refundSnapshot(ctx, s)
+			return ex.refundSnapshot(ctx, s, persistence.RejectReasonFundsTooSmall)
 		}
 	} else {
 		maxAmount := number.NewDecimal(MaxAmount, AmountPrecision)
 		if assetDecimal.Cmp(maxAmount) > 0 {
-			return ex.refundSnapshot(ctx, s)
+			return ex.refundSnapshot(ctx, s, persistence.RejectReasonAmountTooLarge)
 		}
 		amount = assetDecimal.Integer(AmountPrecision)
 		if action.T == engine.OrderTypeLimit && price.Mul(amount).Decimal().Cmp(config.QuoteMinimum(quote)) < 0 {
-			return ex.refundSnapshot(ctx, s)
+			return ex.refundSnapshot(ctx, s, persistence.RejectReasonAmountTooSmall)
 		}
 	}
 
@@ -156,6 +160,27 @@ func (ex *Exchange) processSnapshot(ctx context.Context, s *Snapshot) error {
 	}, s.OpponentId, s.UserId, s.CreatedAt)
 }
 
+func (ex *Exchange) updateFeeBalance(ctx context.Context, s *Snapshot, action string) error {
+	if s.Asset.AssetId != persistence.FeeDiscountAssetId {
+		return ex.refundSnapshot(ctx, s, persistence.RejectReasonFeeAsset)
+	}
+	var enabled *bool
+	var withdraw bool
+	switch action {
+	case "P":
+		enabled = new(bool)
+		*enabled = true
+	case "N":
+		enabled = new(bool)
+	case "D":
+	case "W":
+		withdraw = true
+	default:
+		return ex.refundSnapshot(ctx, s, persistence.RejectReasonFeeAction)
+	}
+	return persistence.UpdateFeeBalance(ctx, s.UserId, s.OpponentId, number.FromString(s.Amount), enabled, withdraw, s.TraceId, s.CreatedAt)
+}
+
 func (ex *Exchange) getQuoteBasePair(s *Snapshot, a *OrderAction) (string, string) {
 	var quote, base string
 	if a.S == engine.PageSideAsk {
@@ -171,13 +196,10 @@ func (ex *Exchange) getQuoteBasePair(s *Snapshot, a *OrderAction) (string, strin
 	return "", ""
 }
 
-func (ex *Exchange) refundSnapshot(ctx context.Context, s *Snapshot) error {
+func (ex *Exchange) refundSnapshot(ctx context.Context, s *Snapshot, reason string) error {
 	amount := number.FromString(s.Amount).Mul(number.FromString(RefundRate))
-	if amount.Exhausted() {
-		return nil
-	}
 	fee := number.FromString(s.Amount).Sub(amount)
-	return persistence.CreateRefundTransfer(ctx, s.UserId, s.OpponentId, s.Asset.AssetId, amount, fee, s.TraceId)
+	return persistence.CreateRefundTransfer(ctx, s.UserId, s.OpponentId, s.Asset.AssetId, amount, fee, s.TraceId, reason)
 }
 
 func (ex *Exchange) decryptOrderAction(ctx context.Context, data string) (*OrderAction, error) {
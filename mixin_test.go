@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/ocean.one/config"
+	"github.com/MixinNetwork/ocean.one/persistence"
+	"github.com/gofrs/uuid/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefundSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	store, err := persistence.NewSQLiteStore(filepath.Join(t.TempDir(), "ocean.db"))
+	assert.Nil(err)
+	ctx := persistence.SetupBackend(context.Background(), store)
+
+	ex := NewExchange()
+	brokerId, userId := testUUID(), testUUID()
+	ex.brokers[brokerId] = &persistence.Broker{BrokerId: brokerId}
+	base, quote := config.BitcoinAssetId, config.ERC20USDTAssetId
+
+	for _, c := range []struct {
+		reason string
+		asset  string
+		amount string
+		action *OrderAction
+	}{
+		{persistence.RejectReasonMemoInvalid, quote, "10", nil},
+		{persistence.RejectReasonAssetInvalid, quote, "10", &OrderAction{S: "B", A: uuid.FromStringOrNil(quote), P: "1", T: "L"}},
+		{persistence.RejectReasonTypeInvalid, quote, "10", &OrderAction{S: "B", A: uuid.FromStringOrNil(base), P: "1", T: "X"}},
+		{persistence.RejectReasonPairInvalid, base, "10", &OrderAction{S: "B", A: uuid.FromStringOrNil(quote), P: "1", T: "L"}},
+		{persistence.RejectReasonPriceTooLarge, quote, "10", &OrderAction{S: "B", A: uuid.FromStringOrNil(base), P: "1000000001", T: "L"}},
+		{persistence.RejectReasonPriceInvalid, quote, "10", &OrderAction{S: "B", A: uuid.FromStringOrNil(base), P: "0", T: "L"}},
+		{persistence.RejectReasonPriceInvalid, quote, "10", &OrderAction{S: "B", A: uuid.FromStringOrNil(base), P: "1", T: "M"}},
+		{persistence.RejectReasonFundsTooLarge, quote, "100000000000000000000000", &OrderAction{S: "B", A: uuid.FromStringOrNil(base), P: "1", T: "L"}},
+		{persistence.RejectReasonFundsTooSmall, quote, "0.00001", &OrderAction{S: "B", A: uuid.FromStringOrNil(base), P: "1", T: "L"}},
+		{persistence.RejectReasonAmountTooLarge, base, "60000000000000", &OrderAction{S: "A", A: uuid.FromStringOrNil(quote), P: "1", T: "L"}},
+		{persistence.RejectReasonAmountTooSmall, base, "0.00000001", &OrderAction{S: "A", A: uuid.FromStringOrNil(quote), P: "1", T: "L"}},
+		{persistence.RejectReasonFeeAsset, base, "10", &OrderAction{F: "P"}},
+		{persistence.RejectReasonFeeAction, persistence.FeeDiscountAssetId, "10", &OrderAction{F: "X"}},
+	} {
+		s := testSnapshot(brokerId, userId, c.asset, c.amount, time.Now(), c.action)
+		assert.Nil(ex.processSnapshot(ctx, s))
+		rejection, err := persistence.UserRejection(ctx, s.TraceId, userId)
+		assert.Nil(err)
+		if assert.NotNil(rejection, c.reason) {
+			assert.Equal(c.reason, rejection.Reason)
+			assert.Equal(c.asset, rejection.AssetId)
+		}
+	}
+
+	count, err := persistence.CountPendingActions(ctx)
+	assert.Nil(err)
+	assert.Equal(int64(0), count)
+	transfers, err := persistence.ListPendingTransfers(ctx, brokerId, 500)
+	assert.Nil(err)
+	assert.Len(transfers, 12)
+	for _, t := range transfers {
+		assert.Equal(persistence.TransferSourceOrderInvalid, t.Source)
+		assert.NotEqual("", t.Reason)
+	}
+}
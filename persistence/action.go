@@ -42,8 +42,8 @@ type Action struct {
 	Order *Order `spanner:"-"`
 }
 
-func CountPendingActions(ctx context.Context) (int64, error) {
-	it := Spanner(ctx).Single().Query(ctx, spanner.Statement{
+func (s *SpannerStore) CountPendingActions(ctx context.Context) (int64, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{
 		SQL: "SELECT COUNT(*) FROM actions",
 	})
 	defer it.Stop()
@@ -59,8 +59,8 @@ func CountPendingActions(ctx context.Context) (int64, error) {
 	return count, err
 }
 
-func ListPendingActions(ctx context.Context, checkpoint time.Time, limit int) ([]*Action, error) {
-	txn := Spanner(ctx).ReadOnlyTransaction()
+func (s *SpannerStore) ListPendingActions(ctx context.Context, checkpoint time.Time, limit int) ([]*Action, error) {
+	txn := s.client.ReadOnlyTransaction()
 	defer txn.Close()
 
 	it := txn.Query(ctx, spanner.Statement{
@@ -119,32 +119,9 @@ func ListPendingActions(ctx context.Context, checkpoint time.Time, limit int) ([
 	return actions, nil
 }
 
-func CreateOrderAction(ctx context.Context, o *engine.Order, userId, brokerId string, createdAt time.Time) error {
-	if !o.FilledFunds.IsZero() || !o.FilledAmount.IsZero() {
-		log.Panicln(userId, o)
-	}
-	order := Order{
-		OrderId:         o.Id,
-		OrderType:       o.Type,
-		Side:            o.Side,
-		QuoteAssetId:    o.Quote,
-		BaseAssetId:     o.Base,
-		Price:           o.Price.Persist(),
-		RemainingAmount: o.RemainingAmount.Persist(),
-		FilledAmount:    o.FilledAmount.Persist(),
-		RemainingFunds:  o.RemainingFunds.Persist(),
-		FilledFunds:     o.FilledFunds.Persist(),
-		CreatedAt:       createdAt,
-		State:           OrderStatePending,
-		UserId:          userId,
-		BrokerId:        brokerId,
-	}
-	action := Action{
-		OrderId:   order.OrderId,
-		Action:    engine.OrderActionCreate,
-		CreatedAt: createdAt,
-	}
-	_, err := Spanner(ctx).ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+func (s *SpannerStore) CreateOrderAction(ctx context.Context, order *Order) error {
+	action := order.createAction()
+	_, err := s.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
 		state, err := checkOrderState(ctx, txn, action.OrderId)
 		if err != nil || state != nil {
 			return err
@@ -162,13 +139,13 @@ func CreateOrderAction(ctx context.Context, o *engine.Order, userId, brokerId st
 	return err
 }
 
-func CancelOrderAction(ctx context.Context, orderId string, createdAt time.Time, userId string) error {
+func (s *SpannerStore) CancelOrderAction(ctx context.Context, orderId string, createdAt time.Time, userId string) error {
 	action := Action{
 		OrderId:   orderId,
 		Action:    engine.OrderActionCancel,
 		CreatedAt: createdAt,
 	}
-	_, err := Spanner(ctx).ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+	_, err := s.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
 		exist, err := checkActionExistence(ctx, txn, action.OrderId, action.Action)
 		if err != nil || exist {
 			return err
@@ -177,10 +154,7 @@ func CancelOrderAction(ctx context.Context, orderId string, createdAt time.Time,
 		if err != nil || state == nil {
 			return err
 		}
-		if state.State != OrderStatePending || state.OrderType != engine.OrderTypeLimit {
-			return nil
-		}
-		if userId != state.UserId && userId != config.ClientId {
+		if !state.cancellableBy(userId) {
 			return nil
 		}
 		actionMutation, err := spanner.InsertStruct("actions", action)
@@ -219,3 +193,40 @@ func checkOrderState(ctx context.Context, txn *spanner.ReadWriteTransaction, ord
 	err = row.Columns(&o.OrderType, &o.State, &o.UserId)
 	return &o, err
 }
+
+func newPendingOrder(o *engine.Order, userId, brokerId string, createdAt time.Time) *Order {
+	if !o.FilledFunds.IsZero() || !o.FilledAmount.IsZero() {
+		log.Panicln(userId, o)
+	}
+	return &Order{
+		OrderId:         o.Id,
+		OrderType:       o.Type,
+		Side:            o.Side,
+		QuoteAssetId:    o.Quote,
+		BaseAssetId:     o.Base,
+		Price:           o.Price.Persist(),
+		RemainingAmount: o.RemainingAmount.Persist(),
+		FilledAmount:    o.FilledAmount.Persist(),
+		RemainingFunds:  o.RemainingFunds.Persist(),
+		FilledFunds:     o.FilledFunds.Persist(),
+		CreatedAt:       createdAt,
+		State:           OrderStatePending,
+		UserId:          userId,
+		BrokerId:        brokerId,
+	}
+}
+
+func (o *Order) createAction() *Action {
+	return &Action{
+		OrderId:   o.OrderId,
+		Action:    engine.OrderActionCreate,
+		CreatedAt: o.CreatedAt,
+	}
+}
+
+func (o *Order) cancellableBy(userId string) bool {
+	if o.State != OrderStatePending || o.OrderType != engine.OrderTypeLimit {
+		return false
+	}
+	return userId == o.UserId || userId == config.ClientId
+}
@@ -20,6 +20,7 @@ import (
 
 	"cloud.google.com/go/spanner"
 	"github.com/MixinNetwork/bot-api-go-client"
+	"github.com/MixinNetwork/go-number"
 	"github.com/MixinNetwork/ocean.one/config"
 	"github.com/golang-jwt/jwt"
 	"google.golang.org/api/iterator"
@@ -34,47 +35,70 @@ type Broker struct {
 	PINToken         string    `spanner:"pin_token"`
 	EncryptedPIN     string    `spanner:"encrypted_pin"`
 	EncryptionHeader []byte    `spanner:"encryption_header"`
+	RevenueShare     string    `spanner:"revenue_share"`
 	CreatedAt        time.Time `spanner:"created_at"`
 
 	DecryptedPIN string `spanner:"-"`
 }
 
 func AllBrokers(ctx context.Context, decryptPIN bool) ([]*Broker, error) {
-	it := Spanner(ctx).Single().Query(ctx, spanner.Statement{SQL: "SELECT * FROM brokers"})
-	defer it.Stop()
-
 	brokers := []*Broker{
 		{
 			BrokerId:     config.ClientId,
 			SessionId:    config.SessionId,
 			SessionKey:   config.SessionKey,
 			PINToken:     config.PinToken,
+			RevenueShare: "0",
 			DecryptedPIN: config.SessionAssetPIN,
 		},
 	}
 
+	all, err := Backend(ctx).ReadBrokers(ctx)
+	if err != nil {
+		return brokers, err
+	}
+	for _, broker := range all {
+		if decryptPIN {
+			err = broker.decryptPIN()
+			if err != nil {
+				return brokers, err
+			}
+		}
+		brokers = append(brokers, broker)
+	}
+	return brokers, nil
+}
+
+func (s *SpannerStore) ReadBrokers(ctx context.Context) ([]*Broker, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{SQL: "SELECT * FROM brokers"})
+	defer it.Stop()
+
+	var brokers []*Broker
 	for {
 		row, err := it.Next()
 		if err == iterator.Done {
 			return brokers, nil
 		} else if err != nil {
-			return brokers, err
+			return nil, err
 		}
 		var broker Broker
 		err = row.ToStruct(&broker)
 		if err != nil {
-			return brokers, err
-		}
-		if decryptPIN {
-			err = broker.decryptPIN()
-			if err != nil {
-				return brokers, err
-			}
+			return nil, err
 		}
 		brokers = append(brokers, &broker)
 	}
 }
 
+func (s *SpannerStore) CreateBroker(ctx context.Context, broker *Broker) error {
+	insertBroker, err := spanner.InsertStruct("brokers", broker)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Apply(ctx, []*spanner.Mutation{insertBroker})
+	return err
+}
+
 func AllBrokersWithToken(ctx context.Context, decryptPIN bool) ([]map[string]string, error) {
 	brokers, err := AllBrokers(ctx, false)
 	if err != nil {
@@ -109,7 +133,60 @@ func AllBrokersWithToken(ctx context.Context, decryptPIN bool) ([]map[string]str
 	return data, nil
 }
 
-func AddBroker(ctx context.Context) (*Broker, error) {
+// AuthenticateBroker verifies a token signed by the session key of the broker,
+// the same way as the Mixin API tokens, so the sig claim must be the hash of the
+// request method and uri, and the public /brokers tokens can't be replayed.
+func AuthenticateBroker(ctx context.Context, jwtToken, method, uri string) (string, error) {
+	brokers, err := AllBrokers(ctx, false)
+	if err != nil {
+		return "", err
+	}
+
+	var brokerId string
+	token, err := jwt.Parse(jwtToken, func(token *jwt.Token) (interface{}, error) {
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			return nil, nil
+		}
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, nil
+		}
+		sum := sha256.Sum256([]byte(method + uri))
+		if fmt.Sprint(claims["sig"]) != hex.EncodeToString(sum[:]) {
+			return nil, nil
+		}
+		for _, b := range brokers {
+			if b.BrokerId != fmt.Sprint(claims["uid"]) || b.SessionId != fmt.Sprint(claims["sid"]) {
+				continue
+			}
+			block, _ := pem.Decode([]byte(b.SessionKey))
+			if block == nil {
+				return nil, nil
+			}
+			privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			brokerId = b.BrokerId
+			return &privateKey.PublicKey, nil
+		}
+		return nil, nil
+	})
+
+	if err == nil && token.Valid {
+		return brokerId, nil
+	}
+	return "", nil
+}
+
+// AddBroker creates a broker user of the exchange, the revenue share is the
+// part of the fees of its orders paid to the broker, from 0 to 1.
+func AddBroker(ctx context.Context, revenueShare string) (*Broker, error) {
+	share := number.FromString(revenueShare)
+	if share.Sign() < 0 || share.Cmp(number.FromString("1")) > 0 {
+		return nil, fmt.Errorf("invalid revenue share %s", revenueShare)
+	}
+
 	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
 	if err != nil {
 		return nil, err
@@ -159,19 +236,16 @@ func AddBroker(ctx context.Context) (*Broker, error) {
 			Type:  "RSA PRIVATE KEY",
 			Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
 		})),
-		PINToken:  resp.Data.PinToken,
-		CreatedAt: time.Now(),
+		PINToken:     resp.Data.PinToken,
+		RevenueShare: share.Persist(),
+		CreatedAt:    time.Now(),
 	}
 
 	err = broker.setupPIN(ctx)
 	if err != nil {
 		return nil, err
 	}
-	insertBroker, err := spanner.InsertStruct("brokers", broker)
-	if err != nil {
-		return nil, err
-	}
-	_, err = Spanner(ctx).Apply(ctx, []*spanner.Mutation{insertBroker})
+	err = Backend(ctx).CreateBroker(ctx, broker)
 	return broker, err
 }
 
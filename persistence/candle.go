@@ -0,0 +1,315 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/MixinNetwork/go-number"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	CandleGranularity1M  = 60
+	CandleGranularity5M  = 300
+	CandleGranularity15M = 900
+	CandleGranularity1H  = 3600
+	CandleGranularity6H  = 21600
+	CandleGranularity1D  = 86400
+)
+
+var candleGranularities = []int64{
+	CandleGranularity1M,
+	CandleGranularity5M,
+	CandleGranularity15M,
+	CandleGranularity1H,
+	CandleGranularity6H,
+	CandleGranularity1D,
+}
+
+var candleColumns = []string{"base", "quote", "granularity", "point", "open", "close", "high", "low", "volume", "total"}
+
+type Candle struct {
+	Base        string `spanner:"base"`
+	Quote       string `spanner:"quote"`
+	Granularity int64  `spanner:"granularity"`
+	Point       int64  `spanner:"point"`
+	Open        string `spanner:"open"`
+	Close       string `spanner:"close"`
+	High        string `spanner:"high"`
+	Low         string `spanner:"low"`
+	Volume      string `spanner:"volume"`
+	Total       string `spanner:"total"`
+}
+
+func MarketCandles(ctx context.Context, market string, granularity int64, limit int64) ([]*Candle, error) {
+	base, quote := getBaseQuote(market)
+	if base == "" || quote == "" || !validCandleGranularity(granularity) {
+		return make([]*Candle, 0), nil
+	}
+	if limit > 500 || limit <= 0 {
+		limit = 100
+	}
+
+	inputs, err := Backend(ctx).ReadCandles(ctx, base, quote, granularity, limit)
+	if err != nil || len(inputs) == 0 {
+		return make([]*Candle, 0), err
+	}
+	filter := make(map[int64]*Candle)
+	for _, c := range inputs {
+		filter[c.Point] = c
+	}
+
+	end := inputs[0].Point
+	start := end - (limit-1)*granularity
+	for _, c := range inputs {
+		if c.Point > start {
+			continue
+		}
+		if c.Point < start {
+			filter[start] = c.copyAsEmpty(start)
+		}
+		break
+	}
+	if filter[start] == nil {
+		start = inputs[len(inputs)-1].Point
+	}
+
+	var candles []*Candle
+	for i := start; i <= end; i += granularity {
+		c := filter[i]
+		if c == nil {
+			c = candles[len(candles)-1].copyAsEmpty(i)
+		}
+		candles = append(candles, c)
+	}
+	return candles, nil
+}
+
+func (s *SpannerStore) ReadCandles(ctx context.Context, base, quote string, granularity, limit int64) ([]*Candle, error) {
+	query := "SELECT * FROM candles WHERE base=@base AND quote=@quote AND granularity=@granularity ORDER BY base,quote,granularity,point DESC LIMIT %d"
+	it := s.client.Single().Query(ctx, spanner.Statement{
+		SQL:    fmt.Sprintf(query, limit),
+		Params: map[string]interface{}{"base": base, "quote": quote, "granularity": granularity},
+	})
+	defer it.Stop()
+
+	var candles []*Candle
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			return candles, nil
+		} else if err != nil {
+			return nil, err
+		}
+		var c Candle
+		err = row.ToStruct(&c)
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, &c)
+	}
+}
+
+// mergeCandles merges the trade into the candles of all granularities, it
+// must be called in the order of the trades of a market.
+func mergeCandles(ctx context.Context, r settlementReader, trade *Trade) ([]*Candle, error) {
+	candles := make([]*Candle, len(candleGranularities))
+	for i, g := range candleGranularities {
+		candles[i] = newCandle(trade, g)
+	}
+	existing, err := r.readCandles(ctx, candles)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range candles {
+		for _, e := range existing {
+			if e.Granularity == c.Granularity && e.Point == c.Point {
+				candles[i] = e.merge(trade)
+			}
+		}
+	}
+	return candles, nil
+}
+
+func (s *SpannerStore) BackfillCandles(ctx context.Context) error {
+	it := s.client.Single().Query(ctx, spanner.Statement{
+		SQL: "SELECT DISTINCT base_asset_id,quote_asset_id FROM trades",
+	})
+	defer it.Stop()
+
+	var markets [][2]string
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return err
+		}
+		var base, quote string
+		err = row.Columns(&base, &quote)
+		if err != nil {
+			return err
+		}
+		markets = append(markets, [2]string{base, quote})
+	}
+
+	for _, m := range markets {
+		err := s.backfillMarketCandles(ctx, m[0], m[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SpannerStore) backfillMarketCandles(ctx context.Context, base, quote string) error {
+	it := s.client.Single().Query(ctx, spanner.Statement{
+		SQL:    "SELECT * FROM trades@{FORCE_INDEX=trades_by_base_quote_created_id_asc} WHERE base_asset_id=@base AND quote_asset_id=@quote AND liquidity=@liquidity ORDER BY base_asset_id,quote_asset_id,created_at,trade_id",
+		Params: map[string]interface{}{"base": base, "quote": quote, "liquidity": TradeLiquidityMaker},
+	})
+	defer it.Stop()
+
+	var mutations []*spanner.Mutation
+	backfill := newCandleBackfill(func(c *Candle) error {
+		mutation, err := spanner.InsertOrUpdateStruct("candles", c)
+		if err != nil {
+			return err
+		}
+		mutations = append(mutations, mutation)
+		if len(mutations) < 1000 {
+			return nil
+		}
+		_, err = s.client.Apply(ctx, mutations)
+		mutations = nil
+		return err
+	})
+
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return err
+		}
+		var t Trade
+		err = row.ToStruct(&t)
+		if err != nil {
+			return err
+		}
+		err = backfill.add(&t)
+		if err != nil {
+			return err
+		}
+	}
+	err := backfill.flush()
+	if err != nil {
+		return err
+	}
+	if len(mutations) > 0 {
+		_, err := s.client.Apply(ctx, mutations)
+		if err != nil {
+			return err
+		}
+	}
+	log.Println("BackfillCandles", base, quote, backfill.count)
+	return nil
+}
+
+// candleBackfill merges the trades of a market in order, each candle is
+// written once it's complete.
+type candleBackfill struct {
+	current map[int64]*Candle
+	write   func(*Candle) error
+	count   int
+}
+
+func newCandleBackfill(write func(*Candle) error) *candleBackfill {
+	return &candleBackfill{current: make(map[int64]*Candle), write: write}
+}
+
+func (b *candleBackfill) add(t *Trade) error {
+	for _, g := range candleGranularities {
+		c := b.current[g]
+		n := newCandle(t, g)
+		if c != nil && c.Point == n.Point {
+			b.current[g] = c.merge(t)
+			continue
+		}
+		if c != nil {
+			err := b.write(c)
+			if err != nil {
+				return err
+			}
+		}
+		b.current[g] = n
+	}
+	b.count = b.count + 1
+	return nil
+}
+
+func (b *candleBackfill) flush() error {
+	for _, c := range b.current {
+		err := b.write(c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newCandle(trade *Trade, granularity int64) *Candle {
+	price, amount := number.FromString(trade.Price), number.FromString(trade.Amount)
+	return &Candle{
+		Base:        trade.BaseAssetId,
+		Quote:       trade.QuoteAssetId,
+		Granularity: granularity,
+		Point:       trade.CreatedAt.UTC().Truncate(time.Duration(granularity) * time.Second).Unix(),
+		Open:        price.Persist(),
+		Close:       price.Persist(),
+		High:        price.Persist(),
+		Low:         price.Persist(),
+		Volume:      amount.Persist(),
+		Total:       price.Mul(amount).Persist(),
+	}
+}
+
+func (c *Candle) merge(trade *Trade) *Candle {
+	price, amount := number.FromString(trade.Price), number.FromString(trade.Amount)
+	c.Close = price.Persist()
+	if price.Cmp(number.FromString(c.High)) > 0 {
+		c.High = price.Persist()
+	}
+	if price.Cmp(number.FromString(c.Low)) < 0 {
+		c.Low = price.Persist()
+	}
+	c.Volume = number.FromString(c.Volume).Add(amount).Persist()
+	c.Total = number.FromString(c.Total).Add(price.Mul(amount)).Persist()
+	return c
+}
+
+func (c *Candle) copyAsEmpty(point int64) *Candle {
+	return &Candle{
+		Base:        c.Base,
+		Quote:       c.Quote,
+		Granularity: c.Granularity,
+		Point:       point,
+		Open:        c.Close,
+		Close:       c.Close,
+		High:        c.Close,
+		Low:         c.Close,
+		Volume:      "0",
+		Total:       "0",
+	}
+}
+
+func validCandleGranularity(granularity int64) bool {
+	for _, g := range candleGranularities {
+		if g == granularity {
+			return true
+		}
+	}
+	return false
+}
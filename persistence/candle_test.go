@@ -0,0 +1,51 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeCandles(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	start := time.Unix(1700000000, 0).Truncate(time.Hour)
+	trades := []*Trade{
+		{BaseAssetId: "base", QuoteAssetId: "quote", Price: "10", Amount: "1", CreatedAt: start},
+		{BaseAssetId: "base", QuoteAssetId: "quote", Price: "12", Amount: "2", CreatedAt: start.Add(time.Second)},
+		{BaseAssetId: "base", QuoteAssetId: "quote", Price: "11", Amount: "1", CreatedAt: start.Add(time.Minute)},
+	}
+
+	r := &testSettlementReader{}
+	for _, trade := range trades {
+		candles, err := mergeCandles(ctx, r, trade)
+		assert.Nil(err)
+		assert.Len(candles, len(candleGranularities))
+		r.candles = candles
+	}
+	m1, h1 := r.candles[0], r.candles[3]
+	assert.Equal(int64(CandleGranularity1M), m1.Granularity)
+	assert.Equal(start.Add(time.Minute).Unix(), m1.Point)
+	assert.Equal("11", m1.Open)
+	assert.Equal("1", m1.Volume)
+	assert.Equal(int64(CandleGranularity1H), h1.Granularity)
+	assert.Equal("10", h1.Open)
+	assert.Equal("11", h1.Close)
+	assert.Equal("12", h1.High)
+	assert.Equal("10", h1.Low)
+	assert.Equal("4", h1.Volume)
+	assert.Equal("45", h1.Total)
+
+	var written []*Candle
+	backfill := newCandleBackfill(func(c *Candle) error {
+		written = append(written, c)
+		return nil
+	})
+	for _, t := range trades {
+		assert.Nil(backfill.add(t))
+	}
+	assert.Nil(backfill.flush())
+	assert.Len(written, len(candleGranularities)+1)
+}
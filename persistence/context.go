@@ -9,14 +9,18 @@ import (
 type contextValueKey int
 
 const (
-	keySpanner contextValueKey = 1
+	keyStore contextValueKey = 1
 )
 
 func SetupSpanner(ctx context.Context, client *spanner.Client) context.Context {
-	return context.WithValue(ctx, keySpanner, client)
+	return SetupBackend(ctx, NewSpannerStore(client))
 }
 
-func Spanner(ctx context.Context) *spanner.Client {
-	v, _ := ctx.Value(keySpanner).(*spanner.Client)
+func SetupBackend(ctx context.Context, store Store) context.Context {
+	return context.WithValue(ctx, keyStore, store)
+}
+
+func Backend(ctx context.Context) Store {
+	v, _ := ctx.Value(keyStore).(Store)
 	return v
 }
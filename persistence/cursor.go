@@ -0,0 +1,73 @@
+package persistence
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+)
+
+// Cursor is an opaque position in a list ordered by (created_at, id), where
+// the id breaks the ties of rows created at the same time. The tiebreak
+// orders the rows of the same id, like the maker and taker trades of a
+// self-trade, and is empty in the lists of unique ids.
+type Cursor struct {
+	CreatedAt time.Time
+	Id        string
+	Tiebreak  string
+}
+
+func ParseCursor(s string) *Cursor {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil
+	}
+	parts := strings.SplitN(string(data), "|", 3)
+	if len(parts) < 2 {
+		return nil
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil
+	}
+	cursor := &Cursor{CreatedAt: createdAt, Id: parts[1]}
+	if len(parts) == 3 {
+		cursor.Tiebreak = parts[2]
+	}
+	return cursor
+}
+
+func (c *Cursor) String() string {
+	if c == nil {
+		return ""
+	}
+	data := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.Id
+	if c.Tiebreak != "" {
+		data = data + "|" + c.Tiebreak
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(data))
+}
+
+// condition returns the SQL condition to continue after the cursor, with the
+// created_at, id and tiebreak columns in the order direction. The legacy offset
+// cursor has no id, and continues strictly after the created_at as before. A
+// cursor without the tiebreak continues strictly after the id.
+func (c *Cursor) condition(idColumn, tiebreakColumn, order string) (string, map[string]interface{}) {
+	if c == nil {
+		return "", nil
+	}
+	cmp := ">"
+	if order == "DESC" {
+		cmp = "<"
+	}
+	if c.Id == "" {
+		return "created_at" + cmp + "@cursor_created", map[string]interface{}{"cursor_created": c.CreatedAt}
+	}
+	params := map[string]interface{}{"cursor_created": c.CreatedAt, "cursor_id": c.Id}
+	id := idColumn + cmp + "@cursor_id"
+	if tiebreakColumn != "" && c.Tiebreak != "" {
+		id = "(" + id + " OR (" + idColumn + "=@cursor_id AND " + tiebreakColumn + cmp + "@cursor_tiebreak))"
+		params["cursor_tiebreak"] = c.Tiebreak
+	}
+	sql := "(created_at" + cmp + "@cursor_created OR (created_at=@cursor_created AND " + id + "))"
+	return sql, params
+}
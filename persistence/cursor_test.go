@@ -0,0 +1,48 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor(t *testing.T) {
+	assert := assert.New(t)
+	createdAt := time.Now().UTC()
+
+	cursor := &Cursor{CreatedAt: createdAt, Id: "id"}
+	parsed := ParseCursor(cursor.String())
+	assert.True(createdAt.Equal(parsed.CreatedAt))
+	assert.Equal("id", parsed.Id)
+	assert.Nil(ParseCursor("invalid"))
+
+	cond, params := cursor.condition("trade_id", "", "ASC")
+	assert.Equal("(created_at>@cursor_created OR (created_at=@cursor_created AND trade_id>@cursor_id))", cond)
+	assert.Len(params, 2)
+	q := &sqlQuery{}
+	cursor.sqlCondition(q, "trade_id", "", "DESC")
+	assert.Equal(" WHERE (created_at<$1 OR (created_at=$2 AND trade_id<$3))", q.where())
+
+	offset := &Cursor{CreatedAt: createdAt}
+	cond, params = offset.condition("trade_id", "", "ASC")
+	assert.Equal("created_at>@cursor_created", cond)
+	assert.Len(params, 1)
+	q = &sqlQuery{}
+	offset.sqlCondition(q, "trade_id", "", "ASC")
+	assert.Equal(" WHERE created_at>$1", q.where())
+
+	// the tiebreak orders the rows of the same id
+	tiebreak := &Cursor{CreatedAt: createdAt, Id: "id", Tiebreak: TradeLiquidityMaker}
+	parsed = ParseCursor(tiebreak.String())
+	assert.Equal("id", parsed.Id)
+	assert.Equal(TradeLiquidityMaker, parsed.Tiebreak)
+	cond, params = tiebreak.condition("trade_id", "liquidity", "ASC")
+	assert.Equal("(created_at>@cursor_created OR (created_at=@cursor_created AND (trade_id>@cursor_id OR (trade_id=@cursor_id AND liquidity>@cursor_tiebreak))))", cond)
+	assert.Len(params, 3)
+	q = &sqlQuery{}
+	tiebreak.sqlCondition(q, "trade_id", "liquidity", "DESC")
+	assert.Equal(" WHERE (created_at<$1 OR (created_at=$2 AND (trade_id<$3 OR (trade_id=$4 AND liquidity<$5))))", q.where())
+	cond, _ = tiebreak.condition("trade_id", "", "ASC")
+	assert.Equal("(created_at>@cursor_created OR (created_at=@cursor_created AND trade_id>@cursor_id))", cond)
+}
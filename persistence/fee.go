@@ -0,0 +1,263 @@
+package persistence
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/config"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	FeeDiscountAssetId = config.MixinAssetId
+	FeeDiscountRate    = "0.25"
+
+	FeeBalanceSourceDeposit  = "DEPOSIT"
+	FeeBalanceSourceWithdraw = "WITHDRAW"
+	FeeBalanceSourceCharge   = "CHARGE"
+)
+
+type FeeBalance struct {
+	UserId    string    `spanner:"user_id"`
+	AssetId   string    `spanner:"asset_id"`
+	Balance   string    `spanner:"balance"`
+	Enabled   bool      `spanner:"enabled"`
+	UpdatedAt time.Time `spanner:"updated_at"`
+}
+
+type FeeBalanceEntry struct {
+	UserId    string    `spanner:"user_id"`
+	EntryId   string    `spanner:"entry_id"`
+	Source    string    `spanner:"source"`
+	Amount    string    `spanner:"amount"`
+	CreatedAt time.Time `spanner:"created_at"`
+}
+
+// FeeBalanceUpdate is a snapshot paid to the fee balance of the user.
+type FeeBalanceUpdate struct {
+	BrokerId  string
+	UserId    string
+	Amount    number.Decimal
+	Enabled   *bool
+	Withdraw  bool
+	Trace     string
+	CreatedAt time.Time
+}
+
+func (s *SpannerStore) ReadFeeBalance(ctx context.Context, userId string) (*FeeBalance, error) {
+	it := s.client.Single().Read(ctx, "fee_balances", spanner.Key{userId}, []string{"user_id", "asset_id", "balance", "enabled", "updated_at"})
+	defer it.Stop()
+
+	row, err := it.Next()
+	if err == iterator.Done {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var b FeeBalance
+	err = row.ToStruct(&b)
+	return &b, err
+}
+
+func (s *SpannerStore) UpdateFeeBalance(ctx context.Context, update *FeeBalanceUpdate) error {
+	_, err := s.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		exist, err := checkFeeBalanceEntry(ctx, txn, update.UserId, update.Trace)
+		if err != nil || exist {
+			return err
+		}
+		balance, err := readFeeBalance(ctx, txn, update.UserId)
+		if err != nil {
+			return err
+		}
+		entries, transfer := applyFeeBalanceUpdate(balance, update)
+
+		var mutations []*spanner.Mutation
+		if transfer != nil {
+			transferMutation, err := spanner.InsertStruct("transfers", transfer)
+			if err != nil {
+				return err
+			}
+			mutations = append(mutations, transferMutation)
+		}
+		for _, e := range entries {
+			entryMutation, err := spanner.InsertStruct("fee_balance_entries", e)
+			if err != nil {
+				return err
+			}
+			mutations = append(mutations, entryMutation)
+		}
+		balanceMutation, err := spanner.InsertOrUpdateStruct("fee_balances", balance)
+		if err != nil {
+			return err
+		}
+		mutations = append(mutations, balanceMutation)
+		return txn.BufferWrite(mutations)
+	})
+	return err
+}
+
+// applyFeeBalanceUpdate changes the balance by the update, and returns the
+// entries and the withdrawal transfer to write with the balance.
+func applyFeeBalanceUpdate(balance *FeeBalance, update *FeeBalanceUpdate) ([]*FeeBalanceEntry, *Transfer) {
+	entries := []*FeeBalanceEntry{{
+		UserId:    update.UserId,
+		EntryId:   update.Trace,
+		Source:    FeeBalanceSourceDeposit,
+		Amount:    update.Amount.Persist(),
+		CreatedAt: update.CreatedAt,
+	}}
+	balance.Balance = number.FromString(balance.Balance).Add(update.Amount).Persist()
+	if update.Enabled != nil {
+		balance.Enabled = *update.Enabled
+	}
+	balance.UpdatedAt = time.Now()
+	if !update.Withdraw {
+		return entries, nil
+	}
+
+	transfer := &Transfer{
+		TransferId: getSettlementId(update.Trace, FeeBalanceSourceWithdraw),
+		Source:     TransferSourceFeeWithdrawn,
+		Detail:     update.Trace,
+		AssetId:    balance.AssetId,
+		Amount:     balance.Balance,
+		Fee:        number.Zero().Persist(),
+		CreatedAt:  time.Now(),
+		UserId:     update.UserId,
+		BrokerId:   update.BrokerId,
+	}
+	entries = append(entries, &FeeBalanceEntry{
+		UserId:    update.UserId,
+		EntryId:   transfer.TransferId,
+		Source:    FeeBalanceSourceWithdraw,
+		Amount:    number.FromString(balance.Balance).Neg().Persist(),
+		CreatedAt: update.CreatedAt,
+	})
+	balance.Balance = number.Zero().Persist()
+	return entries, transfer
+}
+
+// chargeFeeBalances pays the trade fees from the prepaid fee balances of the
+// users who enabled it, the discounted fee is charged in FeeDiscountAssetId and
+// the full proceeds are paid out. It falls back to the normal deduction when the
+// balance is short or the fee asset has no price. The discounted result tells
+// which trades are charged from the fee balances.
+func chargeFeeBalances(ctx context.Context, r settlementReader, trades []*Trade, transfers []*Transfer) ([]*FeeBalanceEntry, []*FeeBalance, []bool, error) {
+	balances, charged := make(map[string]*FeeBalance), make(map[string]bool)
+	var entries []*FeeBalanceEntry
+	var updated []*FeeBalance
+	discounted := make([]bool, len(trades))
+	for i, trade := range trades {
+		fee := number.FromString(trade.FeeAmount)
+		if fee.Sign() <= 0 {
+			continue
+		}
+		balance := balances[trade.UserId]
+		if balance == nil {
+			b, err := r.readFeeBalance(ctx, trade.UserId)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			balance, balances[trade.UserId] = b, b
+		}
+		if !balance.Enabled {
+			continue
+		}
+		price, err := feeDiscountPrice(ctx, r, trade.FeeAssetId)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if price.Sign() <= 0 {
+			continue
+		}
+		discount := number.FromString("1").Sub(number.FromString(FeeDiscountRate))
+		charge := fee.Mul(price).Mul(discount).RoundCeil(8)
+		remaining := number.FromString(balance.Balance).Sub(charge)
+		if remaining.Sign() < 0 {
+			continue
+		}
+
+		transfer := transfers[i]
+		transfer.Amount = number.FromString(transfer.Amount).Add(fee).Persist()
+		transfer.Fee = number.Zero().Persist()
+		trade.FeeAssetId = balance.AssetId
+		trade.FeeAmount = charge.Persist()
+		discounted[i] = true
+		balance.Balance = remaining.Persist()
+		balance.UpdatedAt = time.Now()
+		if !charged[trade.UserId] {
+			charged[trade.UserId] = true
+			updated = append(updated, balance)
+		}
+
+		entries = append(entries, &FeeBalanceEntry{
+			UserId:    trade.UserId,
+			EntryId:   transfer.TransferId,
+			Source:    FeeBalanceSourceCharge,
+			Amount:    charge.Neg().Persist(),
+			CreatedAt: trade.CreatedAt,
+		})
+	}
+	return entries, updated, discounted, nil
+}
+
+// feeDiscountPrice returns the price of the asset in FeeDiscountAssetId, using
+// the last trade of the market between them in either direction, read in the
+// settlement transaction. The price is zero without a direct market, there is
+// no conversion through other assets, so the fee is deducted undiscounted.
+func feeDiscountPrice(ctx context.Context, r settlementReader, assetId string) (number.Decimal, error) {
+	if assetId == FeeDiscountAssetId {
+		return number.FromString("1"), nil
+	}
+	t, err := r.lastTrade(ctx, assetId, FeeDiscountAssetId)
+	if err != nil || t != nil {
+		return feeDiscountTradePrice(t, false), err
+	}
+	t, err = r.lastTrade(ctx, FeeDiscountAssetId, assetId)
+	return feeDiscountTradePrice(t, true), err
+}
+
+func feeDiscountTradePrice(t *Trade, inverse bool) number.Decimal {
+	if t == nil {
+		return number.Zero()
+	}
+	price := number.FromString(t.Price)
+	if !inverse || price.Sign() <= 0 {
+		return price
+	}
+	return number.FromString("1").Div(price)
+}
+
+func readFeeBalance(ctx context.Context, txn *spanner.ReadWriteTransaction, userId string) (*FeeBalance, error) {
+	it := txn.Read(ctx, "fee_balances", spanner.Key{userId}, []string{"user_id", "asset_id", "balance", "enabled", "updated_at"})
+	defer it.Stop()
+
+	row, err := it.Next()
+	if err == iterator.Done {
+		return newFeeBalance(userId), nil
+	} else if err != nil {
+		return nil, err
+	}
+	var b FeeBalance
+	err = row.ToStruct(&b)
+	return &b, err
+}
+
+func checkFeeBalanceEntry(ctx context.Context, txn *spanner.ReadWriteTransaction, userId, entryId string) (bool, error) {
+	it := txn.Read(ctx, "fee_balance_entries", spanner.Key{userId, entryId}, []string{"created_at"})
+	defer it.Stop()
+
+	_, err := it.Next()
+	if err == iterator.Done {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func newFeeBalance(userId string) *FeeBalance {
+	return &FeeBalance{UserId: userId, AssetId: FeeDiscountAssetId, Balance: number.Zero().Persist()}
+}
@@ -0,0 +1,128 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyFeeBalanceUpdate(t *testing.T) {
+	assert := assert.New(t)
+	createdAt := time.Now()
+
+	balance := newFeeBalance("user")
+	enabled := true
+	entries, transfer := applyFeeBalanceUpdate(balance, &FeeBalanceUpdate{UserId: "user", Amount: number.FromString("1.5"), Enabled: &enabled, Trace: "deposit", CreatedAt: createdAt})
+	assert.Nil(transfer)
+	assert.Len(entries, 1)
+	assert.Equal(FeeBalanceSourceDeposit, entries[0].Source)
+	assert.Equal("deposit", entries[0].EntryId)
+	assert.Equal("1.5", entries[0].Amount)
+	assert.Equal("1.5", balance.Balance)
+	assert.True(balance.Enabled)
+
+	entries, transfer = applyFeeBalanceUpdate(balance, &FeeBalanceUpdate{UserId: "user", Amount: number.FromString("0.5"), Trace: "keep", CreatedAt: createdAt})
+	assert.Nil(transfer)
+	assert.Len(entries, 1)
+	assert.Equal("2", balance.Balance)
+	assert.True(balance.Enabled)
+
+	enabled = false
+	entries, transfer = applyFeeBalanceUpdate(balance, &FeeBalanceUpdate{BrokerId: "broker", UserId: "user", Amount: number.FromString("0.1"), Enabled: &enabled, Withdraw: true, Trace: "withdraw", CreatedAt: createdAt})
+	assert.False(balance.Enabled)
+	assert.Equal("0", balance.Balance)
+	assert.Len(entries, 2)
+	assert.Equal("0.1", entries[0].Amount)
+	assert.Equal(FeeBalanceSourceWithdraw, entries[1].Source)
+	assert.Equal(transfer.TransferId, entries[1].EntryId)
+	assert.Equal("-2.1", entries[1].Amount)
+	assert.Equal(TransferSourceFeeWithdrawn, transfer.Source)
+	assert.Equal(FeeDiscountAssetId, transfer.AssetId)
+	assert.Equal("2.1", transfer.Amount)
+	assert.Equal("broker", transfer.BrokerId)
+	assert.Equal("user", transfer.UserId)
+}
+
+func TestChargeFeeBalances(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	r := &testSettlementReader{
+		balances: map[string]*FeeBalance{
+			"enabled":  {UserId: "enabled", AssetId: FeeDiscountAssetId, Balance: "1", Enabled: true},
+			"short":    {UserId: "short", AssetId: FeeDiscountAssetId, Balance: "0.0001", Enabled: true},
+			"disabled": {UserId: "disabled", AssetId: FeeDiscountAssetId, Balance: "1"},
+		},
+		trades: map[string]*Trade{
+			"quote-" + FeeDiscountAssetId: {Price: "0.5"},
+			FeeDiscountAssetId + "-base":  {Price: "4"},
+		},
+	}
+
+	trades := []*Trade{
+		{TradeId: "t1", UserId: "enabled", FeeAssetId: "quote", FeeAmount: "0.02", CreatedAt: time.Now()},
+		{TradeId: "t1", UserId: "disabled", FeeAssetId: "base", FeeAmount: "0.002"},
+		{TradeId: "t2", UserId: "enabled", FeeAssetId: "base", FeeAmount: "0.002"},
+		{TradeId: "t3", UserId: "short", FeeAssetId: "quote", FeeAmount: "0.02"},
+		{TradeId: "t4", UserId: "enabled", FeeAssetId: "unpriced", FeeAmount: "1"},
+		{TradeId: "t5", UserId: "enabled", FeeAssetId: "quote", FeeAmount: "-0.01"},
+	}
+	transfers := make([]*Transfer, len(trades))
+	for i, trade := range trades {
+		transfers[i] = &Transfer{TransferId: trade.TradeId + trade.UserId, AssetId: trade.FeeAssetId, Amount: "10", Fee: trade.FeeAmount}
+	}
+	entries, balances, discounted, err := chargeFeeBalances(ctx, r, trades, transfers)
+	assert.Nil(err)
+	assert.Equal([]bool{true, false, true, false, false, false}, discounted)
+
+	// 0.02 quote at 0.5 XIN with 25% discount, and 0.002 base at 1/4 XIN
+	assert.Len(entries, 2)
+	assert.Equal("-0.0075", entries[0].Amount)
+	assert.Equal(FeeBalanceSourceCharge, entries[0].Source)
+	assert.Equal(transfers[0].TransferId, entries[0].EntryId)
+	assert.Equal("-0.000375", entries[1].Amount)
+	assert.Len(balances, 1)
+	assert.Equal("0.992125", balances[0].Balance)
+
+	assert.Equal(FeeDiscountAssetId, trades[0].FeeAssetId)
+	assert.Equal("0.0075", trades[0].FeeAmount)
+	assert.Equal("10.02", transfers[0].Amount)
+	assert.Equal("0", transfers[0].Fee)
+	assert.Equal(FeeDiscountAssetId, trades[2].FeeAssetId)
+	assert.Equal("10.002", transfers[2].Amount)
+	// no direct XIN market, the undiscounted fee stays in the trade asset
+	assert.Equal("unpriced", trades[4].FeeAssetId)
+	assert.Equal("1", trades[4].FeeAmount)
+	for _, i := range []int{1, 3, 4, 5} {
+		assert.Equal(trades[i].FeeAmount, transfers[i].Fee)
+		assert.Equal("10", transfers[i].Amount)
+	}
+}
+
+type testSettlementReader struct {
+	balances map[string]*FeeBalance
+	trades   map[string]*Trade
+	shares   map[string]string
+	candles  []*Candle
+}
+
+func (r *testSettlementReader) readFeeBalance(ctx context.Context, userId string) (*FeeBalance, error) {
+	if b := r.balances[userId]; b != nil {
+		return b, nil
+	}
+	return newFeeBalance(userId), nil
+}
+
+func (r *testSettlementReader) readBrokerRevenueShare(ctx context.Context, brokerId string) (number.Decimal, error) {
+	return brokerRevenueShare(r.shares[brokerId]), nil
+}
+
+func (r *testSettlementReader) readCandles(ctx context.Context, candles []*Candle) ([]*Candle, error) {
+	return r.candles, nil
+}
+
+func (r *testSettlementReader) lastTrade(ctx context.Context, base, quote string) (*Trade, error) {
+	return r.trades[base+"-"+quote], nil
+}
@@ -0,0 +1,171 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/MixinNetwork/ocean.one/cache"
+	"google.golang.org/api/iterator"
+)
+
+type BookEvent struct {
+	Market    string    `spanner:"market"`
+	Sequence  int64     `spanner:"sequence"`
+	Type      string    `spanner:"type"`
+	Data      string    `spanner:"data"`
+	CreatedAt time.Time `spanner:"created_at"`
+}
+
+// SpannerJournal is the event journal in the book_events table.
+type SpannerJournal struct {
+	client *spanner.Client
+}
+
+func NewSpannerJournal(client *spanner.Client) *SpannerJournal {
+	return &SpannerJournal{client: client}
+}
+
+func (j *SpannerJournal) Append(ctx context.Context, events ...*cache.Event) error {
+	var mutations []*spanner.Mutation
+	for _, e := range events {
+		be, err := newBookEvent(e)
+		if err != nil {
+			return err
+		}
+		mutation, err := spanner.InsertOrUpdateStruct("book_events", be)
+		if err != nil {
+			return err
+		}
+		mutations = append(mutations, mutation)
+	}
+	_, err := j.client.Apply(ctx, mutations)
+	return err
+}
+
+func (j *SpannerJournal) Range(ctx context.Context, market string, from, to int64, limit int) ([]*cache.Event, error) {
+	query := "SELECT * FROM book_events WHERE market=@market AND sequence>=@from AND sequence<=@to ORDER BY market,sequence,type DESC"
+	if limit > 0 {
+		query = fmt.Sprintf("%s LIMIT %d", query, limit)
+	}
+	it := j.client.Single().Query(ctx, spanner.Statement{
+		SQL:    query,
+		Params: map[string]interface{}{"market": market, "from": from, "to": to},
+	})
+	return readBookEvents(it)
+}
+
+func (j *SpannerJournal) Snapshot(ctx context.Context, market string, sequence int64) (*cache.Event, error) {
+	it := j.client.Single().Query(ctx, spanner.Statement{
+		SQL:    "SELECT * FROM book_events@{FORCE_INDEX=book_events_by_market_type_sequence} WHERE market=@market AND type=@type AND sequence<=@sequence ORDER BY market,type,sequence DESC LIMIT 1",
+		Params: map[string]interface{}{"market": market, "type": "BOOK-T0", "sequence": sequence},
+	})
+	events, err := readBookEvents(it)
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+	return events[0], nil
+}
+
+func (j *SpannerJournal) Last(ctx context.Context, market string) (int64, error) {
+	it := j.client.Single().Query(ctx, spanner.Statement{
+		SQL:    "SELECT sequence FROM book_events WHERE market=@market ORDER BY market,sequence DESC LIMIT 1",
+		Params: map[string]interface{}{"market": market},
+	})
+	defer it.Stop()
+
+	row, err := it.Next()
+	if err == iterator.Done {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var sequence int64
+	err = row.Columns(&sequence)
+	return sequence, err
+}
+
+func (j *SpannerJournal) Compact(ctx context.Context, market string, before time.Time) error {
+	it := j.client.Single().Query(ctx, spanner.Statement{
+		SQL:    "SELECT sequence FROM book_events@{FORCE_INDEX=book_events_by_market_type_sequence} WHERE market=@market AND type=@type AND created_at<=@before ORDER BY market,type,sequence DESC LIMIT 1",
+		Params: map[string]interface{}{"market": market, "type": "BOOK-T0", "before": before},
+	})
+	defer it.Stop()
+
+	row, err := it.Next()
+	if err == iterator.Done {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var sequence int64
+	err = row.Columns(&sequence)
+	if err != nil {
+		return err
+	}
+	_, err = j.client.Apply(ctx, []*spanner.Mutation{
+		spanner.Delete("book_events", spanner.KeyRange{
+			Start: spanner.Key{market},
+			End:   spanner.Key{market, sequence},
+			Kind:  spanner.ClosedOpen,
+		}),
+	})
+	return err
+}
+
+func readBookEvents(it *spanner.RowIterator) ([]*cache.Event, error) {
+	defer it.Stop()
+
+	var events []*cache.Event
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		var be BookEvent
+		err = row.ToStruct(&be)
+		if err != nil {
+			return nil, err
+		}
+		e, err := be.event()
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func newBookEvent(e *cache.Event) (*BookEvent, error) {
+	sequence, err := strconv.ParseInt(e.Sequence, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &BookEvent{
+		Market:    e.Market,
+		Sequence:  sequence,
+		Type:      e.Type,
+		Data:      string(data),
+		CreatedAt: e.Timestamp,
+	}, nil
+}
+
+func (be *BookEvent) event() (*cache.Event, error) {
+	e := &cache.Event{
+		Market:    be.Market,
+		Type:      be.Type,
+		Sequence:  strconv.FormatInt(be.Sequence, 10),
+		Timestamp: be.CreatedAt,
+	}
+	err := json.Unmarshal([]byte(be.Data), &e.Data)
+	return e, err
+}
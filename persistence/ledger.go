@@ -0,0 +1,161 @@
+package persistence
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/MixinNetwork/go-number"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	FeeSourceTrade  = "TRADE"
+	FeeSourceRebate = "REBATE"
+	FeeSourceRefund = "REFUND"
+	FeeSourcePayout = "PAYOUT"
+
+	FeePeriodDay   = "DAY"
+	FeePeriodMonth = "MONTH"
+
+	feeDayLayout = "2006-01-02"
+)
+
+type Fee struct {
+	Day       string    `spanner:"day"`
+	Market    string    `spanner:"market"`
+	AssetId   string    `spanner:"asset_id"`
+	FeeId     string    `spanner:"fee_id"`
+	Source    string    `spanner:"source"`
+	Amount    string    `spanner:"amount"`
+	CreatedAt time.Time `spanner:"created_at"`
+}
+
+type FeeRevenue struct {
+	Period  string `json:"period"`
+	Market  string `json:"market,omitempty"`
+	AssetId string `json:"asset_id"`
+	Source  string `json:"source"`
+	Amount  string `json:"amount"`
+	Count   int64  `json:"count"`
+}
+
+func makeTradeFees(trades []*Trade) []*Fee {
+	var fees []*Fee
+	for _, t := range trades {
+		amount := number.FromString(t.FeeAmount)
+		if amount.Sign() == 0 {
+			continue
+		}
+		source := FeeSourceTrade
+		if amount.Sign() < 0 {
+			source = FeeSourceRebate
+		}
+		fees = append(fees, &Fee{
+			Day:       t.CreatedAt.UTC().Format(feeDayLayout),
+			Market:    t.BaseAssetId + "-" + t.QuoteAssetId,
+			AssetId:   t.FeeAssetId,
+			FeeId:     getSettlementId(t.TradeId, t.Liquidity),
+			Source:    source,
+			Amount:    amount.Persist(),
+			CreatedAt: t.CreatedAt,
+		})
+	}
+	return fees
+}
+
+func makeRefundFee(transfer *Transfer) *Fee {
+	return &Fee{
+		Day:       transfer.CreatedAt.UTC().Format(feeDayLayout),
+		AssetId:   transfer.AssetId,
+		FeeId:     transfer.TransferId,
+		Source:    FeeSourceRefund,
+		Amount:    transfer.Fee,
+		CreatedAt: transfer.CreatedAt,
+	}
+}
+
+// makePayoutFee deducts the broker payout from the fee revenue of the day.
+func makePayoutFee(payout *BrokerPayout) *Fee {
+	return &Fee{
+		Day:       payout.CreatedAt.UTC().Format(feeDayLayout),
+		AssetId:   payout.AssetId,
+		FeeId:     payout.PayoutId,
+		Source:    FeeSourcePayout,
+		Amount:    number.FromString(payout.Amount).Neg().Persist(),
+		CreatedAt: payout.CreatedAt,
+	}
+}
+
+func (s *SpannerStore) ReadFees(ctx context.Context, start, end string) ([]*Fee, error) {
+	keys := spanner.KeyRange{
+		Start: spanner.Key{start},
+		End:   spanner.Key{end},
+		Kind:  spanner.ClosedClosed,
+	}
+	it := s.client.Single().Read(ctx, "fees", keys, []string{"day", "market", "asset_id", "source", "amount"})
+	defer it.Stop()
+
+	var fees []*Fee
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			return fees, nil
+		} else if err != nil {
+			return nil, err
+		}
+		var f Fee
+		err = row.Columns(&f.Day, &f.Market, &f.AssetId, &f.Source, &f.Amount)
+		if err != nil {
+			return nil, err
+		}
+		fees = append(fees, &f)
+	}
+}
+
+// AggregateFeeRevenues sums the fee ledger between the start and end days, by
+// period and asset, and also by market if byMarket is set.
+func AggregateFeeRevenues(ctx context.Context, start, end time.Time, period string, byMarket bool) ([]*FeeRevenue, error) {
+	fees, err := Backend(ctx).ReadFees(ctx, start.UTC().Format(feeDayLayout), end.UTC().Format(feeDayLayout))
+	if err != nil {
+		return nil, err
+	}
+
+	revenues := make(map[string]*FeeRevenue)
+	for _, f := range fees {
+		r := &FeeRevenue{Period: f.Day, AssetId: f.AssetId, Source: f.Source, Amount: "0"}
+		if period == FeePeriodMonth {
+			r.Period = f.Day[:7]
+		}
+		if byMarket {
+			r.Market = f.Market
+		}
+		key := r.Period + r.Market + r.AssetId + r.Source
+		if revenues[key] == nil {
+			revenues[key] = r
+		}
+		r = revenues[key]
+		r.Amount = number.FromString(r.Amount).Add(number.FromString(f.Amount)).Persist()
+		r.Count = r.Count + 1
+	}
+
+	result := make([]*FeeRevenue, 0)
+	for _, r := range revenues {
+		result = append(result, r)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Period != b.Period {
+			return a.Period < b.Period
+		}
+		if a.Market != b.Market {
+			return a.Market < b.Market
+		}
+		if a.AssetId != b.AssetId {
+			return a.AssetId < b.AssetId
+		}
+		return a.Source < b.Source
+	})
+	return result, nil
+}
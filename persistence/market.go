@@ -5,21 +5,24 @@ import (
 	"fmt"
 	"sort"
 	"strings"
-	"time"
 
 	"cloud.google.com/go/spanner"
 	"github.com/gofrs/uuid/v5"
 	"google.golang.org/api/iterator"
 )
 
-func LastTrade(ctx context.Context, market string) (*Trade, error) {
-	base, quote := getBaseQuote(market)
-	if base == "" || quote == "" {
-		return nil, nil
-	}
+func (s *SpannerStore) LastTrade(ctx context.Context, base, quote string) (*Trade, error) {
+	return lastTrade(ctx, s.client.Single(), base, quote)
+}
 
-	it := Spanner(ctx).Single().Query(ctx, spanner.Statement{
-		SQL:    "SELECT * FROM trades@{FORCE_INDEX=trades_by_base_quote_created_desc} WHERE base_asset_id=@base AND quote_asset_id=@quote ORDER BY base_asset_id,quote_asset_id,created_at DESC",
+// spannerQueryer is either a read only or a read write transaction.
+type spannerQueryer interface {
+	Query(ctx context.Context, statement spanner.Statement) *spanner.RowIterator
+}
+
+func lastTrade(ctx context.Context, txn spannerQueryer, base, quote string) (*Trade, error) {
+	it := txn.Query(ctx, spanner.Statement{
+		SQL:    "SELECT * FROM trades@{FORCE_INDEX=trades_by_base_quote_created_id_desc} WHERE base_asset_id=@base AND quote_asset_id=@quote ORDER BY base_asset_id,quote_asset_id,created_at DESC,trade_id DESC",
 		Params: map[string]interface{}{"base": base, "quote": quote},
 	})
 	defer it.Stop()
@@ -35,29 +38,20 @@ func LastTrade(ctx context.Context, market string) (*Trade, error) {
 	return &t, err
 }
 
-func MarketTrades(ctx context.Context, market string, offset time.Time, order string, limit int) ([]*Trade, error) {
-	txn := Spanner(ctx).ReadOnlyTransaction()
+func (s *SpannerStore) MarketTrades(ctx context.Context, base, quote string, cursor *Cursor, order string, limit int) ([]*Trade, *Cursor, error) {
+	txn := s.client.ReadOnlyTransaction()
 	defer txn.Close()
 
-	if limit > 100 {
-		limit = 100
-	}
-	cmp := "<"
-	if order != "DESC" {
-		order = "ASC"
-		cmp = ">"
-	}
-
-	base, quote := getBaseQuote(market)
-	if base == "" || quote == "" {
-		return nil, nil
+	query := "SELECT trade_id FROM trades@{FORCE_INDEX=trades_by_base_quote_created_id_%s} WHERE base_asset_id=@base AND quote_asset_id=@quote AND liquidity=@liquidity"
+	query = fmt.Sprintf(query, strings.ToLower(order))
+	params := map[string]interface{}{"base": base, "quote": quote, "liquidity": TradeLiquidityMaker}
+	if cond, cp := cursor.condition("trade_id", "", order); cond != "" {
+		query = query + " AND " + cond
+		for k, v := range cp {
+			params[k] = v
+		}
 	}
-
-	query := "SELECT trade_id FROM trades@{FORCE_INDEX=trades_by_base_quote_created_%s} WHERE base_asset_id=@base AND quote_asset_id=@quote AND created_at%s=@offset AND liquidity=@liquidity"
-	query = fmt.Sprintf(query, strings.ToLower(order), cmp)
-	query = query + " ORDER BY base_asset_id,quote_asset_id,created_at " + order
-	query = fmt.Sprintf("%s LIMIT %d", query, limit)
-	params := map[string]interface{}{"base": base, "quote": quote, "offset": offset, "liquidity": TradeLiquidityMaker}
+	query = query + fmt.Sprintf(" ORDER BY base_asset_id,quote_asset_id,created_at %s,trade_id %s LIMIT %d", order, order, limit)
 
 	iit := txn.Query(ctx, spanner.Statement{SQL: query, Params: params})
 	defer iit.Stop()
@@ -68,12 +62,12 @@ func MarketTrades(ctx context.Context, market string, offset time.Time, order st
 		if err == iterator.Done {
 			break
 		} else if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		var id string
 		err = row.Columns(&id)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		tradeIds = append(tradeIds, id)
 	}
@@ -90,21 +84,30 @@ func MarketTrades(ctx context.Context, market string, offset time.Time, order st
 		if err == iterator.Done {
 			break
 		} else if err != nil {
-			return trades, err
+			return nil, nil, err
 		}
 		var t Trade
 		err = row.ToStruct(&t)
 		if err != nil {
-			return trades, err
+			return nil, nil, err
 		}
 		trades = append(trades, &t)
 	}
-	if order == "DESC" {
-		sort.Slice(trades, func(i, j int) bool { return trades[i].CreatedAt.After(trades[j].CreatedAt) })
-	} else {
-		sort.Slice(trades, func(i, j int) bool { return trades[i].CreatedAt.Before(trades[j].CreatedAt) })
+	sort.Slice(trades, func(i, j int) bool {
+		a, b := trades[i], trades[j]
+		if order == "DESC" {
+			a, b = b, a
+		}
+		if a.CreatedAt.Equal(b.CreatedAt) {
+			return a.TradeId < b.TradeId
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+	if len(tradeIds) < limit || len(trades) == 0 {
+		return trades, nil, nil
 	}
-	return trades, nil
+	last := trades[len(trades)-1]
+	return trades, &Cursor{CreatedAt: last.CreatedAt, Id: last.TradeId}, nil
 }
 
 func getBaseQuote(market string) (string, string) {
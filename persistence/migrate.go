@@ -0,0 +1,133 @@
+package persistence
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/spanner"
+	dbadmin "cloud.google.com/go/spanner/admin/database/apiv1"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	"google.golang.org/grpc/codes"
+)
+
+const PropertySchemaVersion = "persistence-schema-version"
+
+// SQLSchemaVersion is the migration version postgres.sql and sqlite.sql are
+// equal to, both schemas are updated with every new migration and record the
+// version in the properties table when created.
+const SQLSchemaVersion = 12
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a versioned DDL batch of the Spanner schema, the files of the
+// migrations directory are named by the version, like 0001_create_exchange.sql.
+type Migration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+// Migrations reads all the migrations, their versions start from 1 without gaps.
+func Migrations() ([]*Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []*Migration
+	for i, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".sql")
+		version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+		if err != nil || version != i+1 {
+			return nil, fmt.Errorf("invalid migration %s", e.Name())
+		}
+		data, err := migrationFiles.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m := &Migration{Version: version, Name: name}
+		for _, s := range strings.Split(string(data), ";") {
+			if s = strings.TrimSpace(s); s != "" {
+				m.Statements = append(m.Statements, s)
+			}
+		}
+		migrations = append(migrations, m)
+	}
+	return migrations, nil
+}
+
+// MigrateSpanner applies the migrations after the version of the properties
+// table, and records the version after each of them. All statements create
+// the tables, indexes and columns only if missing, so a database created
+// before the migrations is migrated from the version 0.
+func MigrateSpanner(ctx context.Context, client *spanner.Client) (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	store := NewSpannerStore(client)
+	version, err := store.readSchemaVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if version >= len(migrations) {
+		return version, nil
+	}
+
+	admin, err := dbadmin.NewDatabaseAdminClient(ctx)
+	if err != nil {
+		return version, err
+	}
+	defer admin.Close()
+
+	for _, m := range migrations[version:] {
+		op, err := admin.UpdateDatabaseDdl(ctx, &databasepb.UpdateDatabaseDdlRequest{
+			Database:   client.DatabaseName(),
+			Statements: m.Statements,
+		})
+		if err != nil {
+			return version, err
+		}
+		err = op.Wait(ctx)
+		if err != nil {
+			return version, err
+		}
+		err = store.WriteProperty(ctx, PropertySchemaVersion, strconv.Itoa(m.Version))
+		if err != nil {
+			return version, err
+		}
+		version = m.Version
+		log.Println("MigrateSpanner", m.Name)
+	}
+	return version, nil
+}
+
+// readSchemaVersion is 0 if the properties table is not created yet.
+func (s *SpannerStore) readSchemaVersion(ctx context.Context) (int, error) {
+	value, err := s.ReadProperty(ctx, PropertySchemaVersion)
+	if spanner.ErrCode(err) == codes.NotFound {
+		return 0, nil
+	} else if err != nil || value == "" {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// checkSchemaVersion fails unless the SQL database records the SQLSchemaVersion,
+// there are no SQL migrations, so a database of another version is migrated
+// by hand with the statements of the migrations after its version.
+func (s *SQLStore) checkSchemaVersion(ctx context.Context) error {
+	value, err := s.ReadProperty(ctx, PropertySchemaVersion)
+	if err != nil {
+		return err
+	}
+	if value != strconv.Itoa(SQLSchemaVersion) {
+		return fmt.Errorf("persistence schema version %q, expected %d", value, SQLSchemaVersion)
+	}
+	return nil
+}
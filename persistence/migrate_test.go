@@ -0,0 +1,80 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrations(t *testing.T) {
+	assert := assert.New(t)
+	migrations, err := Migrations()
+	assert.Nil(err)
+	assert.Len(migrations, 12)
+
+	var schema []string
+	for i, m := range migrations {
+		assert.Equal(i+1, m.Version)
+		assert.NotEmpty(m.Statements)
+		schema = append(schema, m.Statements...)
+	}
+	ddl := strings.Join(schema, "\n")
+	for _, name := range []string{
+		"TABLE IF NOT EXISTS properties ", "TABLE IF NOT EXISTS brokers ", "TABLE IF NOT EXISTS orders ",
+		"TABLE IF NOT EXISTS actions ", "TABLE IF NOT EXISTS trades ", "TABLE IF NOT EXISTS transfers ",
+		"TABLE IF NOT EXISTS users ", "TABLE IF NOT EXISTS rejections ", "TABLE IF NOT EXISTS fee_balances ",
+		"TABLE IF NOT EXISTS fee_balance_entries ", "TABLE IF NOT EXISTS fees ", "TABLE IF NOT EXISTS broker_revenues ",
+		"TABLE IF NOT EXISTS broker_payouts ", "TABLE IF NOT EXISTS candles ", "TABLE IF NOT EXISTS book_events ",
+		"orders_by_user_state_created_asc ", "orders_by_user_state_created_desc ", "actions_by_created ",
+		"trades_by_base_quote_created_asc ", "trades_by_base_quote_created_desc ",
+		"orders_by_user_state_created_id_asc ", "orders_by_user_state_created_id_desc ",
+		"trades_by_base_quote_created_id_asc ", "trades_by_base_quote_created_id_desc ",
+		"trades_by_ask_order_created ", "trades_by_bid_order_created ",
+		"trades_by_user_created_asc ", "trades_by_user_created_desc ",
+		"trades_by_user_created_liquidity_asc ", "trades_by_user_created_liquidity_desc ",
+		"transfers_by_broker_created ", "users_by_public_key ", "book_events_by_market_type_sequence ",
+		"COLUMN IF NOT EXISTS revenue_share ", "COLUMN IF NOT EXISTS reason ", "COLUMN IF NOT EXISTS collector_id ",
+	} {
+		assert.Contains(ddl, name)
+	}
+}
+
+func TestSQLSchemaVersion(t *testing.T) {
+	assert := assert.New(t)
+	migrations, err := Migrations()
+	assert.Nil(err)
+	assert.Equal(len(migrations), SQLSchemaVersion)
+	record := fmt.Sprintf("('%s', '%d', CURRENT_TIMESTAMP)", PropertySchemaVersion, SQLSchemaVersion)
+	assert.Contains(postgresSchema, record)
+	assert.Contains(sqliteSchema, record)
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "ocean.db")
+	store, err := NewSQLiteStore(path)
+	assert.Nil(err)
+	version, err := store.ReadProperty(ctx, PropertySchemaVersion)
+	assert.Nil(err)
+	assert.Equal(fmt.Sprint(SQLSchemaVersion), version)
+	store.db.Close()
+	store, err = NewSQLiteStore(path)
+	assert.Nil(err)
+
+	assert.Nil(store.WriteProperty(ctx, PropertySchemaVersion, "10"))
+	store.db.Close()
+	_, err = NewSQLiteStore(path)
+	assert.EqualError(err, `persistence schema version "10", expected 12`)
+
+	path = filepath.Join(t.TempDir(), "ocean.db")
+	db, err := sql.Open("sqlite3", path)
+	assert.Nil(err)
+	_, err = db.Exec("CREATE TABLE properties (key VARCHAR(512) NOT NULL PRIMARY KEY, value VARCHAR(8192) NOT NULL, updated_at TIMESTAMP NOT NULL)")
+	assert.Nil(err)
+	db.Close()
+	_, err = NewSQLiteStore(path)
+	assert.EqualError(err, `persistence schema version "", expected 12`)
+}
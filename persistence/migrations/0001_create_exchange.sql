@@ -1,11 +1,11 @@
-CREATE TABLE properties (
+CREATE TABLE IF NOT EXISTS properties (
   key         STRING(512) NOT NULL,
   value       STRING(8192) NOT NULL,
   updated_at  TIMESTAMP NOT NULL,
 ) PRIMARY KEY(key);
 
 
-CREATE TABLE brokers (
+CREATE TABLE IF NOT EXISTS brokers (
   broker_id         STRING(36) NOT NULL,
   session_id        STRING(36) NOT NULL,
   session_key       STRING(1024) NOT NULL,
@@ -16,7 +16,7 @@ CREATE TABLE brokers (
 ) PRIMARY KEY(broker_id);
 
 
-CREATE TABLE orders (
+CREATE TABLE IF NOT EXISTS orders (
   order_id          STRING(36) NOT NULL,
   order_type        STRING(36) NOT NULL,
   quote_asset_id    STRING(36) NOT NULL,
@@ -33,21 +33,21 @@ CREATE TABLE orders (
   broker_id         STRING(36) NOT NULL,
 ) PRIMARY KEY(order_id);
 
-CREATE INDEX orders_by_user_state_created_desc ON orders(user_id, state, created_at DESC) STORING(quote_asset_id,base_asset_id);
-CREATE INDEX orders_by_user_state_created_asc ON orders(user_id, state, created_at ASC) STORING(quote_asset_id,base_asset_id);
+CREATE INDEX IF NOT EXISTS orders_by_user_state_created_desc ON orders(user_id, state, created_at DESC) STORING(quote_asset_id,base_asset_id);
+CREATE INDEX IF NOT EXISTS orders_by_user_state_created_asc ON orders(user_id, state, created_at ASC) STORING(quote_asset_id,base_asset_id);
 
 
-CREATE TABLE actions (
+CREATE TABLE IF NOT EXISTS actions (
   order_id     STRING(36) NOT NULL,
   action       STRING(36) NOT NULL,
   created_at   TIMESTAMP NOT NULL,
 ) PRIMARY KEY(order_id, action),
 INTERLEAVE IN PARENT orders ON DELETE CASCADE;
 
-CREATE INDEX actions_by_created ON actions(created_at);
+CREATE INDEX IF NOT EXISTS actions_by_created ON actions(created_at);
 
 
-CREATE TABLE trades (
+CREATE TABLE IF NOT EXISTS trades (
   trade_id          STRING(36) NOT NULL,
   liquidity         STRING(36) NOT NULL,
   ask_order_id      STRING(36) NOT NULL,
@@ -63,11 +63,11 @@ CREATE TABLE trades (
   fee_amount        STRING(128) NOT NULL,
 ) PRIMARY KEY(trade_id, liquidity);
 
-CREATE INDEX trades_by_base_quote_created_desc ON trades(base_asset_id, quote_asset_id, created_at DESC);
-CREATE INDEX trades_by_base_quote_created_asc ON trades(base_asset_id, quote_asset_id, created_at ASC);
+CREATE INDEX IF NOT EXISTS trades_by_base_quote_created_desc ON trades(base_asset_id, quote_asset_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS trades_by_base_quote_created_asc ON trades(base_asset_id, quote_asset_id, created_at ASC);
 
 
-CREATE TABLE transfers (
+CREATE TABLE IF NOT EXISTS transfers (
   transfer_id       STRING(36) NOT NULL,
   source            STRING(36) NOT NULL,
   detail            STRING(36) NOT NULL,
@@ -79,12 +79,12 @@ CREATE TABLE transfers (
   broker_id         STRING(36) NOT NULL,
 ) PRIMARY KEY(transfer_id);
 
-CREATE INDEX transfers_by_broker_created ON transfers(broker_id,created_at);
+CREATE INDEX IF NOT EXISTS transfers_by_broker_created ON transfers(broker_id,created_at);
 
 
-CREATE TABLE users (
+CREATE TABLE IF NOT EXISTS users (
   user_id          STRING(36) NOT NULL,
   public_key       STRING(512) NOT NULL,
 ) PRIMARY KEY(user_id);
 
-CREATE UNIQUE INDEX users_by_public_key ON users(public_key);
+CREATE UNIQUE INDEX IF NOT EXISTS users_by_public_key ON users(public_key);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS fee_balances (
+  user_id          STRING(36) NOT NULL,
+  asset_id         STRING(36) NOT NULL,
+  balance          STRING(128) NOT NULL,
+  enabled          BOOL NOT NULL,
+  updated_at       TIMESTAMP NOT NULL,
+) PRIMARY KEY(user_id);
+
+
+CREATE TABLE IF NOT EXISTS fee_balance_entries (
+  user_id          STRING(36) NOT NULL,
+  entry_id         STRING(36) NOT NULL,
+  source           STRING(36) NOT NULL,
+  amount           STRING(128) NOT NULL,
+  created_at       TIMESTAMP NOT NULL,
+) PRIMARY KEY(user_id, entry_id);
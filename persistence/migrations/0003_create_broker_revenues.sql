@@ -0,0 +1,19 @@
+ALTER TABLE brokers ADD COLUMN IF NOT EXISTS revenue_share STRING(128) NOT NULL DEFAULT ('0');
+
+
+CREATE TABLE IF NOT EXISTS broker_revenues (
+  broker_id        STRING(36) NOT NULL,
+  revenue_id       STRING(36) NOT NULL,
+  asset_id         STRING(36) NOT NULL,
+  amount           STRING(128) NOT NULL,
+  created_at       TIMESTAMP NOT NULL,
+) PRIMARY KEY(broker_id, revenue_id);
+
+
+CREATE TABLE IF NOT EXISTS broker_payouts (
+  broker_id        STRING(36) NOT NULL,
+  payout_id        STRING(36) NOT NULL,
+  asset_id         STRING(36) NOT NULL,
+  amount           STRING(128) NOT NULL,
+  created_at       TIMESTAMP NOT NULL,
+) PRIMARY KEY(broker_id, payout_id);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS fees (
+  day              STRING(10) NOT NULL,
+  market           STRING(73) NOT NULL,
+  asset_id         STRING(36) NOT NULL,
+  fee_id           STRING(36) NOT NULL,
+  source           STRING(36) NOT NULL,
+  amount           STRING(128) NOT NULL,
+  created_at       TIMESTAMP NOT NULL,
+) PRIMARY KEY(day, fee_id);
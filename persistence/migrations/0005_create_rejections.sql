@@ -0,0 +1,11 @@
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS reason STRING(36) NOT NULL DEFAULT ('');
+
+
+CREATE TABLE IF NOT EXISTS rejections (
+  trace_id         STRING(36) NOT NULL,
+  user_id          STRING(36) NOT NULL,
+  asset_id         STRING(36) NOT NULL,
+  amount           STRING(128) NOT NULL,
+  reason           STRING(36) NOT NULL,
+  created_at       TIMESTAMP NOT NULL,
+) PRIMARY KEY(trace_id);
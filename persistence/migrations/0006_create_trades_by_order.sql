@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS trades_by_ask_order_created ON trades(ask_order_id, created_at, trade_id);
+CREATE INDEX IF NOT EXISTS trades_by_bid_order_created ON trades(bid_order_id, created_at, trade_id);
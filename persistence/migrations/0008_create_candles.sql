@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS candles (
+  base             STRING(36) NOT NULL,
+  quote            STRING(36) NOT NULL,
+  granularity      INT64 NOT NULL,
+  point            INT64 NOT NULL,
+  open             STRING(128) NOT NULL,
+  close            STRING(128) NOT NULL,
+  high             STRING(128) NOT NULL,
+  low              STRING(128) NOT NULL,
+  volume           STRING(128) NOT NULL,
+  total            STRING(128) NOT NULL,
+) PRIMARY KEY(base, quote, granularity, point);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS book_events (
+  market           STRING(73) NOT NULL,
+  sequence         INT64 NOT NULL,
+  type             STRING(36) NOT NULL,
+  data             STRING(MAX) NOT NULL,
+  created_at       TIMESTAMP NOT NULL,
+) PRIMARY KEY(market, sequence, type);
+
+CREATE INDEX IF NOT EXISTS book_events_by_market_type_sequence ON book_events(market, type, sequence DESC) STORING(created_at);
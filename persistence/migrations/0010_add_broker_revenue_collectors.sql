@@ -0,0 +1 @@
+ALTER TABLE broker_revenues ADD COLUMN IF NOT EXISTS collector_id STRING(36) NOT NULL DEFAULT ('');
@@ -0,0 +1,4 @@
+CREATE INDEX IF NOT EXISTS orders_by_user_state_created_id_desc ON orders(user_id, state, created_at DESC, order_id DESC) STORING(quote_asset_id,base_asset_id);
+CREATE INDEX IF NOT EXISTS orders_by_user_state_created_id_asc ON orders(user_id, state, created_at ASC, order_id ASC) STORING(quote_asset_id,base_asset_id);
+CREATE INDEX IF NOT EXISTS trades_by_base_quote_created_id_desc ON trades(base_asset_id, quote_asset_id, created_at DESC, trade_id DESC);
+CREATE INDEX IF NOT EXISTS trades_by_base_quote_created_id_asc ON trades(base_asset_id, quote_asset_id, created_at ASC, trade_id ASC);
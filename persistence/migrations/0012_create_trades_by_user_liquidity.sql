@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS trades_by_user_created_liquidity_desc ON trades(user_id, created_at DESC, trade_id DESC, liquidity DESC);
+CREATE INDEX IF NOT EXISTS trades_by_user_created_liquidity_asc ON trades(user_id, created_at ASC, trade_id ASC, liquidity ASC);
@@ -0,0 +1,74 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+
+	"github.com/lib/pq"
+)
+
+//go:embed postgres.sql
+var postgresSchema string
+
+// NewPostgresStore opens the PostgreSQL database of the data source, the
+// schema is postgres.sql and the database must record the SQLSchemaVersion.
+func NewPostgresStore(dataSource string) (*SQLStore, error) {
+	store, err := openPostgresStore(dataSource)
+	if err != nil {
+		return nil, err
+	}
+	err = store.checkSchemaVersion(context.Background())
+	if err != nil {
+		store.db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// MigratePostgres creates the schema postgres.sql in a new database, and
+// checks the SQLSchemaVersion of an existing one.
+func MigratePostgres(ctx context.Context, dataSource string) (int, error) {
+	store, err := openPostgresStore(dataSource)
+	if err != nil {
+		return 0, err
+	}
+	defer store.db.Close()
+
+	var table sql.NullString
+	err = store.db.QueryRowContext(ctx, "SELECT to_regclass('properties')::text").Scan(&table)
+	if err != nil {
+		return 0, err
+	}
+	if !table.Valid {
+		_, err = store.db.ExecContext(ctx, postgresSchema)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = store.checkSchemaVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return SQLSchemaVersion, nil
+}
+
+func openPostgresStore(dataSource string) (*SQLStore, error) {
+	db, err := sql.Open("postgres", dataSource)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLStore{db: db, isolation: sql.LevelSerializable, retryable: postgresRetryable}, nil
+}
+
+// postgresRetryable tells the serialization failures and deadlocks, which
+// abort the serializable transactions under contention.
+func postgresRetryable(err error) bool {
+	e, ok := err.(*pq.Error)
+	return ok && (e.Code == "40001" || e.Code == "40P01")
+}
@@ -0,0 +1,185 @@
+CREATE TABLE properties (
+  key         VARCHAR(512) NOT NULL PRIMARY KEY,
+  value       VARCHAR(8192) NOT NULL,
+  updated_at  TIMESTAMPTZ NOT NULL
+);
+
+
+CREATE TABLE brokers (
+  broker_id         VARCHAR(36) NOT NULL PRIMARY KEY,
+  session_id        VARCHAR(36) NOT NULL,
+  session_key       VARCHAR(1024) NOT NULL,
+  pin_token         VARCHAR(512) NOT NULL,
+  encrypted_pin     VARCHAR(512) NOT NULL,
+  encryption_header BYTEA NOT NULL,
+  revenue_share     VARCHAR(128) NOT NULL DEFAULT '0',
+  created_at        TIMESTAMPTZ NOT NULL
+);
+
+
+CREATE TABLE orders (
+  order_id          VARCHAR(36) NOT NULL PRIMARY KEY,
+  order_type        VARCHAR(36) NOT NULL,
+  quote_asset_id    VARCHAR(36) NOT NULL,
+  base_asset_id     VARCHAR(36) NOT NULL,
+  side              VARCHAR(36) NOT NULL,
+  price             VARCHAR(128) NOT NULL,
+  remaining_amount  VARCHAR(128) NOT NULL,
+  filled_amount     VARCHAR(128) NOT NULL,
+  remaining_funds   VARCHAR(128) NOT NULL,
+  filled_funds      VARCHAR(128) NOT NULL,
+  created_at        TIMESTAMPTZ NOT NULL,
+  state             VARCHAR(36) NOT NULL,
+  user_id           VARCHAR(36) NOT NULL,
+  broker_id         VARCHAR(36) NOT NULL
+);
+
+CREATE INDEX orders_by_user_state_created ON orders(user_id, state, created_at, order_id);
+
+
+CREATE TABLE actions (
+  order_id     VARCHAR(36) NOT NULL REFERENCES orders(order_id) ON DELETE CASCADE,
+  action       VARCHAR(36) NOT NULL,
+  created_at   TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY(order_id, action)
+);
+
+CREATE INDEX actions_by_created ON actions(created_at);
+
+
+CREATE TABLE trades (
+  trade_id          VARCHAR(36) NOT NULL,
+  liquidity         VARCHAR(36) NOT NULL,
+  ask_order_id      VARCHAR(36) NOT NULL,
+  bid_order_id      VARCHAR(36) NOT NULL,
+  quote_asset_id    VARCHAR(36) NOT NULL,
+  base_asset_id     VARCHAR(36) NOT NULL,
+  side              VARCHAR(36) NOT NULL,
+  price             VARCHAR(128) NOT NULL,
+  amount            VARCHAR(128) NOT NULL,
+  created_at        TIMESTAMPTZ NOT NULL,
+  user_id           VARCHAR(36) NOT NULL,
+  fee_asset_id      VARCHAR(36) NOT NULL,
+  fee_amount        VARCHAR(128) NOT NULL,
+  PRIMARY KEY(trade_id, liquidity)
+);
+
+CREATE INDEX trades_by_base_quote_created ON trades(base_asset_id, quote_asset_id, created_at, trade_id);
+CREATE INDEX trades_by_ask_order_created ON trades(ask_order_id, created_at, trade_id);
+CREATE INDEX trades_by_bid_order_created ON trades(bid_order_id, created_at, trade_id);
+CREATE INDEX trades_by_user_created ON trades(user_id, created_at, trade_id, liquidity);
+
+
+CREATE TABLE transfers (
+  transfer_id       VARCHAR(36) NOT NULL PRIMARY KEY,
+  source            VARCHAR(36) NOT NULL,
+  detail            VARCHAR(36) NOT NULL,
+  asset_id          VARCHAR(36) NOT NULL,
+  amount            VARCHAR(128) NOT NULL,
+  fee               VARCHAR(128) NOT NULL,
+  reason            VARCHAR(36) NOT NULL DEFAULT '',
+  created_at        TIMESTAMPTZ NOT NULL,
+  user_id           VARCHAR(36) NOT NULL,
+  broker_id         VARCHAR(36) NOT NULL
+);
+
+CREATE INDEX transfers_by_broker_created ON transfers(broker_id, created_at);
+
+
+CREATE TABLE users (
+  user_id          VARCHAR(36) NOT NULL PRIMARY KEY,
+  public_key       VARCHAR(512) NOT NULL
+);
+
+CREATE UNIQUE INDEX users_by_public_key ON users(public_key);
+
+
+CREATE TABLE rejections (
+  trace_id         VARCHAR(36) NOT NULL PRIMARY KEY,
+  user_id          VARCHAR(36) NOT NULL,
+  asset_id         VARCHAR(36) NOT NULL,
+  amount           VARCHAR(128) NOT NULL,
+  reason           VARCHAR(36) NOT NULL,
+  created_at       TIMESTAMPTZ NOT NULL
+);
+
+
+CREATE TABLE fee_balances (
+  user_id          VARCHAR(36) NOT NULL PRIMARY KEY,
+  asset_id         VARCHAR(36) NOT NULL,
+  balance          VARCHAR(128) NOT NULL,
+  enabled          BOOLEAN NOT NULL,
+  updated_at       TIMESTAMPTZ NOT NULL
+);
+
+
+CREATE TABLE fee_balance_entries (
+  user_id          VARCHAR(36) NOT NULL,
+  entry_id         VARCHAR(36) NOT NULL,
+  source           VARCHAR(36) NOT NULL,
+  amount           VARCHAR(128) NOT NULL,
+  created_at       TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY(user_id, entry_id)
+);
+
+
+CREATE TABLE fees (
+  day              VARCHAR(10) NOT NULL,
+  market           VARCHAR(73) NOT NULL,
+  asset_id         VARCHAR(36) NOT NULL,
+  fee_id           VARCHAR(36) NOT NULL,
+  source           VARCHAR(36) NOT NULL,
+  amount           VARCHAR(128) NOT NULL,
+  created_at       TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY(day, fee_id)
+);
+
+
+CREATE TABLE broker_revenues (
+  broker_id        VARCHAR(36) NOT NULL,
+  revenue_id       VARCHAR(36) NOT NULL,
+  asset_id         VARCHAR(36) NOT NULL,
+  amount           VARCHAR(128) NOT NULL,
+  created_at       TIMESTAMPTZ NOT NULL,
+  collector_id     VARCHAR(36) NOT NULL DEFAULT '',
+  PRIMARY KEY(broker_id, revenue_id)
+);
+
+
+CREATE TABLE broker_payouts (
+  broker_id        VARCHAR(36) NOT NULL,
+  payout_id        VARCHAR(36) NOT NULL,
+  asset_id         VARCHAR(36) NOT NULL,
+  amount           VARCHAR(128) NOT NULL,
+  created_at       TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY(broker_id, payout_id)
+);
+
+
+CREATE TABLE candles (
+  base             VARCHAR(36) NOT NULL,
+  quote            VARCHAR(36) NOT NULL,
+  granularity      BIGINT NOT NULL,
+  point            BIGINT NOT NULL,
+  open             VARCHAR(128) NOT NULL,
+  close            VARCHAR(128) NOT NULL,
+  high             VARCHAR(128) NOT NULL,
+  low              VARCHAR(128) NOT NULL,
+  volume           VARCHAR(128) NOT NULL,
+  total            VARCHAR(128) NOT NULL,
+  PRIMARY KEY(base, quote, granularity, point)
+);
+
+
+CREATE TABLE book_events (
+  market           VARCHAR(73) NOT NULL,
+  sequence         BIGINT NOT NULL,
+  type             VARCHAR(36) NOT NULL,
+  data             TEXT NOT NULL,
+  created_at       TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY(market, sequence, type)
+);
+
+CREATE INDEX book_events_by_market_type_sequence ON book_events(market, type, sequence);
+
+INSERT INTO properties (key, value, updated_at) VALUES ('persistence-schema-version', '12', CURRENT_TIMESTAMP);
@@ -14,8 +14,8 @@ type Property struct {
 	UpdatedAt time.Time
 }
 
-func ReadProperty(ctx context.Context, key string) (string, error) {
-	it := Spanner(ctx).Single().Read(ctx, "properties", spanner.Key{key}, []string{"value"})
+func (s *SpannerStore) ReadProperty(ctx context.Context, key string) (string, error) {
+	it := s.client.Single().Read(ctx, "properties", spanner.Key{key}, []string{"value"})
 	defer it.Stop()
 
 	row, err := it.Next()
@@ -30,8 +30,8 @@ func ReadProperty(ctx context.Context, key string) (string, error) {
 	return value, err
 }
 
-func WriteProperty(ctx context.Context, key, value string) error {
-	_, err := Spanner(ctx).Apply(ctx, []*spanner.Mutation{
+func (s *SpannerStore) WriteProperty(ctx context.Context, key, value string) error {
+	_, err := s.client.Apply(ctx, []*spanner.Mutation{
 		spanner.InsertOrUpdate("properties", []string{"key", "value", "updated_at"}, []interface{}{key, value, time.Now()}),
 	})
 	return err
@@ -0,0 +1,48 @@
+package persistence
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	RejectReasonMemoInvalid    = "MEMO"
+	RejectReasonAssetInvalid   = "ASSET"
+	RejectReasonTypeInvalid    = "TYPE"
+	RejectReasonPairInvalid    = "PAIR"
+	RejectReasonPriceInvalid   = "PRICE"
+	RejectReasonPriceTooLarge  = "PRICE_MAX"
+	RejectReasonFundsTooLarge  = "FUNDS_MAX"
+	RejectReasonFundsTooSmall  = "FUNDS_MIN"
+	RejectReasonAmountTooLarge = "AMOUNT_MAX"
+	RejectReasonAmountTooSmall = "AMOUNT_MIN"
+	RejectReasonFeeAsset       = "FEE_ASSET"
+	RejectReasonFeeAction      = "FEE_ACTION"
+)
+
+type Rejection struct {
+	TraceId   string    `spanner:"trace_id"`
+	UserId    string    `spanner:"user_id"`
+	AssetId   string    `spanner:"asset_id"`
+	Amount    string    `spanner:"amount"`
+	Reason    string    `spanner:"reason"`
+	CreatedAt time.Time `spanner:"created_at"`
+}
+
+func (s *SpannerStore) UserRejection(ctx context.Context, traceId string) (*Rejection, error) {
+	it := s.client.Single().Read(ctx, "rejections", spanner.Key{traceId}, []string{"trace_id", "user_id", "asset_id", "amount", "reason", "created_at"})
+	defer it.Stop()
+
+	row, err := it.Next()
+	if err == iterator.Done {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var r Rejection
+	err = row.ToStruct(&r)
+	return &r, err
+}
@@ -0,0 +1,57 @@
+package persistence
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateRefundTransfer(t *testing.T) {
+	assert := assert.New(t)
+	ctx := testSQLiteContext(t)
+
+	err := CreateRefundTransfer(ctx, "broker", "user", "asset", number.FromString("9.99"), number.FromString("0.01"), "trace", RejectReasonPriceInvalid)
+	assert.Nil(err)
+	rejection, err := UserRejection(ctx, "trace", "user")
+	assert.Nil(err)
+	assert.Equal(RejectReasonPriceInvalid, rejection.Reason)
+	assert.Equal("asset", rejection.AssetId)
+	assert.Equal("10", rejection.Amount)
+	transfers, err := ListPendingTransfers(ctx, "broker", 10)
+	assert.Nil(err)
+	assert.Len(transfers, 1)
+	assert.Equal(TransferSourceOrderInvalid, transfers[0].Source)
+	assert.Equal(RejectReasonPriceInvalid, transfers[0].Reason)
+	assert.Equal("9.99", transfers[0].Amount)
+	assert.Equal("0.01", transfers[0].Fee)
+	assert.Equal("trace", transfers[0].Detail)
+
+	// the rejection is only visible to its user
+	rejection, err = UserRejection(ctx, "trace", "other")
+	assert.Nil(err)
+	assert.Nil(rejection)
+	rejection, err = UserRejection(ctx, "unknown", "user")
+	assert.Nil(err)
+	assert.Nil(rejection)
+
+	// an exhausted refund is recorded without a transfer
+	err = CreateRefundTransfer(ctx, "broker", "user", "asset", number.FromString("0.000000009"), number.FromString("0.00000000001"), "dust", RejectReasonFundsTooSmall)
+	assert.Nil(err)
+	rejection, err = UserRejection(ctx, "dust", "user")
+	assert.Nil(err)
+	assert.Equal(RejectReasonFundsTooSmall, rejection.Reason)
+	transfers, err = ListPendingTransfers(ctx, "broker", 10)
+	assert.Nil(err)
+	assert.Len(transfers, 1)
+}
+
+func testSQLiteContext(t *testing.T) context.Context {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "ocean.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return SetupBackend(context.Background(), store)
+}
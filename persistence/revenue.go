@@ -0,0 +1,230 @@
+package persistence
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/config"
+	"github.com/gofrs/uuid/v5"
+	"google.golang.org/api/iterator"
+)
+
+type BrokerRevenue struct {
+	BrokerId    string    `spanner:"broker_id"`
+	RevenueId   string    `spanner:"revenue_id"`
+	AssetId     string    `spanner:"asset_id"`
+	Amount      string    `spanner:"amount"`
+	CreatedAt   time.Time `spanner:"created_at"`
+	CollectorId string    `spanner:"collector_id"`
+}
+
+type BrokerPayout struct {
+	BrokerId  string    `spanner:"broker_id"`
+	PayoutId  string    `spanner:"payout_id"`
+	AssetId   string    `spanner:"asset_id"`
+	Amount    string    `spanner:"amount"`
+	CreatedAt time.Time `spanner:"created_at"`
+}
+
+// accrueBrokerRevenues shares the fee of each trade with the broker of the order
+// on that side, the share rate is configured per broker. The maker rebate of the
+// same trade is paid from the taker fee, so only the net fee is shared. The fee
+// is kept by the broker which pays the transfer of the trade, so the revenue is
+// paid out by that collector.
+func accrueBrokerRevenues(ctx context.Context, r settlementReader, trades []*Trade, transfers []*Transfer, brokerIds []string) ([]*BrokerRevenue, error) {
+	shares := make(map[string]number.Decimal)
+	var revenues []*BrokerRevenue
+	for i, trade := range trades {
+		fee := number.FromString(trade.FeeAmount)
+		if fee.Sign() <= 0 {
+			continue
+		}
+		fee = fee.Sub(makerRebate(fee))
+		if fee.Sign() <= 0 {
+			continue
+		}
+		brokerId := brokerIds[i]
+		share, found := shares[brokerId]
+		if !found {
+			s, err := r.readBrokerRevenueShare(ctx, brokerId)
+			if err != nil {
+				return nil, err
+			}
+			share, shares[brokerId] = s, s
+		}
+		amount := fee.Mul(share).RoundFloor(8)
+		if amount.Sign() <= 0 {
+			continue
+		}
+		revenues = append(revenues, &BrokerRevenue{
+			BrokerId:    brokerId,
+			RevenueId:   getSettlementId(trade.TradeId, trade.Liquidity),
+			AssetId:     trade.FeeAssetId,
+			Amount:      amount.Persist(),
+			CreatedAt:   trade.CreatedAt,
+			CollectorId: transfers[i].BrokerId,
+		})
+	}
+	return revenues, nil
+}
+
+func (s *SpannerStore) PayBrokerRevenues(ctx context.Context, brokerId string, limit int) (int, error) {
+	var count int
+	_, err := s.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		it := txn.ReadWithOptions(ctx, "broker_revenues", spanner.Key{brokerId}.AsPrefix(), []string{"broker_id", "revenue_id", "asset_id", "amount", "created_at", "collector_id"}, &spanner.ReadOptions{Limit: limit})
+		defer it.Stop()
+
+		var revenues []*BrokerRevenue
+		var keys []spanner.KeySet
+		for {
+			row, err := it.Next()
+			if err == iterator.Done {
+				break
+			} else if err != nil {
+				return err
+			}
+			var r BrokerRevenue
+			err = row.ToStruct(&r)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, spanner.Key{r.BrokerId, r.RevenueId})
+			revenues = append(revenues, &r)
+		}
+		count = len(keys)
+		if count == 0 {
+			return nil
+		}
+
+		mutations := []*spanner.Mutation{spanner.Delete("broker_revenues", spanner.KeySets(keys...))}
+		payouts, transfers := makeBrokerPayouts(brokerId, revenues)
+		for _, p := range payouts {
+			payoutMutation, err := spanner.InsertStruct("broker_payouts", p)
+			if err != nil {
+				return err
+			}
+			feeMutation, err := spanner.InsertStruct("fees", makePayoutFee(p))
+			if err != nil {
+				return err
+			}
+			mutations = append(mutations, payoutMutation, feeMutation)
+		}
+		for _, t := range transfers {
+			mutation, err := spanner.InsertStruct("transfers", t)
+			if err != nil {
+				return err
+			}
+			mutations = append(mutations, mutation)
+		}
+		return txn.BufferWrite(mutations)
+	})
+	return count, err
+}
+
+// makeBrokerPayouts sums the revenues by asset and collector, each payout is
+// paid by a transfer from the broker which collected the fees. The revenues
+// collected by the broker itself are already in its wallet, and those accrued
+// before the collector was recorded are paid by the exchange.
+func makeBrokerPayouts(brokerId string, revenues []*BrokerRevenue) ([]*BrokerPayout, []*Transfer) {
+	var payouts []*BrokerPayout
+	var collectors []string
+	groups := make(map[string]*BrokerPayout)
+	for _, r := range revenues {
+		collectorId := r.CollectorId
+		if collectorId == "" {
+			collectorId = config.ClientId
+		}
+		key := r.AssetId + collectorId
+		p := groups[key]
+		if p == nil {
+			id, _ := uuid.NewV4()
+			p = &BrokerPayout{
+				BrokerId:  brokerId,
+				PayoutId:  id.String(),
+				AssetId:   r.AssetId,
+				Amount:    number.Zero().Persist(),
+				CreatedAt: time.Now(),
+			}
+			groups[key] = p
+			payouts = append(payouts, p)
+			collectors = append(collectors, collectorId)
+		}
+		p.Amount = number.FromString(p.Amount).Add(number.FromString(r.Amount)).Persist()
+	}
+
+	var transfers []*Transfer
+	for i, p := range payouts {
+		if collectors[i] == brokerId {
+			continue
+		}
+		transfers = append(transfers, &Transfer{
+			TransferId: getSettlementId(p.PayoutId, TransferSourceBrokerRevenue),
+			Source:     TransferSourceBrokerRevenue,
+			Detail:     p.PayoutId,
+			AssetId:    p.AssetId,
+			Amount:     p.Amount,
+			Fee:        number.Zero().Persist(),
+			CreatedAt:  p.CreatedAt,
+			UserId:     p.BrokerId,
+			BrokerId:   collectors[i],
+		})
+	}
+	return payouts, transfers
+}
+
+func (s *SpannerStore) ReadBrokerRevenues(ctx context.Context, brokerId string) (map[string]number.Decimal, map[string]number.Decimal, error) {
+	txn := s.client.ReadOnlyTransaction()
+	defer txn.Close()
+
+	accrued, err := sumBrokerRevenues(ctx, txn, "broker_revenues", brokerId)
+	if err != nil {
+		return nil, nil, err
+	}
+	paid, err := sumBrokerRevenues(ctx, txn, "broker_payouts", brokerId)
+	return accrued, paid, err
+}
+
+func sumBrokerRevenues(ctx context.Context, txn *spanner.ReadOnlyTransaction, table, brokerId string) (map[string]number.Decimal, error) {
+	it := txn.Read(ctx, table, spanner.Key{brokerId}.AsPrefix(), []string{"asset_id", "amount"})
+	defer it.Stop()
+
+	sums := make(map[string]number.Decimal)
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			return sums, nil
+		} else if err != nil {
+			return nil, err
+		}
+		var assetId, amount string
+		err = row.Columns(&assetId, &amount)
+		if err != nil {
+			return nil, err
+		}
+		sums[assetId] = sums[assetId].Add(number.FromString(amount))
+	}
+}
+
+func readBrokerRevenueShare(ctx context.Context, txn *spanner.ReadWriteTransaction, brokerId string) (number.Decimal, error) {
+	it := txn.Read(ctx, "brokers", spanner.Key{brokerId}, []string{"revenue_share"})
+	defer it.Stop()
+
+	row, err := it.Next()
+	if err == iterator.Done {
+		return number.Zero(), nil
+	} else if err != nil {
+		return number.Zero(), err
+	}
+	var share string
+	err = row.Columns(&share)
+	return brokerRevenueShare(share), err
+}
+
+func brokerRevenueShare(share string) number.Decimal {
+	if one := number.FromString("1"); number.FromString(share).Cmp(one) > 0 {
+		return one
+	}
+	return number.FromString(share)
+}
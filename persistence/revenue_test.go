@@ -0,0 +1,121 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/ocean.one/config"
+	"github.com/MixinNetwork/ocean.one/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccrueBrokerRevenues(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	r := &testSettlementReader{shares: map[string]string{"taker-broker": "0.5", "maker-broker": "0.5"}}
+
+	trades := []*Trade{
+		{TradeId: "t1", Liquidity: TradeLiquidityTaker, FeeAssetId: "quote", FeeAmount: "0.02"},
+		{TradeId: "t1", Liquidity: TradeLiquidityMaker, FeeAssetId: "quote", FeeAmount: "-0.004"},
+	}
+	transfers := []*Transfer{{BrokerId: "maker-broker"}, {BrokerId: "taker-broker"}}
+	revenues, err := accrueBrokerRevenues(ctx, r, trades, transfers, []string{"taker-broker", "maker-broker"})
+	assert.Nil(err)
+	assert.Len(revenues, 1)
+	assert.Equal("taker-broker", revenues[0].BrokerId)
+	assert.Equal("maker-broker", revenues[0].CollectorId)
+	assert.Equal("quote", revenues[0].AssetId)
+	assert.Equal("0.008", revenues[0].Amount)
+
+	// the share is capped at 1, and nothing is accrued without a share
+	r.shares["taker-broker"] = "1.5"
+	revenues, err = accrueBrokerRevenues(ctx, r, trades, transfers, []string{"taker-broker", "maker-broker"})
+	assert.Nil(err)
+	assert.Len(revenues, 1)
+	assert.Equal("0.016", revenues[0].Amount)
+	r.shares["taker-broker"] = "0"
+	revenues, err = accrueBrokerRevenues(ctx, r, trades, transfers, []string{"taker-broker", "maker-broker"})
+	assert.Nil(err)
+	assert.Len(revenues, 0)
+}
+
+func TestMakeBrokerPayouts(t *testing.T) {
+	assert := assert.New(t)
+	payouts, transfers := makeBrokerPayouts("broker", []*BrokerRevenue{
+		{BrokerId: "broker", RevenueId: "r1", AssetId: "quote", Amount: "0.008", CollectorId: "collector"},
+		{BrokerId: "broker", RevenueId: "r2", AssetId: "quote", Amount: "0.002", CollectorId: "collector"},
+		{BrokerId: "broker", RevenueId: "r3", AssetId: "quote", Amount: "0.003"},
+		{BrokerId: "broker", RevenueId: "r4", AssetId: "base", Amount: "0.001", CollectorId: "broker"},
+	})
+	assert.Len(payouts, 3)
+	assert.Equal("0.01", payouts[0].Amount)
+	assert.Equal("0.003", payouts[1].Amount)
+	assert.Equal("0.001", payouts[2].Amount)
+
+	// the fees collected by the broker itself are not transferred
+	assert.Len(transfers, 2)
+	assert.Equal(TransferSourceBrokerRevenue, transfers[0].Source)
+	assert.Equal("broker", transfers[0].UserId)
+	assert.Equal("collector", transfers[0].BrokerId)
+	assert.Equal("0.01", transfers[0].Amount)
+	assert.Equal(config.ClientId, transfers[1].BrokerId)
+	assert.Equal("0.003", transfers[1].Amount)
+
+	fee := makePayoutFee(payouts[0])
+	assert.Equal(FeeSourcePayout, fee.Source)
+	assert.Equal("quote", fee.AssetId)
+	assert.Equal("-0.01", fee.Amount)
+	assert.Equal(payouts[0].PayoutId, fee.FeeId)
+}
+
+func TestPayBrokerRevenues(t *testing.T) {
+	assert := assert.New(t)
+	ctx := testSQLiteContext(t)
+
+	for id, share := range map[string]string{"taker-broker": "1.5", "maker-broker": "0"} {
+		err := Backend(ctx).CreateBroker(ctx, &Broker{BrokerId: id, EncryptionHeader: []byte{}, RevenueShare: share, CreatedAt: time.Now()})
+		assert.Nil(err)
+	}
+	maker := testPlaceOrder(ctx, t, "maker", engine.PageSideAsk, "base", "quote")
+	taker := testPlaceOrder(ctx, t, "taker", engine.PageSideBid, "base", "quote")
+	maker.BrokerId, taker.BrokerId = "maker-broker", "taker-broker"
+	testMatch(ctx, t, taker, maker)
+	maker = testPlaceOrder(ctx, t, "maker", engine.PageSideBid, "base", "quote")
+	taker = testPlaceOrder(ctx, t, "taker", engine.PageSideAsk, "base", "quote")
+	maker.BrokerId, taker.BrokerId = "taker-broker", "maker-broker"
+	testMatch(ctx, t, taker, maker)
+
+	// the taker fee of 0.001 base, less the rebate, is all shared at most
+	accrued, _, err := ReadBrokerRevenues(ctx, "taker-broker")
+	assert.Nil(err)
+	assert.Len(accrued, 1)
+	assert.Equal("0.0008", accrued["base"].Persist())
+	accrued, _, err = ReadBrokerRevenues(ctx, "maker-broker")
+	assert.Nil(err)
+	assert.Len(accrued, 0)
+
+	count, err := PayBrokerRevenues(ctx, "maker-broker", 10)
+	assert.Nil(err)
+	assert.Equal(0, count)
+	count, err = PayBrokerRevenues(ctx, "taker-broker", 10)
+	assert.Nil(err)
+	assert.Equal(1, count)
+	_, paid, err := ReadBrokerRevenues(ctx, "taker-broker")
+	assert.Nil(err)
+	assert.Equal("0.0008", paid["base"].Persist())
+
+	// the base fee is deducted from the transfer paid by the maker broker
+	transfers, err := ListPendingTransfers(ctx, "maker-broker", 100)
+	assert.Nil(err)
+	var payouts []*Transfer
+	for _, t := range transfers {
+		if t.Source == TransferSourceBrokerRevenue {
+			payouts = append(payouts, t)
+		}
+	}
+	assert.Len(payouts, 1)
+	assert.Equal("taker-broker", payouts[0].UserId)
+	assert.Equal("base", payouts[0].AssetId)
+	assert.Equal("0.0008", payouts[0].Amount)
+}
@@ -0,0 +1,60 @@
+package persistence
+
+import (
+	"context"
+
+	"cloud.google.com/go/spanner"
+	"github.com/MixinNetwork/go-number"
+	"google.golang.org/api/iterator"
+)
+
+// SpannerStore is the Cloud Spanner store, the schema is the migrations
+// directory.
+type SpannerStore struct {
+	client *spanner.Client
+}
+
+func NewSpannerStore(client *spanner.Client) *SpannerStore {
+	return &SpannerStore{client: client}
+}
+
+type spannerReader struct {
+	txn *spanner.ReadWriteTransaction
+}
+
+func (r *spannerReader) readFeeBalance(ctx context.Context, userId string) (*FeeBalance, error) {
+	return readFeeBalance(ctx, r.txn, userId)
+}
+
+func (r *spannerReader) readBrokerRevenueShare(ctx context.Context, brokerId string) (number.Decimal, error) {
+	return readBrokerRevenueShare(ctx, r.txn, brokerId)
+}
+
+func (r *spannerReader) readCandles(ctx context.Context, candles []*Candle) ([]*Candle, error) {
+	var keys []spanner.KeySet
+	for _, c := range candles {
+		keys = append(keys, spanner.Key{c.Base, c.Quote, c.Granularity, c.Point})
+	}
+	it := r.txn.Read(ctx, "candles", spanner.KeySets(keys...), candleColumns)
+	defer it.Stop()
+
+	var existing []*Candle
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			return existing, nil
+		} else if err != nil {
+			return nil, err
+		}
+		var c Candle
+		err = row.ToStruct(&c)
+		if err != nil {
+			return nil, err
+		}
+		existing = append(existing, &c)
+	}
+}
+
+func (r *spannerReader) lastTrade(ctx context.Context, base, quote string) (*Trade, error) {
+	return lastTrade(ctx, r.txn, base, quote)
+}
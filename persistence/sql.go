@@ -0,0 +1,221 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	sqlOrderColumns      = "order_id,order_type,quote_asset_id,base_asset_id,side,price,remaining_amount,filled_amount,remaining_funds,filled_funds,created_at,state,user_id,broker_id"
+	sqlActionColumns     = "order_id,action,created_at"
+	sqlTradeColumns      = "trade_id,liquidity,ask_order_id,bid_order_id,quote_asset_id,base_asset_id,side,price,amount,created_at,user_id,fee_asset_id,fee_amount"
+	sqlTransferColumns   = "transfer_id,source,detail,asset_id,amount,fee,reason,created_at,user_id,broker_id"
+	sqlBrokerColumns     = "broker_id,session_id,session_key,pin_token,encrypted_pin,encryption_header,revenue_share,created_at"
+	sqlRejectionColumns  = "trace_id,user_id,asset_id,amount,reason,created_at"
+	sqlFeeBalanceColumns = "user_id,asset_id,balance,enabled,updated_at"
+	sqlFeeEntryColumns   = "user_id,entry_id,source,amount,created_at"
+	sqlFeeColumns        = "day,market,asset_id,fee_id,source,amount,created_at"
+	sqlRevenueColumns    = "broker_id,revenue_id,asset_id,amount,created_at,collector_id"
+	sqlPayoutColumns     = "broker_id,payout_id,asset_id,amount,created_at"
+	sqlCandleColumns     = "base,quote,granularity,point,open,close,high,low,volume,total"
+	sqlBookEventColumns  = "market,sequence,type,data,created_at"
+)
+
+// SQLStore is the store of a SQL database through database/sql, all the
+// transactions are serializable and retried on the serialization failures,
+// like the read write transactions of Spanner.
+type SQLStore struct {
+	db        *sql.DB
+	isolation sql.IsolationLevel
+	retryable func(error) bool
+}
+
+type sqlQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// sqlQuery builds the conditions of a query, with the numbered placeholders
+// of the arguments.
+type sqlQuery struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (q *sqlQuery) arg(v interface{}) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// add appends the condition, each %s of it is the placeholder of a value.
+func (q *sqlQuery) add(condition string, values ...interface{}) {
+	placeholders := make([]interface{}, len(values))
+	for i, v := range values {
+		placeholders[i] = q.arg(v)
+	}
+	q.conditions = append(q.conditions, fmt.Sprintf(condition, placeholders...))
+}
+
+func (q *sqlQuery) where() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.conditions, " AND ")
+}
+
+func (s *SQLStore) transact(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	for {
+		err := s.transactOnce(ctx, fn)
+		if err == nil || s.retryable == nil || !s.retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (s *SQLStore) transactOnce(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: s.isolation})
+	if err != nil {
+		return err
+	}
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func sqlInsert(ctx context.Context, q sqlQueryer, table, columns string, values ...interface{}) error {
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, columns, sqlPlaceholders(1, len(values)))
+	_, err := q.ExecContext(ctx, query, values...)
+	return err
+}
+
+// sqlUpsert inserts the row or updates all the columns out of the keys.
+func sqlUpsert(ctx context.Context, q sqlQueryer, table, columns, keys string, values ...interface{}) error {
+	var updates []string
+	for _, c := range strings.Split(columns, ",") {
+		if !strings.Contains(","+keys+",", ","+c+",") {
+			updates = append(updates, c+"=EXCLUDED."+c)
+		}
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s", table, columns, sqlPlaceholders(1, len(values)), keys, strings.Join(updates, ","))
+	_, err := q.ExecContext(ctx, query, values...)
+	return err
+}
+
+func sqlPlaceholders(start, count int) string {
+	placeholders := make([]string, count)
+	for i := range placeholders {
+		placeholders[i] = fmt.Sprintf("$%d", start+i)
+	}
+	return strings.Join(placeholders, ",")
+}
+
+func sqlPrefixColumns(prefix, columns string) string {
+	return prefix + strings.ReplaceAll(columns, ",", ","+prefix)
+}
+
+func sqlCount(ctx context.Context, q sqlQueryer, query string, args ...interface{}) (int64, error) {
+	var count int64
+	err := q.QueryRowContext(ctx, query, args...).Scan(&count)
+	return count, err
+}
+
+func sqlExists(ctx context.Context, q sqlQueryer, query string, args ...interface{}) (bool, error) {
+	var one int
+	err := q.QueryRowContext(ctx, query, args...).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// sqlCondition is the condition of the cursor, like condition of Spanner.
+func (c *Cursor) sqlCondition(q *sqlQuery, idColumn, tiebreakColumn, order string) {
+	if c == nil {
+		return
+	}
+	cmp := ">"
+	if order == "DESC" {
+		cmp = "<"
+	}
+	if c.Id == "" {
+		q.add("created_at"+cmp+"%s", c.CreatedAt)
+		return
+	}
+	id, args := idColumn+cmp+"%s", []interface{}{c.CreatedAt, c.CreatedAt, c.Id}
+	if tiebreakColumn != "" && c.Tiebreak != "" {
+		id = "(" + id + " OR (" + idColumn + "=%s AND " + tiebreakColumn + cmp + "%s))"
+		args = append(args, c.Id, c.Tiebreak)
+	}
+	q.add("(created_at"+cmp+"%s OR (created_at=%s AND "+id+"))", args...)
+}
+
+func (s *SQLStore) UpdateUserPublicKey(ctx context.Context, userId, publicKey string) error {
+	return s.transact(ctx, func(tx *sql.Tx) error {
+		exist, err := sqlExists(ctx, tx, "SELECT 1 FROM users WHERE public_key=$1", publicKey)
+		if err != nil || exist {
+			return err
+		}
+		return sqlUpsert(ctx, tx, "users", "user_id,public_key", "user_id", userId, publicKey)
+	})
+}
+
+func (s *SQLStore) ReadUserPublicKey(ctx context.Context, userId string) (string, error) {
+	var publicKey string
+	err := s.db.QueryRowContext(ctx, "SELECT public_key FROM users WHERE user_id=$1", userId).Scan(&publicKey)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return publicKey, err
+}
+
+func (s *SQLStore) ReadBrokers(ctx context.Context) ([]*Broker, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sqlBrokerColumns+" FROM brokers")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var brokers []*Broker
+	for rows.Next() {
+		var b Broker
+		err = rows.Scan(&b.BrokerId, &b.SessionId, &b.SessionKey, &b.PINToken, &b.EncryptedPIN, &b.EncryptionHeader, &b.RevenueShare, &b.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		brokers = append(brokers, &b)
+	}
+	return brokers, rows.Err()
+}
+
+func (s *SQLStore) CreateBroker(ctx context.Context, b *Broker) error {
+	return sqlInsert(ctx, s.db, "brokers", sqlBrokerColumns, b.BrokerId, b.SessionId, b.SessionKey, b.PINToken, b.EncryptedPIN, b.EncryptionHeader, b.RevenueShare, b.CreatedAt)
+}
+
+func (s *SQLStore) ReadProperty(ctx context.Context, key string) (string, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, "SELECT value FROM properties WHERE key=$1", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+func (s *SQLStore) WriteProperty(ctx context.Context, key, value string) error {
+	return sqlUpsert(ctx, s.db, "properties", "key,value,updated_at", "key", key, value, time.Now())
+}
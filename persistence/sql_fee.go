@@ -0,0 +1,306 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/MixinNetwork/go-number"
+)
+
+type sqlReader struct {
+	tx *sql.Tx
+}
+
+func (r *sqlReader) readFeeBalance(ctx context.Context, userId string) (*FeeBalance, error) {
+	return sqlReadFeeBalance(ctx, r.tx, userId)
+}
+
+func (r *sqlReader) readBrokerRevenueShare(ctx context.Context, brokerId string) (number.Decimal, error) {
+	var share string
+	err := r.tx.QueryRowContext(ctx, "SELECT revenue_share FROM brokers WHERE broker_id=$1", brokerId).Scan(&share)
+	if err == sql.ErrNoRows {
+		return number.Zero(), nil
+	}
+	return brokerRevenueShare(share), err
+}
+
+func (r *sqlReader) readCandles(ctx context.Context, candles []*Candle) ([]*Candle, error) {
+	var existing []*Candle
+	for _, c := range candles {
+		row := r.tx.QueryRowContext(ctx, "SELECT "+sqlCandleColumns+" FROM candles WHERE base=$1 AND quote=$2 AND granularity=$3 AND point=$4", c.Base, c.Quote, c.Granularity, c.Point)
+		e, err := scanCandle(row)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		existing = append(existing, e)
+	}
+	return existing, nil
+}
+
+func (r *sqlReader) lastTrade(ctx context.Context, base, quote string) (*Trade, error) {
+	return sqlLastTrade(ctx, r.tx, base, quote)
+}
+
+func (s *SQLStore) ReadFeeBalance(ctx context.Context, userId string) (*FeeBalance, error) {
+	var b FeeBalance
+	err := s.db.QueryRowContext(ctx, "SELECT "+sqlFeeBalanceColumns+" FROM fee_balances WHERE user_id=$1", userId).Scan(&b.UserId, &b.AssetId, &b.Balance, &b.Enabled, &b.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &b, err
+}
+
+func (s *SQLStore) UpdateFeeBalance(ctx context.Context, update *FeeBalanceUpdate) error {
+	return s.transact(ctx, func(tx *sql.Tx) error {
+		exist, err := sqlExists(ctx, tx, "SELECT 1 FROM fee_balance_entries WHERE user_id=$1 AND entry_id=$2", update.UserId, update.Trace)
+		if err != nil || exist {
+			return err
+		}
+		balance, err := sqlReadFeeBalance(ctx, tx, update.UserId)
+		if err != nil {
+			return err
+		}
+		entries, transfer := applyFeeBalanceUpdate(balance, update)
+		if transfer != nil {
+			err = sqlInsertTransfer(ctx, tx, transfer)
+			if err != nil {
+				return err
+			}
+		}
+		for _, e := range entries {
+			err = sqlInsert(ctx, tx, "fee_balance_entries", sqlFeeEntryColumns, e.UserId, e.EntryId, e.Source, e.Amount, e.CreatedAt)
+			if err != nil {
+				return err
+			}
+		}
+		return sqlUpsertFeeBalance(ctx, tx, balance)
+	})
+}
+
+func (s *SQLStore) ReadFees(ctx context.Context, start, end string) ([]*Fee, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT day,market,asset_id,source,amount FROM fees WHERE day>=$1 AND day<=$2", start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fees []*Fee
+	for rows.Next() {
+		var f Fee
+		err = rows.Scan(&f.Day, &f.Market, &f.AssetId, &f.Source, &f.Amount)
+		if err != nil {
+			return nil, err
+		}
+		fees = append(fees, &f)
+	}
+	return fees, rows.Err()
+}
+
+func (s *SQLStore) PayBrokerRevenues(ctx context.Context, brokerId string, limit int) (int, error) {
+	var count int
+	err := s.transact(ctx, func(tx *sql.Tx) error {
+		query := fmt.Sprintf("SELECT %s FROM broker_revenues WHERE broker_id=$1 ORDER BY revenue_id LIMIT %d", sqlRevenueColumns, limit)
+		rows, err := tx.QueryContext(ctx, query, brokerId)
+		if err != nil {
+			return err
+		}
+		var revenues []*BrokerRevenue
+		var ids []interface{}
+		for rows.Next() {
+			var r BrokerRevenue
+			err = rows.Scan(&r.BrokerId, &r.RevenueId, &r.AssetId, &r.Amount, &r.CreatedAt, &r.CollectorId)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			revenues = append(revenues, &r)
+			ids = append(ids, r.RevenueId)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		count = len(revenues)
+		if count == 0 {
+			return nil
+		}
+
+		query = fmt.Sprintf("DELETE FROM broker_revenues WHERE broker_id=$1 AND revenue_id IN (%s)", sqlPlaceholders(2, len(ids)))
+		_, err = tx.ExecContext(ctx, query, append([]interface{}{brokerId}, ids...)...)
+		if err != nil {
+			return err
+		}
+		payouts, transfers := makeBrokerPayouts(brokerId, revenues)
+		for _, p := range payouts {
+			err = sqlInsert(ctx, tx, "broker_payouts", sqlPayoutColumns, p.BrokerId, p.PayoutId, p.AssetId, p.Amount, p.CreatedAt)
+			if err != nil {
+				return err
+			}
+			err = sqlInsertFee(ctx, tx, makePayoutFee(p))
+			if err != nil {
+				return err
+			}
+		}
+		for _, t := range transfers {
+			err = sqlInsertTransfer(ctx, tx, t)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (s *SQLStore) ReadBrokerRevenues(ctx context.Context, brokerId string) (map[string]number.Decimal, map[string]number.Decimal, error) {
+	accrued, err := s.sumBrokerRevenues(ctx, "broker_revenues", brokerId)
+	if err != nil {
+		return nil, nil, err
+	}
+	paid, err := s.sumBrokerRevenues(ctx, "broker_payouts", brokerId)
+	return accrued, paid, err
+}
+
+func (s *SQLStore) sumBrokerRevenues(ctx context.Context, table, brokerId string) (map[string]number.Decimal, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT asset_id,amount FROM "+table+" WHERE broker_id=$1", brokerId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sums := make(map[string]number.Decimal)
+	for rows.Next() {
+		var assetId, amount string
+		err = rows.Scan(&assetId, &amount)
+		if err != nil {
+			return nil, err
+		}
+		sums[assetId] = sums[assetId].Add(number.FromString(amount))
+	}
+	return sums, rows.Err()
+}
+
+func (s *SQLStore) ReadCandles(ctx context.Context, base, quote string, granularity, limit int64) ([]*Candle, error) {
+	query := fmt.Sprintf("SELECT %s FROM candles WHERE base=$1 AND quote=$2 AND granularity=$3 ORDER BY point DESC LIMIT %d", sqlCandleColumns, limit)
+	rows, err := s.db.QueryContext(ctx, query, base, quote, granularity)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candles []*Candle
+	for rows.Next() {
+		c, err := scanCandle(rows)
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, c)
+	}
+	return candles, rows.Err()
+}
+
+func (s *SQLStore) BackfillCandles(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT DISTINCT base_asset_id,quote_asset_id FROM trades")
+	if err != nil {
+		return err
+	}
+	var markets [][2]string
+	for rows.Next() {
+		var base, quote string
+		err = rows.Scan(&base, &quote)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		markets = append(markets, [2]string{base, quote})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range markets {
+		err := s.backfillMarketCandles(ctx, m[0], m[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillMarketCandles merges all the trades before writing the candles, so
+// the rows are never read and written at the same time.
+func (s *SQLStore) backfillMarketCandles(ctx context.Context, base, quote string) error {
+	query := "SELECT " + sqlTradeColumns + " FROM trades WHERE base_asset_id=$1 AND quote_asset_id=$2 AND liquidity=$3 ORDER BY created_at,trade_id"
+	rows, err := s.db.QueryContext(ctx, query, base, quote, TradeLiquidityMaker)
+	if err != nil {
+		return err
+	}
+	var candles []*Candle
+	backfill := newCandleBackfill(func(c *Candle) error {
+		candles = append(candles, c)
+		return nil
+	})
+	for rows.Next() {
+		t, err := scanTrade(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		backfill.add(t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	backfill.flush()
+
+	for len(candles) > 0 {
+		batch := candles
+		if len(batch) > 1000 {
+			batch = batch[:1000]
+		}
+		candles = candles[len(batch):]
+		err = s.transact(ctx, func(tx *sql.Tx) error {
+			for _, c := range batch {
+				err := sqlUpsertCandle(ctx, tx, c)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	log.Println("BackfillCandles", base, quote, backfill.count)
+	return nil
+}
+
+func sqlReadFeeBalance(ctx context.Context, q sqlQueryer, userId string) (*FeeBalance, error) {
+	var b FeeBalance
+	err := q.QueryRowContext(ctx, "SELECT "+sqlFeeBalanceColumns+" FROM fee_balances WHERE user_id=$1", userId).Scan(&b.UserId, &b.AssetId, &b.Balance, &b.Enabled, &b.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return newFeeBalance(userId), nil
+	}
+	return &b, err
+}
+
+func sqlUpsertFeeBalance(ctx context.Context, q sqlQueryer, b *FeeBalance) error {
+	return sqlUpsert(ctx, q, "fee_balances", sqlFeeBalanceColumns, "user_id", b.UserId, b.AssetId, b.Balance, b.Enabled, b.UpdatedAt)
+}
+
+func sqlUpsertCandle(ctx context.Context, q sqlQueryer, c *Candle) error {
+	return sqlUpsert(ctx, q, "candles", sqlCandleColumns, "base,quote,granularity,point", c.Base, c.Quote, c.Granularity, c.Point, c.Open, c.Close, c.High, c.Low, c.Volume, c.Total)
+}
+
+func scanCandle(row sqlScanner) (*Candle, error) {
+	var c Candle
+	err := row.Scan(&c.Base, &c.Quote, &c.Granularity, &c.Point, &c.Open, &c.Close, &c.High, &c.Low, &c.Volume, &c.Total)
+	return &c, err
+}
@@ -0,0 +1,98 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/MixinNetwork/ocean.one/cache"
+)
+
+// SQLJournal is the event journal in the book_events table of a SQL store.
+type SQLJournal struct {
+	store *SQLStore
+}
+
+func NewSQLJournal(store *SQLStore) *SQLJournal {
+	return &SQLJournal{store: store}
+}
+
+func (j *SQLJournal) Append(ctx context.Context, events ...*cache.Event) error {
+	return j.store.transact(ctx, func(tx *sql.Tx) error {
+		for _, e := range events {
+			be, err := newBookEvent(e)
+			if err != nil {
+				return err
+			}
+			err = sqlUpsert(ctx, tx, "book_events", sqlBookEventColumns, "market,sequence,type", be.Market, be.Sequence, be.Type, be.Data, be.CreatedAt)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (j *SQLJournal) Range(ctx context.Context, market string, from, to int64, limit int) ([]*cache.Event, error) {
+	query := "SELECT " + sqlBookEventColumns + " FROM book_events WHERE market=$1 AND sequence>=$2 AND sequence<=$3 ORDER BY sequence,type DESC"
+	if limit > 0 {
+		query = fmt.Sprintf("%s LIMIT %d", query, limit)
+	}
+	return j.query(ctx, query, market, from, to)
+}
+
+func (j *SQLJournal) Snapshot(ctx context.Context, market string, sequence int64) (*cache.Event, error) {
+	query := "SELECT " + sqlBookEventColumns + " FROM book_events WHERE market=$1 AND type=$2 AND sequence<=$3 ORDER BY sequence DESC LIMIT 1"
+	events, err := j.query(ctx, query, market, "BOOK-T0", sequence)
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+	return events[0], nil
+}
+
+func (j *SQLJournal) Last(ctx context.Context, market string) (int64, error) {
+	var sequence int64
+	query := "SELECT sequence FROM book_events WHERE market=$1 ORDER BY sequence DESC LIMIT 1"
+	err := j.store.db.QueryRowContext(ctx, query, market).Scan(&sequence)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return sequence, err
+}
+
+func (j *SQLJournal) Compact(ctx context.Context, market string, before time.Time) error {
+	var sequence int64
+	query := "SELECT sequence FROM book_events WHERE market=$1 AND type=$2 AND created_at<=$3 ORDER BY sequence DESC LIMIT 1"
+	err := j.store.db.QueryRowContext(ctx, query, market, "BOOK-T0", before).Scan(&sequence)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err = j.store.db.ExecContext(ctx, "DELETE FROM book_events WHERE market=$1 AND sequence<$2", market, sequence)
+	return err
+}
+
+func (j *SQLJournal) query(ctx context.Context, query string, args ...interface{}) ([]*cache.Event, error) {
+	rows, err := j.store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*cache.Event
+	for rows.Next() {
+		var be BookEvent
+		err = rows.Scan(&be.Market, &be.Sequence, &be.Type, &be.Data, &be.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		e, err := be.event()
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,99 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgresRetryable(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(postgresRetryable(&pq.Error{Code: "40001"}))
+	assert.True(postgresRetryable(&pq.Error{Code: "40P01"}))
+	assert.False(postgresRetryable(&pq.Error{Code: "23505"}))
+	assert.False(postgresRetryable(errors.New("40001")))
+	assert.False(postgresRetryable(nil))
+
+	// the serialization failure is retried in a new transaction
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "ocean.db"))
+	assert.Nil(err)
+	store.retryable = postgresRetryable
+	var attempts int
+	err = store.transact(context.Background(), func(tx *sql.Tx) error {
+		attempts++
+		if attempts < 3 {
+			return &pq.Error{Code: "40001"}
+		}
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal(3, attempts)
+	err = store.transact(context.Background(), func(tx *sql.Tx) error {
+		attempts++
+		return &pq.Error{Code: "23505"}
+	})
+	assert.Equal(&pq.Error{Code: "23505"}, err)
+	assert.Equal(4, attempts)
+}
+
+func TestSQLUpsert(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	q := &testSQLQueryer{}
+	err := sqlUpsert(ctx, q, "fee_balances", sqlFeeBalanceColumns, "user_id", "user", "asset", "1", true, time.Now())
+	assert.Nil(err)
+	assert.Equal("INSERT INTO fee_balances (user_id,asset_id,balance,enabled,updated_at) VALUES ($1,$2,$3,$4,$5) ON CONFLICT (user_id) DO UPDATE SET asset_id=EXCLUDED.asset_id,balance=EXCLUDED.balance,enabled=EXCLUDED.enabled,updated_at=EXCLUDED.updated_at", q.query)
+	assert.Len(q.args, 5)
+
+	// the conflicting row is updated out of the keys
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "ocean.db"))
+	assert.Nil(err)
+	err = sqlUpsert(ctx, store.db, "properties", "key,value,updated_at", "key", "key", "first", time.Now())
+	assert.Nil(err)
+	err = sqlUpsert(ctx, store.db, "properties", "key,value,updated_at", "key", "key", "second", time.Now())
+	assert.Nil(err)
+	value, err := store.ReadProperty(ctx, "key")
+	assert.Nil(err)
+	assert.Equal("second", value)
+	count, err := sqlCount(ctx, store.db, "SELECT COUNT(*) FROM properties WHERE key=$1", "key")
+	assert.Nil(err)
+	assert.Equal(int64(1), count)
+}
+
+func TestSQLReaderLastTrade(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "ocean.db"))
+	assert.Nil(err)
+
+	// the price is read in the settlement transaction, with its own writes
+	err = store.transact(ctx, func(tx *sql.Tx) error {
+		trade := &Trade{TradeId: "trade", Liquidity: TradeLiquidityMaker, BaseAssetId: "base", QuoteAssetId: FeeDiscountAssetId, Side: "ASK", Price: "0.5", Amount: "1", CreatedAt: time.Now(), UserId: "user", FeeAssetId: "base", FeeAmount: "0"}
+		err := sqlInsertTrade(ctx, tx, trade)
+		if err != nil {
+			return err
+		}
+		price, err := feeDiscountPrice(ctx, &sqlReader{tx: tx}, "base")
+		assert.Equal("0.5", price.Persist())
+		return err
+	})
+	assert.Nil(err)
+}
+
+type testSQLQueryer struct {
+	sqlQueryer
+	query string
+	args  []interface{}
+}
+
+func (q *testSQLQueryer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	q.query, q.args = query, args
+	return nil, nil
+}
@@ -0,0 +1,391 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/engine"
+)
+
+func (s *SQLStore) CountPendingActions(ctx context.Context) (int64, error) {
+	return sqlCount(ctx, s.db, "SELECT COUNT(*) FROM actions")
+}
+
+func (s *SQLStore) ListPendingActions(ctx context.Context, checkpoint time.Time, limit int) ([]*Action, error) {
+	query := "SELECT %s,%s FROM actions a JOIN orders o ON o.order_id=a.order_id WHERE a.created_at>=$1 ORDER BY a.created_at LIMIT %d"
+	query = fmt.Sprintf(query, sqlPrefixColumns("a.", sqlActionColumns), sqlPrefixColumns("o.", sqlOrderColumns), limit)
+	rows, err := s.db.QueryContext(ctx, query, checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := make([]*Action, 0)
+	for rows.Next() {
+		var a Action
+		var o Order
+		err = rows.Scan(&a.OrderId, &a.Action, &a.CreatedAt, &o.OrderId, &o.OrderType, &o.QuoteAssetId, &o.BaseAssetId, &o.Side, &o.Price, &o.RemainingAmount, &o.FilledAmount, &o.RemainingFunds, &o.FilledFunds, &o.CreatedAt, &o.State, &o.UserId, &o.BrokerId)
+		if err != nil {
+			return actions, err
+		}
+		a.Order = &o
+		actions = append(actions, &a)
+	}
+	return actions, rows.Err()
+}
+
+func (s *SQLStore) CreateOrderAction(ctx context.Context, o *Order) error {
+	action := o.createAction()
+	return s.transact(ctx, func(tx *sql.Tx) error {
+		query := fmt.Sprintf("INSERT INTO orders (%s) VALUES (%s) ON CONFLICT (order_id) DO NOTHING", sqlOrderColumns, sqlPlaceholders(1, 14))
+		res, err := tx.ExecContext(ctx, query, o.OrderId, o.OrderType, o.QuoteAssetId, o.BaseAssetId, o.Side, o.Price, o.RemainingAmount, o.FilledAmount, o.RemainingFunds, o.FilledFunds, o.CreatedAt, o.State, o.UserId, o.BrokerId)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		return sqlInsert(ctx, tx, "actions", sqlActionColumns, action.OrderId, action.Action, action.CreatedAt)
+	})
+}
+
+func (s *SQLStore) CancelOrderAction(ctx context.Context, orderId string, createdAt time.Time, userId string) error {
+	return s.transact(ctx, func(tx *sql.Tx) error {
+		exist, err := sqlExists(ctx, tx, "SELECT 1 FROM actions WHERE order_id=$1 AND action=$2", orderId, engine.OrderActionCancel)
+		if err != nil || exist {
+			return err
+		}
+		var state Order
+		err = tx.QueryRowContext(ctx, "SELECT order_type,state,user_id FROM orders WHERE order_id=$1", orderId).Scan(&state.OrderType, &state.State, &state.UserId)
+		if err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !state.cancellableBy(userId) {
+			return nil
+		}
+		return sqlInsert(ctx, tx, "actions", sqlActionColumns, orderId, engine.OrderActionCancel, createdAt)
+	})
+}
+
+func (s *SQLStore) UserOrder(ctx context.Context, orderId, userId string) (*Order, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+sqlOrderColumns+" FROM orders WHERE order_id=$1 AND user_id=$2", orderId, userId)
+	o, err := scanOrder(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return o, err
+}
+
+func (s *SQLStore) UserOrders(ctx context.Context, userId, base, quote, state string, cursor *Cursor, order string, limit int) ([]*Order, *Cursor, error) {
+	q := &sqlQuery{}
+	q.add("user_id=%s", userId)
+	q.add("state=%s", state)
+	if base != "" && quote != "" {
+		q.add("base_asset_id=%s AND quote_asset_id=%s", base, quote)
+	}
+	cursor.sqlCondition(q, "order_id", "", order)
+	query := fmt.Sprintf("SELECT %s FROM orders%s ORDER BY created_at %s,order_id %s LIMIT %d", sqlOrderColumns, q.where(), order, order, limit)
+	rows, err := s.db.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var orders []*Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		orders = append(orders, o)
+	}
+	if err := rows.Err(); err != nil || len(orders) < limit {
+		return orders, nil, err
+	}
+	last := orders[len(orders)-1]
+	return orders, &Cursor{CreatedAt: last.CreatedAt, Id: last.OrderId}, nil
+}
+
+func (s *SQLStore) Transact(ctx context.Context, taker, maker *engine.Order, amount number.Integer) ([]*Trade, []*Transfer, error) {
+	var st *settlement
+	err := s.transact(ctx, func(tx *sql.Tx) error {
+		var err error
+		st, err = settle(ctx, &sqlReader{tx: tx}, taker, maker, amount)
+		if err != nil {
+			return err
+		}
+		return st.write(ctx, tx)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return st.trades, st.transfers, nil
+}
+
+func (st *settlement) write(ctx context.Context, tx *sql.Tx) error {
+	for _, o := range []*engine.Order{st.taker, st.maker} {
+		err := sqlUpdateOrder(ctx, tx, o, orderFilled(o))
+		if err != nil {
+			return err
+		}
+	}
+	for _, t := range st.trades {
+		err := sqlInsertTrade(ctx, tx, t)
+		if err != nil {
+			return err
+		}
+	}
+	for _, t := range st.transfers {
+		err := sqlInsertTransfer(ctx, tx, t)
+		if err != nil {
+			return err
+		}
+	}
+	for _, e := range st.feeEntries {
+		err := sqlInsert(ctx, tx, "fee_balance_entries", sqlFeeEntryColumns, e.UserId, e.EntryId, e.Source, e.Amount, e.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	for _, b := range st.feeBalances {
+		err := sqlUpsertFeeBalance(ctx, tx, b)
+		if err != nil {
+			return err
+		}
+	}
+	for _, r := range st.revenues {
+		err := sqlInsert(ctx, tx, "broker_revenues", sqlRevenueColumns, r.BrokerId, r.RevenueId, r.AssetId, r.Amount, r.CreatedAt, r.CollectorId)
+		if err != nil {
+			return err
+		}
+	}
+	for _, f := range st.fees {
+		err := sqlInsertFee(ctx, tx, f)
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range st.candles {
+		err := sqlUpsertCandle(ctx, tx, c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) CancelOrder(ctx context.Context, order *engine.Order) (*Transfer, error) {
+	transfer := makeCancelTransfer(order)
+	err := s.transact(ctx, func(tx *sql.Tx) error {
+		err := sqlUpdateOrder(ctx, tx, order, true)
+		if err != nil {
+			return err
+		}
+		return sqlInsertTransfer(ctx, tx, transfer)
+	})
+	return transfer, err
+}
+
+// sqlUpdateOrder writes the filled and remaining of the order, a done order
+// also has its actions removed.
+func sqlUpdateOrder(ctx context.Context, tx *sql.Tx, o *engine.Order, done bool) error {
+	query := "UPDATE orders SET filled_amount=$2,remaining_amount=$3,filled_funds=$4,remaining_funds=$5 WHERE order_id=$1"
+	args := []interface{}{o.Id, o.FilledAmount.Persist(), o.RemainingAmount.Persist(), o.FilledFunds.Persist(), o.RemainingFunds.Persist()}
+	if done {
+		query = "UPDATE orders SET filled_amount=$2,remaining_amount=$3,filled_funds=$4,remaining_funds=$5,state=$6 WHERE order_id=$1"
+		args = append(args, OrderStateDone)
+	}
+	_, err := tx.ExecContext(ctx, query, args...)
+	if err != nil || !done {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM actions WHERE order_id=$1", o.Id)
+	return err
+}
+
+func (s *SQLStore) LastTrade(ctx context.Context, base, quote string) (*Trade, error) {
+	return sqlLastTrade(ctx, s.db, base, quote)
+}
+
+func sqlLastTrade(ctx context.Context, q sqlQueryer, base, quote string) (*Trade, error) {
+	row := q.QueryRowContext(ctx, "SELECT "+sqlTradeColumns+" FROM trades WHERE base_asset_id=$1 AND quote_asset_id=$2 ORDER BY created_at DESC,trade_id DESC LIMIT 1", base, quote)
+	t, err := scanTrade(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (s *SQLStore) MarketTrades(ctx context.Context, base, quote string, cursor *Cursor, order string, limit int) ([]*Trade, *Cursor, error) {
+	q := &sqlQuery{}
+	q.add("base_asset_id=%s AND quote_asset_id=%s AND liquidity=%s", base, quote, TradeLiquidityMaker)
+	cursor.sqlCondition(q, "trade_id", "", order)
+	query := fmt.Sprintf("SELECT %s FROM trades%s ORDER BY created_at %s,trade_id %s LIMIT %d", sqlTradeColumns, q.where(), order, order, limit)
+	return s.queryTrades(ctx, limit, query, q.args...)
+}
+
+func (s *SQLStore) UserOrderTrades(ctx context.Context, o *Order, cursor *Cursor, limit int) ([]*Trade, *Cursor, error) {
+	column := "ask_order_id"
+	if o.Side == engine.PageSideBid {
+		column = "bid_order_id"
+	}
+	q := &sqlQuery{}
+	q.add(column+"=%s AND side=%s", o.OrderId, o.Side)
+	cursor.sqlCondition(q, "trade_id", "", "ASC")
+	query := fmt.Sprintf("SELECT %s FROM trades%s ORDER BY created_at,trade_id LIMIT %d", sqlTradeColumns, q.where(), limit)
+	return s.queryTrades(ctx, limit, query, q.args...)
+}
+
+func (s *SQLStore) UserTrades(ctx context.Context, userId string, filter *TradeFilter, cursor *Cursor, order string, limit int) ([]*Trade, *Cursor, error) {
+	q := &sqlQuery{}
+	q.add("user_id=%s", userId)
+	if filter.Base != "" && filter.Quote != "" {
+		q.add("base_asset_id=%s AND quote_asset_id=%s", filter.Base, filter.Quote)
+	}
+	if filter.Side != "" {
+		q.add("side=%s", filter.Side)
+	}
+	if filter.Liquidity != "" {
+		q.add("liquidity=%s", filter.Liquidity)
+	}
+	if !filter.Start.IsZero() {
+		q.add("created_at>=%s", filter.Start)
+	}
+	if !filter.End.IsZero() {
+		q.add("created_at<%s", filter.End)
+	}
+	cursor.sqlCondition(q, "trade_id", "liquidity", order)
+	query := fmt.Sprintf("SELECT %s FROM trades%s ORDER BY created_at %s,trade_id %s,liquidity %s LIMIT %d", sqlTradeColumns, q.where(), order, order, order, limit)
+	trades, next, err := s.queryTrades(ctx, limit, query, q.args...)
+	if next != nil {
+		next.Tiebreak = trades[len(trades)-1].Liquidity
+	}
+	return trades, next, err
+}
+
+func (s *SQLStore) queryTrades(ctx context.Context, limit int, query string, args ...interface{}) ([]*Trade, *Cursor, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var trades []*Trade
+	for rows.Next() {
+		t, err := scanTrade(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		trades = append(trades, t)
+	}
+	if err := rows.Err(); err != nil || len(trades) < limit {
+		return trades, nil, err
+	}
+	last := trades[len(trades)-1]
+	return trades, &Cursor{CreatedAt: last.CreatedAt, Id: last.TradeId}, nil
+}
+
+func (s *SQLStore) CountPendingTransfers(ctx context.Context) (int64, error) {
+	return sqlCount(ctx, s.db, "SELECT COUNT(*) FROM transfers")
+}
+
+func (s *SQLStore) ListPendingTransfers(ctx context.Context, broker string, limit int) ([]*Transfer, error) {
+	query := fmt.Sprintf("SELECT %s FROM transfers WHERE broker_id=$1 ORDER BY created_at LIMIT %d", sqlTransferColumns, limit)
+	rows, err := s.db.QueryContext(ctx, query, broker)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := make([]*Transfer, 0)
+	for rows.Next() {
+		var t Transfer
+		err = rows.Scan(&t.TransferId, &t.Source, &t.Detail, &t.AssetId, &t.Amount, &t.Fee, &t.Reason, &t.CreatedAt, &t.UserId, &t.BrokerId)
+		if err != nil {
+			return transfers, err
+		}
+		transfers = append(transfers, &t)
+	}
+	return transfers, rows.Err()
+}
+
+func (s *SQLStore) ExpireTransfers(ctx context.Context, transfers []*Transfer) error {
+	ids := make([]interface{}, len(transfers))
+	for i, t := range transfers {
+		ids[i] = t.TransferId
+	}
+	query := fmt.Sprintf("DELETE FROM transfers WHERE transfer_id IN (%s)", sqlPlaceholders(1, len(ids)))
+	_, err := s.db.ExecContext(ctx, query, ids...)
+	return err
+}
+
+func (s *SQLStore) ReadTransferTrade(ctx context.Context, tradeId, assetId string) (*Trade, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sqlTradeColumns+" FROM trades WHERE trade_id=$1", tradeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanTrade(rows)
+		if err != nil {
+			return nil, err
+		}
+		if t.settledIn(assetId) {
+			return t, nil
+		}
+	}
+	return nil, rows.Err()
+}
+
+func (s *SQLStore) CreateRefundTransfer(ctx context.Context, r *Rejection, transfer *Transfer) error {
+	return s.transact(ctx, func(tx *sql.Tx) error {
+		err := sqlUpsert(ctx, tx, "rejections", sqlRejectionColumns, "trace_id", r.TraceId, r.UserId, r.AssetId, r.Amount, r.Reason, r.CreatedAt)
+		if err != nil || transfer == nil {
+			return err
+		}
+		err = sqlInsertTransfer(ctx, tx, transfer)
+		if err != nil || number.FromString(transfer.Fee).Sign() <= 0 {
+			return err
+		}
+		return sqlInsertFee(ctx, tx, makeRefundFee(transfer))
+	})
+}
+
+func (s *SQLStore) UserRejection(ctx context.Context, traceId string) (*Rejection, error) {
+	var r Rejection
+	err := s.db.QueryRowContext(ctx, "SELECT "+sqlRejectionColumns+" FROM rejections WHERE trace_id=$1", traceId).Scan(&r.TraceId, &r.UserId, &r.AssetId, &r.Amount, &r.Reason, &r.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &r, err
+}
+
+func scanOrder(row sqlScanner) (*Order, error) {
+	var o Order
+	err := row.Scan(&o.OrderId, &o.OrderType, &o.QuoteAssetId, &o.BaseAssetId, &o.Side, &o.Price, &o.RemainingAmount, &o.FilledAmount, &o.RemainingFunds, &o.FilledFunds, &o.CreatedAt, &o.State, &o.UserId, &o.BrokerId)
+	return &o, err
+}
+
+func scanTrade(row sqlScanner) (*Trade, error) {
+	var t Trade
+	err := row.Scan(&t.TradeId, &t.Liquidity, &t.AskOrderId, &t.BidOrderId, &t.QuoteAssetId, &t.BaseAssetId, &t.Side, &t.Price, &t.Amount, &t.CreatedAt, &t.UserId, &t.FeeAssetId, &t.FeeAmount)
+	return &t, err
+}
+
+func sqlInsertTrade(ctx context.Context, q sqlQueryer, t *Trade) error {
+	return sqlInsert(ctx, q, "trades", sqlTradeColumns, t.TradeId, t.Liquidity, t.AskOrderId, t.BidOrderId, t.QuoteAssetId, t.BaseAssetId, t.Side, t.Price, t.Amount, t.CreatedAt, t.UserId, t.FeeAssetId, t.FeeAmount)
+}
+
+func sqlInsertTransfer(ctx context.Context, q sqlQueryer, t *Transfer) error {
+	return sqlInsert(ctx, q, "transfers", sqlTransferColumns, t.TransferId, t.Source, t.Detail, t.AssetId, t.Amount, t.Fee, t.Reason, t.CreatedAt, t.UserId, t.BrokerId)
+}
+
+func sqlInsertFee(ctx context.Context, q sqlQueryer, f *Fee) error {
+	return sqlInsert(ctx, q, "fees", sqlFeeColumns, f.Day, f.Market, f.AssetId, f.FeeId, f.Source, f.Amount, f.CreatedAt)
+}
@@ -0,0 +1,94 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	_ "embed"
+	"regexp"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+//go:embed sqlite.sql
+var sqliteSchema string
+
+var sqlitePlaceholder = regexp.MustCompile(`\$(\d+)`)
+
+// NewSQLiteStore opens the embedded SQLite database of the path, and creates
+// the schema sqlite.sql in a new database. An existing database must record
+// the SQLSchemaVersion. All the transactions take the write lock when they
+// begin, so they are serialized like the serializable ones.
+func NewSQLiteStore(path string) (*SQLStore, error) {
+	dsn := "file:" + path + "?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate&_foreign_keys=1"
+	db := sql.OpenDB(&sqliteConnector{dsn: dsn})
+	store := &SQLStore{db: db, isolation: sql.LevelDefault, retryable: sqliteRetryable}
+	var tables int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='properties'").Scan(&tables)
+	if err == nil && tables == 0 {
+		_, err = db.Exec(sqliteSchema)
+	}
+	if err == nil {
+		err = store.checkSchemaVersion(context.Background())
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func sqliteRetryable(err error) bool {
+	e, ok := err.(sqlite3.Error)
+	return ok && (e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked)
+}
+
+type sqliteConnector struct {
+	dsn    string
+	driver sqlite3.SQLiteDriver
+}
+
+func (c *sqliteConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+func (c *sqliteConnector) Driver() driver.Driver {
+	return &c.driver
+}
+
+// sqliteConn rebinds the $N placeholders of PostgreSQL to the ?N of SQLite,
+// a $N of SQLite is a named parameter numbered by its first appearance.
+type sqliteConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c *sqliteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.SQLiteConn.PrepareContext(ctx, sqliteRebind(query))
+}
+
+func (c *sqliteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.SQLiteConn.ExecContext(ctx, sqliteRebind(query), args)
+}
+
+func (c *sqliteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.SQLiteConn.QueryContext(ctx, sqliteRebind(query), args)
+}
+
+// CheckNamedValue writes the times in UTC, so they are ordered as the text
+// they are stored in.
+func (c *sqliteConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if t, ok := nv.Value.(time.Time); ok {
+		nv.Value = t.UTC()
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+func sqliteRebind(query string) string {
+	return sqlitePlaceholder.ReplaceAllString(query, "?$1")
+}
@@ -0,0 +1,185 @@
+CREATE TABLE IF NOT EXISTS properties (
+  key         VARCHAR(512) NOT NULL PRIMARY KEY,
+  value       VARCHAR(8192) NOT NULL,
+  updated_at  TIMESTAMP NOT NULL
+);
+
+
+CREATE TABLE IF NOT EXISTS brokers (
+  broker_id         VARCHAR(36) NOT NULL PRIMARY KEY,
+  session_id        VARCHAR(36) NOT NULL,
+  session_key       VARCHAR(1024) NOT NULL,
+  pin_token         VARCHAR(512) NOT NULL,
+  encrypted_pin     VARCHAR(512) NOT NULL,
+  encryption_header BLOB NOT NULL,
+  revenue_share     VARCHAR(128) NOT NULL DEFAULT '0',
+  created_at        TIMESTAMP NOT NULL
+);
+
+
+CREATE TABLE IF NOT EXISTS orders (
+  order_id          VARCHAR(36) NOT NULL PRIMARY KEY,
+  order_type        VARCHAR(36) NOT NULL,
+  quote_asset_id    VARCHAR(36) NOT NULL,
+  base_asset_id     VARCHAR(36) NOT NULL,
+  side              VARCHAR(36) NOT NULL,
+  price             VARCHAR(128) NOT NULL,
+  remaining_amount  VARCHAR(128) NOT NULL,
+  filled_amount     VARCHAR(128) NOT NULL,
+  remaining_funds   VARCHAR(128) NOT NULL,
+  filled_funds      VARCHAR(128) NOT NULL,
+  created_at        TIMESTAMP NOT NULL,
+  state             VARCHAR(36) NOT NULL,
+  user_id           VARCHAR(36) NOT NULL,
+  broker_id         VARCHAR(36) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS orders_by_user_state_created ON orders(user_id, state, created_at, order_id);
+
+
+CREATE TABLE IF NOT EXISTS actions (
+  order_id     VARCHAR(36) NOT NULL REFERENCES orders(order_id) ON DELETE CASCADE,
+  action       VARCHAR(36) NOT NULL,
+  created_at   TIMESTAMP NOT NULL,
+  PRIMARY KEY(order_id, action)
+);
+
+CREATE INDEX IF NOT EXISTS actions_by_created ON actions(created_at);
+
+
+CREATE TABLE IF NOT EXISTS trades (
+  trade_id          VARCHAR(36) NOT NULL,
+  liquidity         VARCHAR(36) NOT NULL,
+  ask_order_id      VARCHAR(36) NOT NULL,
+  bid_order_id      VARCHAR(36) NOT NULL,
+  quote_asset_id    VARCHAR(36) NOT NULL,
+  base_asset_id     VARCHAR(36) NOT NULL,
+  side              VARCHAR(36) NOT NULL,
+  price             VARCHAR(128) NOT NULL,
+  amount            VARCHAR(128) NOT NULL,
+  created_at        TIMESTAMP NOT NULL,
+  user_id           VARCHAR(36) NOT NULL,
+  fee_asset_id      VARCHAR(36) NOT NULL,
+  fee_amount        VARCHAR(128) NOT NULL,
+  PRIMARY KEY(trade_id, liquidity)
+);
+
+CREATE INDEX IF NOT EXISTS trades_by_base_quote_created ON trades(base_asset_id, quote_asset_id, created_at, trade_id);
+CREATE INDEX IF NOT EXISTS trades_by_ask_order_created ON trades(ask_order_id, created_at, trade_id);
+CREATE INDEX IF NOT EXISTS trades_by_bid_order_created ON trades(bid_order_id, created_at, trade_id);
+CREATE INDEX IF NOT EXISTS trades_by_user_created ON trades(user_id, created_at, trade_id, liquidity);
+
+
+CREATE TABLE IF NOT EXISTS transfers (
+  transfer_id       VARCHAR(36) NOT NULL PRIMARY KEY,
+  source            VARCHAR(36) NOT NULL,
+  detail            VARCHAR(36) NOT NULL,
+  asset_id          VARCHAR(36) NOT NULL,
+  amount            VARCHAR(128) NOT NULL,
+  fee               VARCHAR(128) NOT NULL,
+  reason            VARCHAR(36) NOT NULL DEFAULT '',
+  created_at        TIMESTAMP NOT NULL,
+  user_id           VARCHAR(36) NOT NULL,
+  broker_id         VARCHAR(36) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS transfers_by_broker_created ON transfers(broker_id, created_at);
+
+
+CREATE TABLE IF NOT EXISTS users (
+  user_id          VARCHAR(36) NOT NULL PRIMARY KEY,
+  public_key       VARCHAR(512) NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_by_public_key ON users(public_key);
+
+
+CREATE TABLE IF NOT EXISTS rejections (
+  trace_id         VARCHAR(36) NOT NULL PRIMARY KEY,
+  user_id          VARCHAR(36) NOT NULL,
+  asset_id         VARCHAR(36) NOT NULL,
+  amount           VARCHAR(128) NOT NULL,
+  reason           VARCHAR(36) NOT NULL,
+  created_at       TIMESTAMP NOT NULL
+);
+
+
+CREATE TABLE IF NOT EXISTS fee_balances (
+  user_id          VARCHAR(36) NOT NULL PRIMARY KEY,
+  asset_id         VARCHAR(36) NOT NULL,
+  balance          VARCHAR(128) NOT NULL,
+  enabled          BOOLEAN NOT NULL,
+  updated_at       TIMESTAMP NOT NULL
+);
+
+
+CREATE TABLE IF NOT EXISTS fee_balance_entries (
+  user_id          VARCHAR(36) NOT NULL,
+  entry_id         VARCHAR(36) NOT NULL,
+  source           VARCHAR(36) NOT NULL,
+  amount           VARCHAR(128) NOT NULL,
+  created_at       TIMESTAMP NOT NULL,
+  PRIMARY KEY(user_id, entry_id)
+);
+
+
+CREATE TABLE IF NOT EXISTS fees (
+  day              VARCHAR(10) NOT NULL,
+  market           VARCHAR(73) NOT NULL,
+  asset_id         VARCHAR(36) NOT NULL,
+  fee_id           VARCHAR(36) NOT NULL,
+  source           VARCHAR(36) NOT NULL,
+  amount           VARCHAR(128) NOT NULL,
+  created_at       TIMESTAMP NOT NULL,
+  PRIMARY KEY(day, fee_id)
+);
+
+
+CREATE TABLE IF NOT EXISTS broker_revenues (
+  broker_id        VARCHAR(36) NOT NULL,
+  revenue_id       VARCHAR(36) NOT NULL,
+  asset_id         VARCHAR(36) NOT NULL,
+  amount           VARCHAR(128) NOT NULL,
+  created_at       TIMESTAMP NOT NULL,
+  collector_id     VARCHAR(36) NOT NULL DEFAULT '',
+  PRIMARY KEY(broker_id, revenue_id)
+);
+
+
+CREATE TABLE IF NOT EXISTS broker_payouts (
+  broker_id        VARCHAR(36) NOT NULL,
+  payout_id        VARCHAR(36) NOT NULL,
+  asset_id         VARCHAR(36) NOT NULL,
+  amount           VARCHAR(128) NOT NULL,
+  created_at       TIMESTAMP NOT NULL,
+  PRIMARY KEY(broker_id, payout_id)
+);
+
+
+CREATE TABLE IF NOT EXISTS candles (
+  base             VARCHAR(36) NOT NULL,
+  quote            VARCHAR(36) NOT NULL,
+  granularity      BIGINT NOT NULL,
+  point            BIGINT NOT NULL,
+  open             VARCHAR(128) NOT NULL,
+  close            VARCHAR(128) NOT NULL,
+  high             VARCHAR(128) NOT NULL,
+  low              VARCHAR(128) NOT NULL,
+  volume           VARCHAR(128) NOT NULL,
+  total            VARCHAR(128) NOT NULL,
+  PRIMARY KEY(base, quote, granularity, point)
+);
+
+
+CREATE TABLE IF NOT EXISTS book_events (
+  market           VARCHAR(73) NOT NULL,
+  sequence         BIGINT NOT NULL,
+  type             VARCHAR(36) NOT NULL,
+  data             TEXT NOT NULL,
+  created_at       TIMESTAMP NOT NULL,
+  PRIMARY KEY(market, sequence, type)
+);
+
+CREATE INDEX IF NOT EXISTS book_events_by_market_type_sequence ON book_events(market, type, sequence);
+
+INSERT INTO properties (key, value, updated_at) VALUES ('persistence-schema-version', '12', CURRENT_TIMESTAMP);
@@ -0,0 +1,253 @@
+package persistence
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/engine"
+)
+
+// Store is the database of the exchange, all writes of an action, a match or
+// a settlement are made in a single transaction of the backend. The arguments
+// are validated by the package functions before calling the store.
+type Store interface {
+	CountPendingActions(ctx context.Context) (int64, error)
+	ListPendingActions(ctx context.Context, checkpoint time.Time, limit int) ([]*Action, error)
+	// CreateOrderAction writes the order and its create action, nothing is
+	// written if the order exists.
+	CreateOrderAction(ctx context.Context, order *Order) error
+	// CancelOrderAction writes the cancel action of a pending limit order of
+	// the user, or of any user if it's the exchange.
+	CancelOrderAction(ctx context.Context, orderId string, createdAt time.Time, userId string) error
+	UserOrder(ctx context.Context, orderId, userId string) (*Order, error)
+	UserOrders(ctx context.Context, userId, base, quote, state string, cursor *Cursor, order string, limit int) ([]*Order, *Cursor, error)
+
+	// Transact writes the settlement of a match, with the order updates, the
+	// fee balance charges, the broker revenues, the fee ledger and the candles.
+	Transact(ctx context.Context, taker, maker *engine.Order, amount number.Integer) ([]*Trade, []*Transfer, error)
+	CancelOrder(ctx context.Context, order *engine.Order) (*Transfer, error)
+	LastTrade(ctx context.Context, base, quote string) (*Trade, error)
+	MarketTrades(ctx context.Context, base, quote string, cursor *Cursor, order string, limit int) ([]*Trade, *Cursor, error)
+	UserOrderTrades(ctx context.Context, o *Order, cursor *Cursor, limit int) ([]*Trade, *Cursor, error)
+	UserTrades(ctx context.Context, userId string, filter *TradeFilter, cursor *Cursor, order string, limit int) ([]*Trade, *Cursor, error)
+
+	CountPendingTransfers(ctx context.Context) (int64, error)
+	ListPendingTransfers(ctx context.Context, broker string, limit int) ([]*Transfer, error)
+	ExpireTransfers(ctx context.Context, transfers []*Transfer) error
+	ReadTransferTrade(ctx context.Context, tradeId, assetId string) (*Trade, error)
+	// CreateRefundTransfer writes the rejection, and the refund transfer with
+	// its fee if the amount is not exhausted.
+	CreateRefundTransfer(ctx context.Context, rejection *Rejection, transfer *Transfer) error
+	UserRejection(ctx context.Context, traceId string) (*Rejection, error)
+
+	// UpdateUserPublicKey sets the public key of the user, unless the key is
+	// used by any user.
+	UpdateUserPublicKey(ctx context.Context, userId, publicKey string) error
+	ReadUserPublicKey(ctx context.Context, userId string) (string, error)
+	ReadBrokers(ctx context.Context) ([]*Broker, error)
+	CreateBroker(ctx context.Context, broker *Broker) error
+	ReadProperty(ctx context.Context, key string) (string, error)
+	WriteProperty(ctx context.Context, key, value string) error
+
+	ReadFeeBalance(ctx context.Context, userId string) (*FeeBalance, error)
+	UpdateFeeBalance(ctx context.Context, update *FeeBalanceUpdate) error
+	// ReadFees reads the fee ledger between the start and end days inclusive.
+	ReadFees(ctx context.Context, start, end string) ([]*Fee, error)
+	PayBrokerRevenues(ctx context.Context, brokerId string, limit int) (int, error)
+	ReadBrokerRevenues(ctx context.Context, brokerId string) (map[string]number.Decimal, map[string]number.Decimal, error)
+	// ReadCandles reads the last candles of the market, the latest first.
+	ReadCandles(ctx context.Context, base, quote string, granularity, limit int64) ([]*Candle, error)
+	BackfillCandles(ctx context.Context) error
+}
+
+func CountPendingActions(ctx context.Context) (int64, error) {
+	return Backend(ctx).CountPendingActions(ctx)
+}
+
+func ListPendingActions(ctx context.Context, checkpoint time.Time, limit int) ([]*Action, error) {
+	return Backend(ctx).ListPendingActions(ctx, checkpoint, limit)
+}
+
+func CreateOrderAction(ctx context.Context, o *engine.Order, userId, brokerId string, createdAt time.Time) error {
+	return Backend(ctx).CreateOrderAction(ctx, newPendingOrder(o, userId, brokerId, createdAt))
+}
+
+func CancelOrderAction(ctx context.Context, orderId string, createdAt time.Time, userId string) error {
+	return Backend(ctx).CancelOrderAction(ctx, orderId, createdAt, userId)
+}
+
+func UserOrder(ctx context.Context, orderId, userId string) (*Order, error) {
+	return Backend(ctx).UserOrder(ctx, orderId, userId)
+}
+
+func UserOrders(ctx context.Context, userId string, market, state string, cursor *Cursor, order string, limit int) ([]*Order, *Cursor, error) {
+	base, quote := getBaseQuote(market)
+	return Backend(ctx).UserOrders(ctx, userId, base, quote, state, cursor, normalizeOrder(order), normalizeLimit(limit))
+}
+
+// Transact writes the trades and settlement transfers of a match, the ask
+// trade is always the first one.
+func Transact(ctx context.Context, taker, maker *engine.Order, amount number.Integer) ([]*Trade, []*Transfer, error) {
+	return Backend(ctx).Transact(ctx, taker, maker, amount)
+}
+
+func CancelOrder(ctx context.Context, order *engine.Order) (*Transfer, error) {
+	return Backend(ctx).CancelOrder(ctx, order)
+}
+
+func LastTrade(ctx context.Context, market string) (*Trade, error) {
+	base, quote := getBaseQuote(market)
+	if base == "" || quote == "" {
+		return nil, nil
+	}
+	return Backend(ctx).LastTrade(ctx, base, quote)
+}
+
+func MarketTrades(ctx context.Context, market string, cursor *Cursor, order string, limit int) ([]*Trade, *Cursor, error) {
+	base, quote := getBaseQuote(market)
+	if base == "" || quote == "" {
+		return nil, nil, nil
+	}
+	return Backend(ctx).MarketTrades(ctx, base, quote, cursor, normalizeOrder(order), normalizeLimit(limit))
+}
+
+func UserOrderTrades(ctx context.Context, o *Order, cursor *Cursor, limit int) ([]*Trade, *Cursor, error) {
+	return Backend(ctx).UserOrderTrades(ctx, o, cursor, normalizeLimit(limit))
+}
+
+func UserTrades(ctx context.Context, userId, market, side, liquidity string, start, end time.Time, cursor *Cursor, order string, limit int) ([]*Trade, *Cursor, error) {
+	filter := &TradeFilter{Start: start, End: end}
+	filter.Base, filter.Quote = getBaseQuote(market)
+	if side == engine.PageSideAsk || side == engine.PageSideBid {
+		filter.Side = side
+	}
+	if liquidity == TradeLiquidityMaker || liquidity == TradeLiquidityTaker {
+		filter.Liquidity = liquidity
+	}
+	return Backend(ctx).UserTrades(ctx, userId, filter, cursor, normalizeOrder(order), normalizeLimit(limit))
+}
+
+func CountPendingTransfers(ctx context.Context) (int64, error) {
+	return Backend(ctx).CountPendingTransfers(ctx)
+}
+
+func ListPendingTransfers(ctx context.Context, broker string, limit int) ([]*Transfer, error) {
+	return Backend(ctx).ListPendingTransfers(ctx, broker, limit)
+}
+
+func ExpireTransfers(ctx context.Context, transfers []*Transfer) error {
+	if len(transfers) == 0 {
+		return nil
+	}
+	return Backend(ctx).ExpireTransfers(ctx, transfers)
+}
+
+func ReadTransferTrade(ctx context.Context, tradeId, assetId string) (*Trade, error) {
+	return Backend(ctx).ReadTransferTrade(ctx, tradeId, assetId)
+}
+
+func CreateRefundTransfer(ctx context.Context, brokerId, userId, assetId string, amount, fee number.Decimal, trace, reason string) error {
+	rejection := &Rejection{
+		TraceId:   trace,
+		UserId:    userId,
+		AssetId:   assetId,
+		Amount:    amount.Add(fee).Persist(),
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+	if amount.Exhausted() {
+		return Backend(ctx).CreateRefundTransfer(ctx, rejection, nil)
+	}
+	transfer := &Transfer{
+		TransferId: getSettlementId(trace, "REFUND"),
+		Source:     TransferSourceOrderInvalid,
+		Detail:     trace,
+		AssetId:    assetId,
+		Amount:     amount.Persist(),
+		Fee:        fee.Persist(),
+		Reason:     reason,
+		CreatedAt:  time.Now(),
+		UserId:     userId,
+		BrokerId:   brokerId,
+	}
+	return Backend(ctx).CreateRefundTransfer(ctx, rejection, transfer)
+}
+
+func UserRejection(ctx context.Context, traceId, userId string) (*Rejection, error) {
+	r, err := Backend(ctx).UserRejection(ctx, traceId)
+	if err != nil || r == nil || r.UserId != userId {
+		return nil, err
+	}
+	return r, nil
+}
+
+func UpdateUserPublicKey(ctx context.Context, userId, publicKey string) error {
+	pkix, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return nil
+	}
+	_, err = x509.ParsePKIXPublicKey(pkix)
+	if err != nil {
+		return nil
+	}
+	return Backend(ctx).UpdateUserPublicKey(ctx, userId, publicKey)
+}
+
+func ReadProperty(ctx context.Context, key string) (string, error) {
+	return Backend(ctx).ReadProperty(ctx, key)
+}
+
+func WriteProperty(ctx context.Context, key, value string) error {
+	return Backend(ctx).WriteProperty(ctx, key, value)
+}
+
+func ReadFeeBalance(ctx context.Context, userId string) (*FeeBalance, error) {
+	return Backend(ctx).ReadFeeBalance(ctx, userId)
+}
+
+// UpdateFeeBalance deposits the snapshot amount to the user fee balance, then
+// optionally toggles the fee discount preference or withdraws the whole balance.
+func UpdateFeeBalance(ctx context.Context, brokerId, userId string, amount number.Decimal, enabled *bool, withdraw bool, trace string, createdAt time.Time) error {
+	return Backend(ctx).UpdateFeeBalance(ctx, &FeeBalanceUpdate{
+		BrokerId:  brokerId,
+		UserId:    userId,
+		Amount:    amount,
+		Enabled:   enabled,
+		Withdraw:  withdraw,
+		Trace:     trace,
+		CreatedAt: createdAt,
+	})
+}
+
+// PayBrokerRevenues settles the accrued revenues of the broker, one transfer
+// for each asset is sent from the exchange to the broker.
+func PayBrokerRevenues(ctx context.Context, brokerId string, limit int) (int, error) {
+	return Backend(ctx).PayBrokerRevenues(ctx, brokerId, limit)
+}
+
+// ReadBrokerRevenues sums the accrued and the paid revenues of the broker by asset.
+func ReadBrokerRevenues(ctx context.Context, brokerId string) (map[string]number.Decimal, map[string]number.Decimal, error) {
+	return Backend(ctx).ReadBrokerRevenues(ctx, brokerId)
+}
+
+// BackfillCandles rebuilds the candles of all markets from the trades history.
+func BackfillCandles(ctx context.Context) error {
+	return Backend(ctx).BackfillCandles(ctx)
+}
+
+func normalizeLimit(limit int) int {
+	if limit > 100 || limit <= 0 {
+		return 100
+	}
+	return limit
+}
+
+func normalizeOrder(order string) string {
+	if order != "DESC" {
+		return "ASC"
+	}
+	return order
+}
@@ -13,8 +13,8 @@ import (
 )
 
 const (
-	MakerFeeRate = "0.000"
-	TakerFeeRate = "0.001"
+	MakerRebateRate = "0.0002"
+	TakerFeeRate    = "0.001"
 
 	TradeLiquidityTaker = "TAKER"
 	TradeLiquidityMaker = "MAKER"
@@ -36,36 +36,139 @@ type Trade struct {
 	FeeAmount    string    `spanner:"fee_amount"`
 }
 
-func Transact(ctx context.Context, taker, maker *engine.Order, amount number.Integer) (string, error) {
+// TradeFilter narrows the trades of a user, the zero fields are ignored.
+type TradeFilter struct {
+	Base      string
+	Quote     string
+	Side      string
+	Liquidity string
+	Start     time.Time
+	End       time.Time
+}
+
+// settlementReader reads the state a match depends on, in the transaction of
+// the store which writes the settlement.
+type settlementReader interface {
+	readFeeBalance(ctx context.Context, userId string) (*FeeBalance, error)
+	readBrokerRevenueShare(ctx context.Context, brokerId string) (number.Decimal, error)
+	readCandles(ctx context.Context, candles []*Candle) ([]*Candle, error)
+	lastTrade(ctx context.Context, base, quote string) (*Trade, error)
+}
+
+// settlement is all the writes of a match, the ask trade and transfer are
+// always the first ones.
+type settlement struct {
+	taker       *engine.Order
+	maker       *engine.Order
+	trades      []*Trade
+	transfers   []*Transfer
+	feeEntries  []*FeeBalanceEntry
+	feeBalances []*FeeBalance
+	revenues    []*BrokerRevenue
+	fees        []*Fee
+	candles     []*Candle
+}
+
+func settle(ctx context.Context, r settlementReader, taker, maker *engine.Order, amount number.Integer) (*settlement, error) {
 	askTrade, bidTrade := makeTrades(taker, maker, amount.Decimal())
 	askTransfer, bidTransfer := handleFees(askTrade, bidTrade, taker, maker)
+	st := &settlement{
+		taker:     taker,
+		maker:     maker,
+		trades:    []*Trade{askTrade, bidTrade},
+		transfers: []*Transfer{askTransfer, bidTransfer},
+	}
 
-	askTradeMutation, err := spanner.InsertStruct("trades", askTrade)
+	var err error
+	var discounted []bool
+	st.feeEntries, st.feeBalances, discounted, err = chargeFeeBalances(ctx, r, st.trades, st.transfers)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	bidTradeMutation, err := spanner.InsertStruct("trades", bidTrade)
-	if err != nil {
-		return "", err
+	askBrokerId, bidBrokerId := taker.BrokerId, maker.BrokerId
+	takerTrade, makerTrade, takerTransfer, takerDiscounted := askTrade, bidTrade, askTransfer, discounted[0]
+	if taker.Side == engine.PageSideBid {
+		askBrokerId, bidBrokerId = maker.BrokerId, taker.BrokerId
+		takerTrade, makerTrade, takerTransfer, takerDiscounted = bidTrade, askTrade, bidTransfer, discounted[1]
 	}
-
-	askTransferMutation, err := spanner.InsertStruct("transfers", askTransfer)
-	if err != nil {
-		return "", err
+	if rebate := handleMakerRebate(takerTrade, makerTrade, takerTransfer, takerDiscounted); rebate != nil {
+		st.transfers = append(st.transfers, rebate)
 	}
-	bidTransferMutation, err := spanner.InsertStruct("transfers", bidTransfer)
+	st.revenues, err = accrueBrokerRevenues(ctx, r, st.trades, st.transfers, []string{askBrokerId, bidBrokerId})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	st.fees = makeTradeFees(st.trades)
+	st.candles, err = mergeCandles(ctx, r, askTrade)
+	return st, err
+}
+
+func (s *SpannerStore) Transact(ctx context.Context, taker, maker *engine.Order, amount number.Integer) ([]*Trade, []*Transfer, error) {
+	var trades []*Trade
+	var transfers []*Transfer
+	_, err := s.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		st, err := settle(ctx, &spannerReader{txn: txn}, taker, maker, amount)
+		if err != nil {
+			return err
+		}
+		trades, transfers = st.trades, st.transfers
 
-	mutations := makeOrderMutations(taker, maker)
-	mutations = append(mutations, askTradeMutation, bidTradeMutation)
-	mutations = append(mutations, askTransferMutation, bidTransferMutation)
-	_, err = Spanner(ctx).Apply(ctx, mutations)
-	return askTrade.TradeId, err
+		mutations := makeOrderMutations(taker, maker)
+		for _, t := range st.trades {
+			mutation, err := spanner.InsertStruct("trades", t)
+			if err != nil {
+				return err
+			}
+			mutations = append(mutations, mutation)
+		}
+		for _, t := range st.transfers {
+			mutation, err := spanner.InsertStruct("transfers", t)
+			if err != nil {
+				return err
+			}
+			mutations = append(mutations, mutation)
+		}
+		for _, e := range st.feeEntries {
+			mutation, err := spanner.InsertStruct("fee_balance_entries", e)
+			if err != nil {
+				return err
+			}
+			mutations = append(mutations, mutation)
+		}
+		for _, b := range st.feeBalances {
+			mutation, err := spanner.InsertOrUpdateStruct("fee_balances", b)
+			if err != nil {
+				return err
+			}
+			mutations = append(mutations, mutation)
+		}
+		for _, r := range st.revenues {
+			mutation, err := spanner.InsertStruct("broker_revenues", r)
+			if err != nil {
+				return err
+			}
+			mutations = append(mutations, mutation)
+		}
+		for _, f := range st.fees {
+			mutation, err := spanner.InsertStruct("fees", f)
+			if err != nil {
+				return err
+			}
+			mutations = append(mutations, mutation)
+		}
+		for _, c := range st.candles {
+			mutation, err := spanner.InsertOrUpdateStruct("candles", c)
+			if err != nil {
+				return err
+			}
+			mutations = append(mutations, mutation)
+		}
+		return txn.BufferWrite(mutations)
+	})
+	return trades, transfers, err
 }
 
-func CancelOrder(ctx context.Context, order *engine.Order) error {
+func (s *SpannerStore) CancelOrder(ctx context.Context, order *engine.Order) (*Transfer, error) {
 	orderCols := []string{"order_id", "filled_amount", "remaining_amount", "filled_funds", "remaining_funds", "state"}
 	orderVals := []interface{}{order.Id, order.FilledAmount.Persist(), order.RemainingAmount.Persist(), order.FilledFunds.Persist(), order.RemainingFunds.Persist(), OrderStateDone}
 	mutations := []*spanner.Mutation{
@@ -74,6 +177,17 @@ func CancelOrder(ctx context.Context, order *engine.Order) error {
 		spanner.Delete("actions", spanner.Key{order.Id, engine.OrderActionCancel}),
 	}
 
+	transfer := makeCancelTransfer(order)
+	transferMutation, err := spanner.InsertStruct("transfers", transfer)
+	if err != nil {
+		return nil, err
+	}
+	mutations = append(mutations, transferMutation)
+	_, err = s.client.Apply(ctx, mutations)
+	return transfer, err
+}
+
+func makeCancelTransfer(order *engine.Order) *Transfer {
 	transfer := &Transfer{
 		TransferId: getSettlementId(order.Id, engine.OrderActionCancel),
 		Source:     TransferSourceOrderCancelled,
@@ -89,13 +203,7 @@ func CancelOrder(ctx context.Context, order *engine.Order) error {
 		transfer.AssetId = order.Quote
 		transfer.Amount = order.RemainingFunds.Persist()
 	}
-	transferMutation, err := spanner.InsertStruct("transfers", transfer)
-	if err != nil {
-		return err
-	}
-	mutations = append(mutations, transferMutation)
-	_, err = Spanner(ctx).Apply(ctx, mutations)
-	return err
+	return transfer
 }
 
 func makeOrderMutations(taker, maker *engine.Order) []*spanner.Mutation {
@@ -103,11 +211,11 @@ func makeOrderMutations(taker, maker *engine.Order) []*spanner.Mutation {
 	takerOrderVals := []interface{}{taker.Id, taker.FilledAmount.Persist(), taker.RemainingAmount.Persist(), taker.FilledFunds.Persist(), taker.RemainingFunds.Persist()}
 	makerOrderCols := []string{"order_id", "filled_amount", "remaining_amount", "filled_funds", "remaining_funds"}
 	makerOrderVals := []interface{}{maker.Id, maker.FilledAmount.Persist(), maker.RemainingAmount.Persist(), maker.FilledFunds.Persist(), maker.RemainingFunds.Persist()}
-	if taker.RemainingAmount.IsZero() && taker.RemainingFunds.IsZero() {
+	if orderFilled(taker) {
 		takerOrderCols = append(takerOrderCols, "state")
 		takerOrderVals = append(takerOrderVals, OrderStateDone)
 	}
-	if maker.RemainingAmount.IsZero() && maker.RemainingFunds.IsZero() {
+	if orderFilled(maker) {
 		makerOrderCols = append(makerOrderCols, "state")
 		makerOrderVals = append(makerOrderVals, OrderStateDone)
 	}
@@ -116,24 +224,28 @@ func makeOrderMutations(taker, maker *engine.Order) []*spanner.Mutation {
 		spanner.Update("orders", makerOrderCols, makerOrderVals),
 	}
 
-	if taker.RemainingAmount.IsZero() && taker.RemainingFunds.IsZero() {
+	if orderFilled(taker) {
 		mutations = append(mutations, spanner.Delete("actions", spanner.Key{taker.Id, engine.OrderActionCreate}))
 		mutations = append(mutations, spanner.Delete("actions", spanner.Key{taker.Id, engine.OrderActionCancel}))
 	}
-	if maker.RemainingAmount.IsZero() && maker.RemainingFunds.IsZero() {
+	if orderFilled(maker) {
 		mutations = append(mutations, spanner.Delete("actions", spanner.Key{maker.Id, engine.OrderActionCreate}))
 		mutations = append(mutations, spanner.Delete("actions", spanner.Key{maker.Id, engine.OrderActionCancel}))
 	}
 	return mutations
 }
 
+func orderFilled(o *engine.Order) bool {
+	return o.RemainingAmount.IsZero() && o.RemainingFunds.IsZero()
+}
+
 func makeTrades(taker, maker *engine.Order, amount number.Decimal) (*Trade, *Trade) {
 	tradeId := getSettlementId(taker.Id, maker.Id)
 	askOrderId, bidOrderId := taker.Id, maker.Id
 	if taker.Side == engine.PageSideBid {
 		askOrderId, bidOrderId = maker.Id, taker.Id
 	}
-	price := maker.Price.Decimal()
+	price, createdAt := maker.Price.Decimal(), time.Now()
 
 	takerTrade := &Trade{
 		TradeId:      tradeId,
@@ -145,7 +257,7 @@ func makeTrades(taker, maker *engine.Order, amount number.Decimal) (*Trade, *Tra
 		Side:         taker.Side,
 		Price:        price.Persist(),
 		Amount:       amount.Persist(),
-		CreatedAt:    time.Now(),
+		CreatedAt:    createdAt,
 		UserId:       taker.UserId,
 	}
 	makerTrade := &Trade{
@@ -158,7 +270,7 @@ func makeTrades(taker, maker *engine.Order, amount number.Decimal) (*Trade, *Tra
 		Side:         maker.Side,
 		Price:        price.Persist(),
 		Amount:       amount.Persist(),
-		CreatedAt:    time.Now(),
+		CreatedAt:    createdAt,
 		UserId:       maker.UserId,
 	}
 
@@ -169,13 +281,13 @@ func makeTrades(taker, maker *engine.Order, amount number.Decimal) (*Trade, *Tra
 	return askTrade, bidTrade
 }
 
+// handleFees deducts the taker fee from the proceeds of the taker, the maker
+// pays no fee and is rebated by handleMakerRebate.
 func handleFees(ask, bid *Trade, taker, maker *engine.Order) (*Transfer, *Transfer) {
 	total := number.FromString(ask.Amount).Mul(number.FromString(ask.Price))
-	askFee := total.Mul(number.FromString(TakerFeeRate))
-	bidFee := number.FromString(bid.Amount).Mul(number.FromString(MakerFeeRate))
+	askFee, bidFee := total.Mul(number.FromString(TakerFeeRate)), number.Zero()
 	if ask.Liquidity == TradeLiquidityMaker {
-		askFee = total.Mul(number.FromString(MakerFeeRate))
-		bidFee = number.FromString(bid.Amount).Mul(number.FromString(TakerFeeRate))
+		askFee, bidFee = number.Zero(), number.FromString(bid.Amount).Mul(number.FromString(TakerFeeRate))
 	}
 
 	ask.FeeAssetId = ask.QuoteAssetId
@@ -213,6 +325,53 @@ func handleFees(ask, bid *Trade, taker, maker *engine.Order) (*Transfer, *Transf
 	return askTransfer, bidTransfer
 }
 
+// handleMakerRebate pays the maker rebate out of the fee recorded on the
+// TRADE_CONFIRMED transfer of the taker, in the same asset and never more than
+// that fee. When the taker fee is discounted, it's charged from the fee balance
+// in FeeDiscountAssetId instead, so the rebate is a share of the charged fee in
+// that asset. The rebate is a TRADE_CONFIRMED transfer of the maker with the
+// negative fee, paid by the broker of the taker transfer which keeps the fee,
+// and the maker trade records it as its negative fee.
+func handleMakerRebate(taker, maker *Trade, takerTransfer *Transfer, discounted bool) *Transfer {
+	fee, assetId := number.FromString(takerTransfer.Fee), takerTransfer.AssetId
+	if discounted {
+		fee, assetId = number.FromString(taker.FeeAmount), taker.FeeAssetId
+	}
+	rebate := makerRebate(fee)
+	if rebate.Cmp(fee) > 0 {
+		rebate = fee
+	}
+	if rebate.Sign() <= 0 {
+		return nil
+	}
+	maker.FeeAssetId = assetId
+	maker.FeeAmount = rebate.Neg().Persist()
+	return &Transfer{
+		TransferId: getSettlementId(maker.TradeId, FeeSourceRebate),
+		Source:     TransferSourceTradeConfirmed,
+		Detail:     maker.TradeId,
+		AssetId:    assetId,
+		Amount:     rebate.Persist(),
+		Fee:        maker.FeeAmount,
+		CreatedAt:  time.Now(),
+		UserId:     maker.UserId,
+		BrokerId:   takerTransfer.BrokerId,
+	}
+}
+
+// makerRebate is the share of the collected taker fee by the rates, the share
+// is capped at 1 and rounded down to never exceed the collected fee.
+func makerRebate(fee number.Decimal) number.Decimal {
+	if fee.Sign() <= 0 {
+		return number.Zero()
+	}
+	rate, taker := number.FromString(MakerRebateRate), number.FromString(TakerFeeRate)
+	if rate.Cmp(taker) >= 0 {
+		return fee
+	}
+	return fee.Mul(rate).Div(taker).RoundFloor(8)
+}
+
 func getSettlementId(id, modifier string) string {
 	h := md5.New()
 	io.WriteString(h, id)
@@ -0,0 +1,107 @@
+package persistence
+
+import (
+	"testing"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleFees(t *testing.T) {
+	assert := assert.New(t)
+
+	// an ask taker pays the fee in the quote, the bid maker is rebated in the quote,
+	// each transfer is paid by the broker of the order which brought the asset
+	ask, bid := testOrder(engine.PageSideAsk, "taker"), testOrder(engine.PageSideBid, "maker")
+	askTrade, bidTrade := makeTrades(ask, bid, number.FromString("2"))
+	askTransfer, bidTransfer := handleFees(askTrade, bidTrade, ask, bid)
+	assert.Equal(TradeLiquidityTaker, askTrade.Liquidity)
+	assert.Equal("quote", askTrade.FeeAssetId)
+	assert.Equal("0.02", askTrade.FeeAmount)
+	assert.Equal("19.98", askTransfer.Amount)
+	assert.Equal("0.02", askTransfer.Fee)
+	assert.Equal("maker-broker", askTransfer.BrokerId)
+	assert.Equal("base", bidTrade.FeeAssetId)
+	assert.Equal("0", bidTrade.FeeAmount)
+	assert.Equal("2", bidTransfer.Amount)
+	assert.Equal("taker-broker", bidTransfer.BrokerId)
+	rebate := handleMakerRebate(askTrade, bidTrade, askTransfer, false)
+	assert.Equal(TransferSourceTradeConfirmed, rebate.Source)
+	assert.Equal(bidTrade.TradeId, rebate.Detail)
+	assert.Equal("quote", rebate.AssetId)
+	assert.Equal("0.004", rebate.Amount)
+	assert.Equal("-0.004", rebate.Fee)
+	assert.Equal("maker", rebate.UserId)
+	assert.Equal("maker-broker", rebate.BrokerId)
+	assert.NotEqual(bidTransfer.TransferId, rebate.TransferId)
+	assert.Equal("quote", bidTrade.FeeAssetId)
+	assert.Equal("-0.004", bidTrade.FeeAmount)
+	assert.Equal("2", bidTransfer.Amount)
+	assert.True(bidTrade.settledIn("quote"))
+
+	// a bid taker pays the fee in the base, the ask maker is rebated in the base
+	ask, bid = testOrder(engine.PageSideAsk, "maker"), testOrder(engine.PageSideBid, "taker")
+	askTrade, bidTrade = makeTrades(bid, ask, number.FromString("2"))
+	askTransfer, bidTransfer = handleFees(askTrade, bidTrade, bid, ask)
+	assert.Equal(TradeLiquidityMaker, askTrade.Liquidity)
+	assert.Equal("quote", askTrade.FeeAssetId)
+	assert.Equal("0", askTrade.FeeAmount)
+	assert.Equal("20", askTransfer.Amount)
+	assert.Equal("taker-broker", askTransfer.BrokerId)
+	assert.Equal("base", bidTrade.FeeAssetId)
+	assert.Equal("0.002", bidTrade.FeeAmount)
+	assert.Equal("1.998", bidTransfer.Amount)
+	assert.Equal("maker-broker", bidTransfer.BrokerId)
+	rebate = handleMakerRebate(bidTrade, askTrade, bidTransfer, false)
+	assert.Equal("base", rebate.AssetId)
+	assert.Equal("0.0004", rebate.Amount)
+	assert.Equal("maker", rebate.UserId)
+	assert.Equal("maker-broker", rebate.BrokerId)
+	assert.Equal("base", askTrade.FeeAssetId)
+	assert.Equal("-0.0004", askTrade.FeeAmount)
+
+	// the discounted fee is charged from the fee balance, the rebate is a share of it
+	ask, bid = testOrder(engine.PageSideAsk, "maker"), testOrder(engine.PageSideBid, "taker")
+	askTrade, bidTrade = makeTrades(bid, ask, number.FromString("2"))
+	_, bidTransfer = handleFees(askTrade, bidTrade, bid, ask)
+	bidTrade.FeeAssetId, bidTrade.FeeAmount = FeeDiscountAssetId, "0.0015"
+	bidTransfer.Amount, bidTransfer.Fee = "2", "0"
+	rebate = handleMakerRebate(bidTrade, askTrade, bidTransfer, true)
+	assert.Equal(FeeDiscountAssetId, rebate.AssetId)
+	assert.Equal("0.0003", rebate.Amount)
+	assert.Equal(FeeDiscountAssetId, askTrade.FeeAssetId)
+	assert.Equal("-0.0003", askTrade.FeeAmount)
+	assert.True(askTrade.settledIn(FeeDiscountAssetId))
+
+	// no rebate without a taker fee
+	ask, bid = testOrder(engine.PageSideAsk, "maker"), testOrder(engine.PageSideBid, "taker")
+	askTrade, bidTrade = makeTrades(bid, ask, number.FromString("2"))
+	_, bidTransfer = handleFees(askTrade, bidTrade, bid, ask)
+	bidTransfer.Fee = "0"
+	assert.Nil(handleMakerRebate(bidTrade, askTrade, bidTransfer, false))
+	assert.Equal("quote", askTrade.FeeAssetId)
+	assert.Equal("0", askTrade.FeeAmount)
+}
+
+func TestMakerRebate(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("0.0002", makerRebate(number.FromString("0.001")).Persist())
+	assert.Equal("0.00000001", makerRebate(number.FromString("0.00000009")).Persist())
+	assert.Equal("0", makerRebate(number.FromString("0.00000004")).Persist())
+	assert.Equal("0", makerRebate(number.Zero()).Persist())
+	assert.Equal("0", makerRebate(number.FromString("-1")).Persist())
+}
+
+func testOrder(side, userId string) *engine.Order {
+	return &engine.Order{
+		Id:       userId + "-order",
+		Side:     side,
+		Type:     engine.OrderTypeLimit,
+		Price:    number.FromString("10").Integer(4),
+		Quote:    "quote",
+		Base:     "base",
+		UserId:   userId,
+		BrokerId: userId + "-broker",
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"cloud.google.com/go/spanner"
 	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/engine"
 	"google.golang.org/api/iterator"
 )
 
@@ -15,6 +16,8 @@ const (
 	TransferSourceOrderCancelled = "ORDER_CANCELLED"
 	TransferSourceOrderFilled    = "ORDER_FILLED"
 	TransferSourceOrderInvalid   = "ORDER_INVALID"
+	TransferSourceFeeWithdrawn   = "FEE_WITHDRAWN"
+	TransferSourceBrokerRevenue  = "BROKER_REVENUE"
 )
 
 type Transfer struct {
@@ -24,13 +27,14 @@ type Transfer struct {
 	AssetId    string    `spanner:"asset_id"`
 	Amount     string    `spanner:"amount"`
 	Fee        string    `spanner:"fee"`
+	Reason     string    `spanner:"reason"`
 	CreatedAt  time.Time `spanner:"created_at"`
 	UserId     string    `spanner:"user_id"`
 	BrokerId   string    `spanner:"broker_id"`
 }
 
-func CountPendingTransfers(ctx context.Context) (int64, error) {
-	it := Spanner(ctx).Single().Query(ctx, spanner.Statement{
+func (s *SpannerStore) CountPendingTransfers(ctx context.Context) (int64, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{
 		SQL: "SELECT COUNT(*) FROM transfers",
 	})
 	defer it.Stop()
@@ -46,8 +50,8 @@ func CountPendingTransfers(ctx context.Context) (int64, error) {
 	return count, err
 }
 
-func ListPendingTransfers(ctx context.Context, broker string, limit int) ([]*Transfer, error) {
-	txn := Spanner(ctx).ReadOnlyTransaction()
+func (s *SpannerStore) ListPendingTransfers(ctx context.Context, broker string, limit int) ([]*Transfer, error) {
+	txn := s.client.ReadOnlyTransaction()
 	defer txn.Close()
 
 	it := txn.Query(ctx, spanner.Statement{
@@ -95,19 +99,19 @@ func ListPendingTransfers(ctx context.Context, broker string, limit int) ([]*Tra
 	}
 }
 
-func ExpireTransfers(ctx context.Context, transfers []*Transfer) error {
+func (s *SpannerStore) ExpireTransfers(ctx context.Context, transfers []*Transfer) error {
 	var set []spanner.KeySet
 	for _, t := range transfers {
 		set = append(set, spanner.Key{t.TransferId})
 	}
-	_, err := Spanner(ctx).Apply(ctx, []*spanner.Mutation{
+	_, err := s.client.Apply(ctx, []*spanner.Mutation{
 		spanner.Delete("transfers", spanner.KeySets(set...)),
 	})
 	return err
 }
 
-func ReadTransferTrade(ctx context.Context, tradeId, assetId string) (*Trade, error) {
-	it := Spanner(ctx).Single().Query(ctx, spanner.Statement{
+func (s *SpannerStore) ReadTransferTrade(ctx context.Context, tradeId, assetId string) (*Trade, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{
 		SQL:    "SELECT * FROM trades WHERE trade_id=@trade_id",
 		Params: map[string]interface{}{"trade_id": tradeId},
 	})
@@ -125,31 +129,48 @@ func ReadTransferTrade(ctx context.Context, tradeId, assetId string) (*Trade, er
 		if err != nil {
 			return nil, err
 		}
-		if trade.FeeAssetId == assetId {
+		if trade.settledIn(assetId) {
 			return &trade, nil
 		}
 	}
 }
 
-func CreateRefundTransfer(ctx context.Context, brokerId, userId, assetId string, amount, fee number.Decimal, trace string) error {
-	if amount.Exhausted() {
-		return nil
+func (s *SpannerStore) CreateRefundTransfer(ctx context.Context, rejection *Rejection, transfer *Transfer) error {
+	rejectionMutation, err := spanner.InsertOrUpdateStruct("rejections", rejection)
+	if err != nil {
+		return err
 	}
-	transfer := &Transfer{
-		TransferId: getSettlementId(trace, "REFUND"),
-		Source:     TransferSourceOrderInvalid,
-		Detail:     trace,
-		AssetId:    assetId,
-		Amount:     amount.Persist(),
-		Fee:        fee.Persist(),
-		CreatedAt:  time.Now(),
-		UserId:     userId,
-		BrokerId:   brokerId,
+	mutations := []*spanner.Mutation{rejectionMutation}
+	if transfer == nil {
+		_, err = s.client.Apply(ctx, mutations)
+		return err
 	}
+
 	mutation, err := spanner.InsertStruct("transfers", transfer)
 	if err != nil {
 		return err
 	}
-	_, err = Spanner(ctx).Apply(ctx, []*spanner.Mutation{mutation})
+	mutations = append(mutations, mutation)
+	if number.FromString(transfer.Fee).Sign() > 0 {
+		feeMutation, err := spanner.InsertStruct("fees", makeRefundFee(transfer))
+		if err != nil {
+			return err
+		}
+		mutations = append(mutations, feeMutation)
+	}
+	_, err = s.client.Apply(ctx, mutations)
 	return err
 }
+
+// settledIn tells whether the trade is the one settled in the asset, each
+// match has an ask trade settled in the quote and a bid trade in the base, and
+// the maker trade is also settled in the asset of its rebate.
+func (t *Trade) settledIn(assetId string) bool {
+	if t.Side == engine.PageSideAsk && t.QuoteAssetId == assetId {
+		return true
+	}
+	if t.Side == engine.PageSideBid && t.BaseAssetId == assetId {
+		return true
+	}
+	return t.FeeAssetId == assetId && number.FromString(t.FeeAmount).Sign() < 0
+}
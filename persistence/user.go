@@ -7,9 +7,9 @@ import (
 	"fmt"
 	"sort"
 	"strings"
-	"time"
 
 	"cloud.google.com/go/spanner"
+	"github.com/MixinNetwork/ocean.one/engine"
 	"github.com/gofrs/uuid/v5"
 	"github.com/golang-jwt/jwt"
 	"google.golang.org/api/iterator"
@@ -20,17 +20,8 @@ type User struct {
 	PublicKey string
 }
 
-func UpdateUserPublicKey(ctx context.Context, userId, publicKey string) error {
-	pkix, err := hex.DecodeString(publicKey)
-	if err != nil {
-		return nil
-	}
-	_, err = x509.ParsePKIXPublicKey(pkix)
-	if err != nil {
-		return nil
-	}
-
-	_, err = Spanner(ctx).ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+func (s *SpannerStore) UpdateUserPublicKey(ctx context.Context, userId, publicKey string) error {
+	_, err := s.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
 		it := txn.ReadUsingIndex(ctx, "users", "users_by_public_key", spanner.Key{publicKey}, []string{"user_id"})
 		defer it.Stop()
 
@@ -51,8 +42,24 @@ func UpdateUserPublicKey(ctx context.Context, userId, publicKey string) error {
 	return err
 }
 
+func (s *SpannerStore) ReadUserPublicKey(ctx context.Context, userId string) (string, error) {
+	it := s.client.Single().Read(ctx, "users", spanner.Key{userId}, []string{"public_key"})
+	defer it.Stop()
+
+	row, err := it.Next()
+	if err == iterator.Done {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	var publicKey string
+	err = row.Columns(&publicKey)
+	return publicKey, err
+}
+
 func Authenticate(ctx context.Context, jwtToken string) (string, error) {
 	var userId string
+	var readErr error
 	token, err := jwt.Parse(jwtToken, func(token *jwt.Token) (interface{}, error) {
 		claims, ok := token.Claims.(jwt.MapClaims)
 		if !ok {
@@ -72,18 +79,12 @@ func Authenticate(ctx context.Context, jwtToken string) (string, error) {
 			userId = id.String()
 		}
 
-		it := Spanner(ctx).Single().Read(ctx, "users", spanner.Key{userId}, []string{"public_key"})
-		defer it.Stop()
-		row, err := it.Next()
-		if err == iterator.Done {
-			return nil, nil
-		} else if err != nil {
-			return nil, err
-		}
-		var publicKey string
-		err = row.Columns(&publicKey)
+		publicKey, err := Backend(ctx).ReadUserPublicKey(ctx, userId)
 		if err != nil {
+			readErr = err
 			return nil, err
+		} else if publicKey == "" {
+			return nil, nil
 		}
 
 		pkix, err := hex.DecodeString(publicKey)
@@ -93,8 +94,8 @@ func Authenticate(ctx context.Context, jwtToken string) (string, error) {
 		return x509.ParsePKIXPublicKey(pkix)
 	})
 
-	if err != nil && strings.Contains(err.Error(), "spanner") {
-		return "", err
+	if readErr != nil {
+		return "", readErr
 	}
 	if err == nil && token.Valid {
 		return userId, nil
@@ -102,8 +103,8 @@ func Authenticate(ctx context.Context, jwtToken string) (string, error) {
 	return "", nil
 }
 
-func UserOrder(ctx context.Context, orderId, userId string) (*Order, error) {
-	it := Spanner(ctx).Single().Query(ctx, spanner.Statement{
+func (s *SpannerStore) UserOrder(ctx context.Context, orderId, userId string) (*Order, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{
 		SQL:    "SELECT * FROM orders WHERE order_id=@order_id AND user_id=@user_id",
 		Params: map[string]interface{}{"order_id": orderId, "user_id": userId},
 	})
@@ -120,31 +121,26 @@ func UserOrder(ctx context.Context, orderId, userId string) (*Order, error) {
 	return &o, err
 }
 
-func UserOrders(ctx context.Context, userId string, market, state string, offset time.Time, order string, limit int) ([]*Order, error) {
-	txn := Spanner(ctx).ReadOnlyTransaction()
+func (s *SpannerStore) UserOrders(ctx context.Context, userId, base, quote, state string, cursor *Cursor, order string, limit int) ([]*Order, *Cursor, error) {
+	txn := s.client.ReadOnlyTransaction()
 	defer txn.Close()
 
-	if limit > 100 {
-		limit = 100
-	}
-	cmp := "<"
-	if order != "DESC" {
-		order = "ASC"
-		cmp = ">"
-	}
-
-	base, quote := getBaseQuote(market)
-	query := "SELECT order_id FROM orders@{FORCE_INDEX=orders_by_user_state_created_%s} WHERE user_id=@user_id AND created_at%s=@offset AND state=@state"
-	query = fmt.Sprintf(query, strings.ToLower(order), cmp)
-	params := map[string]interface{}{"user_id": userId, "offset": offset, "state": state}
+	query := "SELECT order_id FROM orders@{FORCE_INDEX=orders_by_user_state_created_id_%s} WHERE user_id=@user_id AND state=@state"
+	query = fmt.Sprintf(query, strings.ToLower(order))
+	params := map[string]interface{}{"user_id": userId, "state": state}
 	if base != "" && quote != "" {
 		query = query + " AND base_asset_id=@base AND quote_asset_id=@quote"
 		params["base"], params["quote"] = base, quote
 	}
-	query = query + " ORDER BY user_id,state,created_at " + order
-	query = fmt.Sprintf("%s LIMIT %d", query, limit)
+	if cond, cp := cursor.condition("order_id", "", order); cond != "" {
+		query = query + " AND " + cond
+		for k, v := range cp {
+			params[k] = v
+		}
+	}
+	query = query + fmt.Sprintf(" ORDER BY user_id,state,created_at %s,order_id %s LIMIT %d", order, order, limit)
 
-	iit := txn.Query(ctx, spanner.Statement{query, params})
+	iit := txn.Query(ctx, spanner.Statement{SQL: query, Params: params})
 	defer iit.Stop()
 
 	var orderIds []string
@@ -153,12 +149,12 @@ func UserOrders(ctx context.Context, userId string, market, state string, offset
 		if err == iterator.Done {
 			break
 		} else if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		var id string
 		err = row.Columns(&id)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		orderIds = append(orderIds, id)
 	}
@@ -175,19 +171,126 @@ func UserOrders(ctx context.Context, userId string, market, state string, offset
 		if err == iterator.Done {
 			break
 		} else if err != nil {
-			return orders, err
+			return nil, nil, err
 		}
 		var o Order
 		err = row.ToStruct(&o)
 		if err != nil {
-			return orders, err
+			return nil, nil, err
 		}
 		orders = append(orders, &o)
 	}
-	if order == "DESC" {
-		sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.After(orders[j].CreatedAt) })
-	} else {
-		sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.Before(orders[j].CreatedAt) })
+	sort.Slice(orders, func(i, j int) bool {
+		a, b := orders[i], orders[j]
+		if order == "DESC" {
+			a, b = b, a
+		}
+		if a.CreatedAt.Equal(b.CreatedAt) {
+			return a.OrderId < b.OrderId
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+	if len(orderIds) < limit || len(orders) == 0 {
+		return orders, nil, nil
+	}
+	last := orders[len(orders)-1]
+	return orders, &Cursor{CreatedAt: last.CreatedAt, Id: last.OrderId}, nil
+}
+
+func (s *SpannerStore) UserOrderTrades(ctx context.Context, o *Order, cursor *Cursor, limit int) ([]*Trade, *Cursor, error) {
+	column := "ask_order_id"
+	if o.Side == engine.PageSideBid {
+		column = "bid_order_id"
+	}
+	query := "SELECT * FROM trades@{FORCE_INDEX=trades_by_%s_created} WHERE %s=@order_id AND side=@side"
+	query = fmt.Sprintf(query, strings.TrimSuffix(column, "_id"), column)
+	params := map[string]interface{}{"order_id": o.OrderId, "side": o.Side}
+	if cond, cp := cursor.condition("trade_id", "", "ASC"); cond != "" {
+		query = query + " AND " + cond
+		for k, v := range cp {
+			params[k] = v
+		}
+	}
+	query = query + fmt.Sprintf(" ORDER BY %s,created_at,trade_id LIMIT %d", column, limit)
+
+	it := s.client.Single().Query(ctx, spanner.Statement{SQL: query, Params: params})
+	defer it.Stop()
+
+	var trades []*Trade
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		var t Trade
+		err = row.ToStruct(&t)
+		if err != nil {
+			return nil, nil, err
+		}
+		trades = append(trades, &t)
+	}
+	if len(trades) < limit {
+		return trades, nil, nil
+	}
+	last := trades[len(trades)-1]
+	return trades, &Cursor{CreatedAt: last.CreatedAt, Id: last.TradeId}, nil
+}
+
+func (s *SpannerStore) UserTrades(ctx context.Context, userId string, filter *TradeFilter, cursor *Cursor, order string, limit int) ([]*Trade, *Cursor, error) {
+	query := "SELECT * FROM trades@{FORCE_INDEX=trades_by_user_created_liquidity_%s} WHERE user_id=@user_id"
+	query = fmt.Sprintf(query, strings.ToLower(order))
+	params := map[string]interface{}{"user_id": userId}
+	if filter.Base != "" && filter.Quote != "" {
+		query = query + " AND base_asset_id=@base AND quote_asset_id=@quote"
+		params["base"], params["quote"] = filter.Base, filter.Quote
+	}
+	if filter.Side != "" {
+		query = query + " AND side=@side"
+		params["side"] = filter.Side
+	}
+	if filter.Liquidity != "" {
+		query = query + " AND liquidity=@liquidity"
+		params["liquidity"] = filter.Liquidity
+	}
+	if !filter.Start.IsZero() {
+		query = query + " AND created_at>=@start"
+		params["start"] = filter.Start
+	}
+	if !filter.End.IsZero() {
+		query = query + " AND created_at<@end"
+		params["end"] = filter.End
+	}
+	if cond, cp := cursor.condition("trade_id", "liquidity", order); cond != "" {
+		query = query + " AND " + cond
+		for k, v := range cp {
+			params[k] = v
+		}
+	}
+	query = query + fmt.Sprintf(" ORDER BY user_id,created_at %s,trade_id %s,liquidity %s LIMIT %d", order, order, order, limit)
+
+	it := s.client.Single().Query(ctx, spanner.Statement{SQL: query, Params: params})
+	defer it.Stop()
+
+	var trades []*Trade
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		var t Trade
+		err = row.ToStruct(&t)
+		if err != nil {
+			return nil, nil, err
+		}
+		trades = append(trades, &t)
+	}
+	if len(trades) < limit {
+		return trades, nil, nil
 	}
-	return orders, nil
+	last := trades[len(trades)-1]
+	return trades, &Cursor{CreatedAt: last.CreatedAt, Id: last.TradeId, Tiebreak: last.Liquidity}, nil
 }
@@ -0,0 +1,177 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/config"
+	"github.com/MixinNetwork/ocean.one/engine"
+	"github.com/gofrs/uuid/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserOrderTrades(t *testing.T) {
+	assert := assert.New(t)
+	ctx := testSQLiteContext(t)
+
+	maker := testPlaceOrder(ctx, t, "maker", engine.PageSideAsk, "base", "quote")
+	first := testPlaceOrder(ctx, t, "taker", engine.PageSideBid, "base", "quote")
+	second := testPlaceOrder(ctx, t, "taker", engine.PageSideBid, "base", "quote")
+	idle := testPlaceOrder(ctx, t, "maker", engine.PageSideAsk, "base", "quote")
+	testMatch(ctx, t, first, maker)
+	testMatch(ctx, t, second, maker)
+
+	// the order is only read by its user before its trades are listed
+	o, err := UserOrder(ctx, maker.Id, "taker")
+	assert.Nil(err)
+	assert.Nil(o)
+	o, err = UserOrder(ctx, maker.Id, "maker")
+	assert.Nil(err)
+	trades, cursor, err := UserOrderTrades(ctx, o, nil, 1)
+	assert.Nil(err)
+	assert.Len(trades, 1)
+	assert.Equal(maker.Id, trades[0].AskOrderId)
+	assert.Equal(engine.PageSideAsk, trades[0].Side)
+	assert.Equal(TradeLiquidityMaker, trades[0].Liquidity)
+	assert.NotNil(cursor)
+	next, cursor, err := UserOrderTrades(ctx, o, cursor, 1)
+	assert.Nil(err)
+	assert.Len(next, 1)
+	assert.NotEqual(trades[0].TradeId, next[0].TradeId)
+	assert.NotNil(cursor)
+	trades, cursor, err = UserOrderTrades(ctx, o, cursor, 1)
+	assert.Nil(err)
+	assert.Len(trades, 0)
+	assert.Nil(cursor)
+
+	o, err = UserOrder(ctx, first.Id, "taker")
+	assert.Nil(err)
+	trades, _, err = UserOrderTrades(ctx, o, nil, 10)
+	assert.Nil(err)
+	assert.Len(trades, 1)
+	assert.Equal(engine.PageSideBid, trades[0].Side)
+	assert.Equal(TradeLiquidityTaker, trades[0].Liquidity)
+
+	// an order without trades has an empty list
+	o, err = UserOrder(ctx, idle.Id, "maker")
+	assert.Nil(err)
+	trades, cursor, err = UserOrderTrades(ctx, o, nil, 10)
+	assert.Nil(err)
+	assert.Len(trades, 0)
+	assert.Nil(cursor)
+}
+
+func TestUserTrades(t *testing.T) {
+	assert := assert.New(t)
+	ctx := testSQLiteContext(t)
+
+	btc, xin, usdt := config.BitcoinAssetId, config.MixinAssetId, config.ERC20USDTAssetId
+	maker := testPlaceOrder(ctx, t, "maker", engine.PageSideAsk, btc, usdt)
+	testMatch(ctx, t, testPlaceOrder(ctx, t, "trader", engine.PageSideBid, btc, usdt), maker)
+	testMatch(ctx, t, testPlaceOrder(ctx, t, "trader", engine.PageSideBid, btc, usdt), maker)
+	testMatch(ctx, t, testPlaceOrder(ctx, t, "taker", engine.PageSideBid, xin, usdt), testPlaceOrder(ctx, t, "trader", engine.PageSideAsk, xin, usdt))
+
+	all, cursor, err := UserTrades(ctx, "trader", "", "X", "", time.Time{}, time.Time{}, nil, "ASC", 10)
+	assert.Nil(err)
+	assert.Len(all, 3)
+	assert.Nil(cursor)
+
+	trades, _, err := UserTrades(ctx, "trader", btc+"-"+usdt, "", "", time.Time{}, time.Time{}, nil, "ASC", 10)
+	assert.Nil(err)
+	assert.Len(trades, 2)
+	for _, trade := range trades {
+		assert.Equal(btc, trade.BaseAssetId)
+		assert.Equal(engine.PageSideBid, trade.Side)
+		assert.Equal("trader", trade.UserId)
+	}
+	trades, _, err = UserTrades(ctx, "trader", "", engine.PageSideAsk, "", time.Time{}, time.Time{}, nil, "ASC", 10)
+	assert.Nil(err)
+	assert.Len(trades, 1)
+	assert.Equal(xin, trades[0].BaseAssetId)
+	assert.Equal(TradeLiquidityMaker, trades[0].Liquidity)
+	trades, _, err = UserTrades(ctx, "trader", btc+"-"+usdt, engine.PageSideAsk, "", time.Time{}, time.Time{}, nil, "ASC", 10)
+	assert.Nil(err)
+	assert.Len(trades, 0)
+
+	// the pages continue after the cursor in either order
+	trades, cursor, err = UserTrades(ctx, "trader", "", "", "", time.Time{}, time.Time{}, nil, "ASC", 2)
+	assert.Nil(err)
+	assert.Len(trades, 2)
+	assert.Equal(all[0].TradeId, trades[0].TradeId)
+	assert.NotNil(cursor)
+	trades, cursor, err = UserTrades(ctx, "trader", "", "", "", time.Time{}, time.Time{}, cursor, "ASC", 2)
+	assert.Nil(err)
+	assert.Len(trades, 1)
+	assert.Equal(all[2].TradeId, trades[0].TradeId)
+	assert.Nil(cursor)
+	trades, cursor, err = UserTrades(ctx, "trader", "", "", "", time.Time{}, time.Time{}, nil, "DESC", 1)
+	assert.Nil(err)
+	assert.Equal(all[2].TradeId, trades[0].TradeId)
+	trades, _, err = UserTrades(ctx, "trader", "", "", "", time.Time{}, time.Time{}, cursor, "DESC", 10)
+	assert.Nil(err)
+	assert.Len(trades, 2)
+	assert.Equal(all[1].TradeId, trades[0].TradeId)
+	assert.Equal(all[0].TradeId, trades[1].TradeId)
+}
+
+func TestUserSelfTrades(t *testing.T) {
+	assert := assert.New(t)
+	ctx := testSQLiteContext(t)
+
+	// both trades of a self-trade have the same created_at and trade_id
+	btc, usdt := config.BitcoinAssetId, config.ERC20USDTAssetId
+	testMatch(ctx, t, testPlaceOrder(ctx, t, "self", engine.PageSideBid, btc, usdt), testPlaceOrder(ctx, t, "self", engine.PageSideAsk, btc, usdt))
+
+	for _, order := range []string{"ASC", "DESC"} {
+		first, cursor, err := UserTrades(ctx, "self", "", "", "", time.Time{}, time.Time{}, nil, order, 1)
+		assert.Nil(err)
+		assert.Len(first, 1)
+		assert.NotNil(cursor)
+		second, cursor, err := UserTrades(ctx, "self", "", "", "", time.Time{}, time.Time{}, ParseCursor(cursor.String()), order, 1)
+		assert.Nil(err)
+		assert.Len(second, 1)
+		assert.Equal(first[0].TradeId, second[0].TradeId)
+		assert.NotEqual(first[0].Liquidity, second[0].Liquidity)
+		trades, cursor, err := UserTrades(ctx, "self", "", "", "", time.Time{}, time.Time{}, cursor, order, 1)
+		assert.Nil(err)
+		assert.Len(trades, 0)
+		assert.Nil(cursor)
+	}
+}
+
+func testPlaceOrder(ctx context.Context, t *testing.T, userId, side, base, quote string) *engine.Order {
+	id, _ := uuid.NewV4()
+	o := &engine.Order{
+		Id:              id.String(),
+		Side:            side,
+		Type:            engine.OrderTypeLimit,
+		Price:           number.FromString("10").Integer(4),
+		RemainingAmount: number.NewInteger(0, 8),
+		FilledAmount:    number.NewInteger(0, 8),
+		RemainingFunds:  number.NewInteger(0, 12),
+		FilledFunds:     number.NewInteger(0, 12),
+		Quote:           quote,
+		Base:            base,
+		UserId:          userId,
+		BrokerId:        "broker",
+	}
+	if side == engine.PageSideAsk {
+		o.RemainingAmount = number.FromString("2").Integer(8)
+	} else {
+		o.RemainingFunds = number.FromString("10").Integer(12)
+	}
+	err := CreateOrderAction(ctx, o, userId, o.BrokerId, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func testMatch(ctx context.Context, t *testing.T, taker, maker *engine.Order) {
+	_, _, err := Transact(ctx, taker, maker, number.FromString("1").Integer(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"math"
 	"net/http"
 	"sort"
 	"strconv"
@@ -17,6 +19,7 @@ import (
 	"github.com/MixinNetwork/go-number"
 	"github.com/MixinNetwork/ocean.one/cache"
 	"github.com/MixinNetwork/ocean.one/config"
+	"github.com/MixinNetwork/ocean.one/engine"
 	"github.com/MixinNetwork/ocean.one/persistence"
 	"github.com/dimfeld/httptreemux"
 	"github.com/golang-jwt/jwt"
@@ -29,12 +32,22 @@ func NewRouter() *httptreemux.TreeMux {
 	router, impl := httptreemux.New(), &R{}
 	router.GET("/assets", impl.assets)
 	router.GET("/brokers", impl.brokers)
+	router.GET("/brokers/:id/revenues", impl.brokerRevenues)
+	router.GET("/markets/tickers", impl.marketTickers)
 	router.GET("/markets/:id/ticker", impl.marketTicker)
 	router.GET("/markets/:id/book", impl.marketBook)
 	router.GET("/markets/:id/trades", impl.marketTrades)
+	router.GET("/markets/:id/candles/:granularity", impl.marketCandles)
+	router.GET("/trades", impl.trades)
 	router.GET("/orders", impl.orders)
 	router.GET("/orders/:id", impl.order)
+	router.GET("/orders/:id/trades", impl.orderTrades)
+	router.GET("/rejections/:id", impl.rejection)
+	router.GET("/fees/balance", impl.feeBalance)
 	router.POST("/tokens", impl.tokens)
+	router.GET("/admin/fees", admin(impl.adminFees))
+	router.GET("/admin/markets/:id/events", admin(impl.adminMarketEvents))
+	router.GET("/admin/markets/:id/book", admin(impl.adminMarketBook))
 	registerHanders(router)
 	return router
 }
@@ -104,6 +117,45 @@ func (impl *R) brokers(w http.ResponseWriter, r *http.Request, _ map[string]stri
 	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data})
 }
 
+func (impl *R) brokerRevenues(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		render.New().JSON(w, http.StatusUnauthorized, map[string]interface{}{})
+		return
+	}
+	brokerId, err := persistence.AuthenticateBroker(r.Context(), header[7:], r.Method, r.URL.Path)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if brokerId == "" || brokerId != params["id"] {
+		render.New().JSON(w, http.StatusUnauthorized, map[string]interface{}{})
+		return
+	}
+
+	accrued, paid, err := persistence.ReadBrokerRevenues(r.Context(), brokerId)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	assets := make(map[string]map[string]string)
+	for id, amount := range accrued {
+		assets[id] = map[string]string{"asset_id": id, "accrued": amount.Persist(), "paid": "0"}
+	}
+	for id, amount := range paid {
+		if assets[id] == nil {
+			assets[id] = map[string]string{"asset_id": id, "accrued": "0"}
+		}
+		assets[id]["paid"] = amount.Persist()
+	}
+	data := make([]map[string]string, 0)
+	for _, a := range assets {
+		data = append(data, a)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i]["asset_id"] < data[j]["asset_id"] })
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
 func (impl *R) tokens(w http.ResponseWriter, r *http.Request, _ map[string]string) {
 	var body struct {
 		URI string `json:"uri"`
@@ -150,19 +202,64 @@ func (impl *R) marketTicker(w http.ResponseWriter, r *http.Request, params map[s
 		render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{}})
 		return
 	}
-	b, err := cache.Book(r.Context(), params["id"], 1)
+	tickers, err := cache.Tickers(r.Context(), params["id"])
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	stats := &cache.Ticker{Market: params["id"], Open: t.Price, High: t.Price, Low: t.Price, Close: t.Price, Volume: "0", QuoteVolume: "0", Change: "0"}
+	if len(tickers) > 0 {
+		stats = tickers[0]
+	}
+	ticker, err := marketTickerWithBook(r.Context(), stats)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	ticker["trade_id"] = t.TradeId
+	ticker["amount"] = t.Amount
+	ticker["price"] = t.Price
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": ticker})
+}
+
+func (impl *R) marketTickers(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	tickers, err := cache.Tickers(r.Context())
 	if err != nil {
 		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
 		return
 	}
+	data := make([]map[string]interface{}, 0)
+	for _, stats := range tickers {
+		ticker, err := marketTickerWithBook(r.Context(), stats)
+		if err != nil {
+			render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		ticker["price"] = stats.Close
+		data = append(data, ticker)
+	}
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func marketTickerWithBook(ctx context.Context, stats *cache.Ticker) (map[string]interface{}, error) {
+	b, err := cache.Book(ctx, stats.Market, 1)
+	if err != nil {
+		return nil, err
+	}
 	ticker := map[string]interface{}{
-		"trade_id":  t.TradeId,
-		"amount":    t.Amount,
-		"price":     t.Price,
-		"sequence":  b.Sequence,
-		"timestamp": b.Timestamp,
-		"ask":       "0",
-		"bid":       "0",
+		"market":       stats.Market,
+		"sequence":     b.Sequence,
+		"timestamp":    b.Timestamp,
+		"ask":          "0",
+		"bid":          "0",
+		"open":         stats.Open,
+		"high":         stats.High,
+		"low":          stats.Low,
+		"close":        stats.Close,
+		"volume":       stats.Volume,
+		"quote_volume": stats.QuoteVolume,
+		"change":       stats.Change,
+		"count":        stats.Count,
 	}
 	data, _ := json.Marshal(b.Data)
 	var best struct {
@@ -180,7 +277,7 @@ func (impl *R) marketTicker(w http.ResponseWriter, r *http.Request, params map[s
 	if len(best.Bids) > 0 {
 		ticker["bid"] = best.Bids[0].Price
 	}
-	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": ticker})
+	return ticker, nil
 }
 
 func (impl *R) marketBook(w http.ResponseWriter, r *http.Request, params map[string]string) {
@@ -192,11 +289,26 @@ func (impl *R) marketBook(w http.ResponseWriter, r *http.Request, params map[str
 	}
 }
 
+func (impl *R) marketCandles(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	granularity, _ := strconv.ParseInt(params["granularity"], 10, 64)
+	limit, _ := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64)
+	candles, err := persistence.MarketCandles(r.Context(), params["id"], granularity, limit)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	data := make([][]interface{}, 0)
+	for _, c := range candles {
+		data = append(data, []interface{}{c.Point, c.Low, c.High, c.Open, c.Close, c.Volume, c.Total})
+	}
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
 func (impl *R) marketTrades(w http.ResponseWriter, r *http.Request, params map[string]string) {
 	order := r.URL.Query().Get("order")
 	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-	offset, _ := time.Parse(time.RFC3339Nano, r.URL.Query().Get("offset"))
-	trades, err := persistence.MarketTrades(r.Context(), params["id"], offset, order, limit)
+	trades, next, err := persistence.MarketTrades(r.Context(), params["id"], listCursor(r), order, limit)
 	if err != nil {
 		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
 		return
@@ -204,6 +316,10 @@ func (impl *R) marketTrades(w http.ResponseWriter, r *http.Request, params map[s
 
 	data := make([]map[string]interface{}, 0)
 	for _, t := range trades {
+		takerSide := engine.PageSideBid
+		if t.Side == engine.PageSideBid {
+			takerSide = engine.PageSideAsk
+		}
 		data = append(data, map[string]interface{}{
 			"trade_id":     t.TradeId,
 			"base":         t.BaseAssetId,
@@ -211,12 +327,54 @@ func (impl *R) marketTrades(w http.ResponseWriter, r *http.Request, params map[s
 			"bid_order_id": t.BidOrderId,
 			"ask_order_id": t.AskOrderId,
 			"side":         t.Side,
+			"taker_side":   takerSide,
 			"price":        t.Price,
 			"amount":       t.Amount,
 			"created_at":   t.CreatedAt,
 		})
 	}
-	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data})
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data, "next_cursor": next.String()})
+}
+
+func (impl *R) trades(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	userId, err := authenticateUser(r)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if userId == "" {
+		render.New().JSON(w, http.StatusUnauthorized, map[string]interface{}{})
+		return
+	}
+
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	start, _ := time.Parse(time.RFC3339Nano, query.Get("start"))
+	end, _ := time.Parse(time.RFC3339Nano, query.Get("end"))
+	trades, next, err := persistence.UserTrades(r.Context(), userId, query.Get("market"), query.Get("side"), query.Get("liquidity"), start, end, listCursor(r), query.Get("order"), limit)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	data := make([]map[string]interface{}, 0)
+	for _, t := range trades {
+		data = append(data, map[string]interface{}{
+			"trade_id":     t.TradeId,
+			"base":         t.BaseAssetId,
+			"quote":        t.QuoteAssetId,
+			"bid_order_id": t.BidOrderId,
+			"ask_order_id": t.AskOrderId,
+			"side":         t.Side,
+			"liquidity":    t.Liquidity,
+			"price":        t.Price,
+			"amount":       t.Amount,
+			"fee_asset_id": t.FeeAssetId,
+			"fee_amount":   t.FeeAmount,
+			"created_at":   t.CreatedAt,
+		})
+	}
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data, "next_cursor": next.String()})
 }
 
 func (impl *R) orders(w http.ResponseWriter, r *http.Request, params map[string]string) {
@@ -234,8 +392,7 @@ func (impl *R) orders(w http.ResponseWriter, r *http.Request, params map[string]
 	order := r.URL.Query().Get("order")
 	state := r.URL.Query().Get("state")
 	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-	offset, _ := time.Parse(time.RFC3339Nano, r.URL.Query().Get("offset"))
-	orders, err := persistence.UserOrders(r.Context(), userId, market, state, offset, order, limit)
+	orders, next, err := persistence.UserOrders(r.Context(), userId, market, state, listCursor(r), order, limit)
 	if err != nil {
 		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
 		return
@@ -258,7 +415,7 @@ func (impl *R) orders(w http.ResponseWriter, r *http.Request, params map[string]
 			"created_at":       o.CreatedAt,
 		})
 	}
-	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data})
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data, "next_cursor": next.String()})
 }
 
 func (impl *R) order(w http.ResponseWriter, r *http.Request, params map[string]string) {
@@ -299,6 +456,217 @@ func (impl *R) order(w http.ResponseWriter, r *http.Request, params map[string]s
 	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data})
 }
 
+func (impl *R) orderTrades(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	userId, err := authenticateUser(r)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if userId == "" {
+		render.New().JSON(w, http.StatusUnauthorized, map[string]interface{}{})
+		return
+	}
+
+	o, err := persistence.UserOrder(r.Context(), params["id"], userId)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if o == nil {
+		render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": []interface{}{}, "next_cursor": ""})
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	trades, next, err := persistence.UserOrderTrades(r.Context(), o, listCursor(r), limit)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	data := make([]map[string]interface{}, 0)
+	for _, t := range trades {
+		data = append(data, map[string]interface{}{
+			"trade_id":     t.TradeId,
+			"base":         t.BaseAssetId,
+			"quote":        t.QuoteAssetId,
+			"bid_order_id": t.BidOrderId,
+			"ask_order_id": t.AskOrderId,
+			"side":         t.Side,
+			"liquidity":    t.Liquidity,
+			"price":        t.Price,
+			"amount":       t.Amount,
+			"fee_asset_id": t.FeeAssetId,
+			"fee_amount":   t.FeeAmount,
+			"created_at":   t.CreatedAt,
+		})
+	}
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data, "next_cursor": next.String()})
+}
+
+func (impl *R) rejection(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	userId, err := authenticateUser(r)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if userId == "" {
+		render.New().JSON(w, http.StatusUnauthorized, map[string]interface{}{})
+		return
+	}
+
+	rj, err := persistence.UserRejection(r.Context(), params["id"], userId)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if rj == nil {
+		render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{}})
+		return
+	}
+
+	data := map[string]interface{}{
+		"trace_id":   rj.TraceId,
+		"asset_id":   rj.AssetId,
+		"amount":     rj.Amount,
+		"reason":     rj.Reason,
+		"created_at": rj.CreatedAt,
+	}
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (impl *R) feeBalance(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	userId, err := authenticateUser(r)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if userId == "" {
+		render.New().JSON(w, http.StatusUnauthorized, map[string]interface{}{})
+		return
+	}
+
+	b, err := persistence.ReadFeeBalance(r.Context(), userId)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	data := map[string]interface{}{
+		"asset_id": persistence.FeeDiscountAssetId,
+		"balance":  "0",
+		"enabled":  false,
+		"discount": persistence.FeeDiscountRate,
+	}
+	if b != nil {
+		data["asset_id"] = b.AssetId
+		data["balance"] = b.Balance
+		data["enabled"] = b.Enabled
+	}
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (impl *R) adminFees(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	end, err := time.Parse("2006-01-02", r.URL.Query().Get("end"))
+	if err != nil {
+		end = time.Now().UTC()
+	}
+	start, err := time.Parse("2006-01-02", r.URL.Query().Get("start"))
+	if err != nil {
+		start = end.AddDate(0, 0, -30)
+	}
+	period := strings.ToUpper(r.URL.Query().Get("period"))
+	if period != persistence.FeePeriodMonth {
+		period = persistence.FeePeriodDay
+	}
+	byMarket := r.URL.Query().Get("group") == "market"
+	revenues, err := persistence.AggregateFeeRevenues(r.Context(), start, end, period, byMarket)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": revenues})
+}
+
+func (impl *R) adminMarketEvents(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	journal := cache.Journal(r.Context())
+	if journal == nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "event journal not configured"})
+		return
+	}
+
+	query := r.URL.Query()
+	from, _ := strconv.ParseInt(query.Get("from"), 10, 64)
+	to, err := strconv.ParseInt(query.Get("to"), 10, 64)
+	if err != nil {
+		to = math.MaxInt64
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit > 1000 || limit <= 0 {
+		limit = 100
+	}
+	events, err := journal.Range(r.Context(), params["id"], from, to, limit)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if events == nil {
+		events = make([]*cache.Event, 0)
+	}
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": events})
+}
+
+func (impl *R) adminMarketBook(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	sequence, err := strconv.ParseInt(r.URL.Query().Get("sequence"), 10, 64)
+	if err != nil {
+		render.New().JSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid sequence"})
+		return
+	}
+	book, err := cache.RebuildBook(r.Context(), params["id"], sequence)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if book == nil {
+		render.New().JSON(w, http.StatusNotFound, map[string]interface{}{})
+		return
+	}
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+		"market":   book.Market,
+		"sequence": fmt.Sprint(sequence),
+		"asks":     book.Asks(),
+		"bids":     book.Bids(),
+		"checksum": book.Checksum(),
+	}})
+}
+
+// admin authorizes the handler to the users in config.AdminUserIds only.
+func admin(handler httptreemux.HandlerFunc) httptreemux.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		userId, err := authenticateUser(r)
+		if err != nil {
+			render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		if !isAdmin(userId) {
+			render.New().JSON(w, http.StatusUnauthorized, map[string]interface{}{})
+			return
+		}
+		handler(w, r, params)
+	}
+}
+
+func isAdmin(userId string) bool {
+	if userId == "" {
+		return false
+	}
+	for _, id := range strings.Split(config.AdminUserIds, ",") {
+		if strings.TrimSpace(id) == userId {
+			return true
+		}
+	}
+	return false
+}
+
 func authenticateUser(r *http.Request) (string, error) {
 	header := r.Header.Get("Authorization")
 	if !strings.HasPrefix(header, "Bearer ") {
@@ -307,6 +675,20 @@ func authenticateUser(r *http.Request) (string, error) {
 	return persistence.Authenticate(r.Context(), header[7:])
 }
 
+// listCursor reads the cursor query param, the legacy offset timestamp is
+// still accepted as a cursor without id.
+func listCursor(r *http.Request) *persistence.Cursor {
+	query := r.URL.Query()
+	if cursor := persistence.ParseCursor(query.Get("cursor")); cursor != nil {
+		return cursor
+	}
+	offset, err := time.Parse(time.RFC3339Nano, query.Get("offset"))
+	if err != nil {
+		return nil
+	}
+	return &persistence.Cursor{CreatedAt: offset}
+}
+
 func registerHanders(router *httptreemux.TreeMux) {
 	router.MethodNotAllowedHandler = func(w http.ResponseWriter, r *http.Request, _ map[string]httptreemux.HandlerFunc) {
 		render.New().JSON(w, http.StatusNotFound, map[string]interface{}{})
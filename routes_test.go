@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/MixinNetwork/ocean.one/cache"
+	"github.com/MixinNetwork/ocean.one/config"
+	"github.com/MixinNetwork/ocean.one/persistence"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmin(t *testing.T) {
+	assert := assert.New(t)
+	store, err := persistence.NewSQLiteStore(filepath.Join(t.TempDir(), "ocean.db"))
+	assert.Nil(err)
+	ctx := persistence.SetupBackend(context.Background(), store)
+
+	router := NewRouter()
+	request := func(path, userId string) int {
+		r := httptest.NewRequest("GET", path, nil).WithContext(ctx)
+		if userId != "" {
+			r.Header.Set("Authorization", "Bearer "+testUserToken(ctx, t, userId))
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(http.StatusUnauthorized, request("/admin/fees", ""))
+	assert.Equal(http.StatusUnauthorized, request("/admin/fees", testUUID()))
+	assert.Equal(http.StatusOK, request("/admin/fees", config.ClientId))
+
+	// the journal endpoints respond an error without a journal
+	market := config.BitcoinAssetId + "-" + config.ERC20USDTAssetId
+	assert.Equal(http.StatusInternalServerError, request("/admin/markets/"+market+"/events", config.ClientId))
+	assert.Equal(http.StatusInternalServerError, request("/admin/markets/"+market+"/book?sequence=1", config.ClientId))
+	ctx = cache.SetupJournal(ctx, persistence.NewSQLJournal(store))
+	assert.Equal(http.StatusOK, request("/admin/markets/"+market+"/events", config.ClientId))
+	assert.Equal(http.StatusNotFound, request("/admin/markets/"+market+"/book?sequence=1", config.ClientId))
+}
+
+func testUserToken(ctx context.Context, t *testing.T, userId string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = persistence.UpdateUserPublicKey(ctx, userId, hex.EncodeToString(pkix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"uid": userId}).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}